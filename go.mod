@@ -7,6 +7,7 @@ require (
 	github.com/lyraproj/pcore v0.0.0-20190619162937-645af37a80ad
 	github.com/lyraproj/puppet-evaluator v0.0.0-20190620124608-a575c423de1a
 	github.com/lyraproj/puppet-parser v0.0.0-20190606112603-21687f912799
+	github.com/lyraproj/semver v0.0.0-20181213164306-02ecea2cd6a2
 	github.com/lyraproj/servicesdk v0.0.0-20190620124349-11383d404381
 	github.com/stretchr/testify v1.3.0
 	gopkg.in/check.v1 v1.0.0-20161208181325-20d25e280405 // indirect
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+// writeDoc writes reference documentation for the manifests and TypeSet files found in the paths
+// given as arguments. It returns the exit code of the process.
+func writeDoc(args []string) (exitCode int) {
+	fs := flag.NewFlagSet("doc", flag.ContinueOnError)
+	format := fs.String("format", puppetwf.MarkdownDoc, "format of the documentation, markdown or html")
+	out := fs.String("o", "", "file where the documentation is written, default is stdout")
+	modulePath := fs.String("modulepath", os.Getenv("LYRA_MODULE_PATH"), "list of directories with modules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow doc [-format markdown|html] [-o file] [-modulepath dirs] [path ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				fmt.Fprintln(os.Stderr, ri.Error())
+				exitCode = 1
+				return
+			}
+			panic(r)
+		}
+	}()
+	b := bytes.NewBufferString(``)
+	puppetwf.WriteDoc(b, *format, paths, puppetwf.WithModulePath(puppetwf.ModulePath(*modulePath)...))
+	if *out == "" {
+		_, err := os.Stdout.Write(b.Bytes())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, b.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(*out)
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+// formatManifests formats the manifests given as arguments, or found in the directories given as
+// arguments. The formatted manifests are written to stdout unless -w or -check is given. With
+// -check, nothing is written and the names of the manifests that are not formatted are listed
+// instead. It returns the exit code of the process, which with -check is 1 when some manifest is
+// not formatted.
+func formatManifests(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := fs.Bool("check", false, "list the manifests that are not formatted and fail if there are any")
+	write := fs.Bool("w", false, "write the result to the manifest instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow fmt [-check] [-w] [path ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := manifestFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	exitCode := 0
+	for _, file := range files {
+		changed, err := formatManifest(file, *check, *write)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+			continue
+		}
+		if changed && *check {
+			fmt.Println(file)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// formatManifest formats the given file and returns true if the formatted content differs from
+// the content of the file.
+func formatManifest(file string, check, write bool) (changed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				err = ri
+				return
+			}
+			panic(r)
+		}
+	}()
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	formatted := puppetwf.Format(file, content)
+	changed = !bytes.Equal(content, formatted)
+	switch {
+	case check:
+	case write:
+		if changed {
+			err = ioutil.WriteFile(file, formatted, 0644)
+		}
+	default:
+		_, err = os.Stdout.Write(formatted)
+	}
+	return changed, err
+}
+
+// manifestFiles returns the given files and the .pp files in the given directories. Hidden and
+// vendor directories are skipped.
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != root && (strings.HasPrefix(info.Name(), ".") || info.Name() == puppetwf.VendorDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".pp") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// generateHandlers writes Go structs, handler skeletons, and registration code for the TypeSet .pp
+// file given as argument. It returns the exit code of the process.
+func generateHandlers(args []string) (exitCode int) {
+	fs := flag.NewFlagSet("handlers", flag.ContinueOnError)
+	pkg := fs.String("package", "", "name of the Go package, default is the lowercased name of the TypeSet")
+	out := fs.String("o", "", "file where the Go source is written, default is stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow handlers [-package name] [-o file] file.pp")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				fmt.Fprintln(os.Stderr, ri.Error())
+				exitCode = 1
+				return
+			}
+			panic(r)
+		}
+	}()
+	b := bytes.NewBufferString(``)
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.ReadTypeSet(c, fs.Arg(0))
+		if *pkg == "" {
+			*pkg = strings.ToLower(wf.LeafName(ts.Name()))
+		}
+		puppetwf.GenerateGo(c, ts, *pkg, b)
+	})
+	if *out == "" {
+		_, err := os.Stdout.Write(b.Bytes())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, b.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(*out)
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+// lintManifests writes the warnings for the manifests found in the paths given as arguments, one
+// per line and prefixed with its code. It returns the exit code of the process, which is 1 when
+// there are warnings or a manifest cannot be loaded.
+func lintManifests(args []string) (exitCode int) {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	disable := fs.String("disable", "", "comma separated list of warning codes that are not reported")
+	modulePath := fs.String("modulepath", os.Getenv("LYRA_MODULE_PATH"), "list of directories with modules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow lint [-disable codes] [-modulepath dirs] [path ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	disabled := make(map[string]bool)
+	for _, code := range strings.Split(*disable, ",") {
+		disabled[strings.TrimSpace(code)] = true
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				fmt.Fprintln(os.Stderr, ri.Error())
+				exitCode = 1
+				return
+			}
+			panic(r)
+		}
+	}()
+	for _, w := range puppetwf.Lint(paths, puppetwf.WithModulePath(puppetwf.ModulePath(*modulePath)...)) {
+		if !disabled[string(w.Code())] {
+			fmt.Printf("%s: %s\n", w.Code(), w.Error())
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
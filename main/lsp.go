@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+// serveLanguageServer serves the Language Server Protocol on stdin and stdout. It returns the exit
+// code of the process, which is 1 when the client exits without shutting the server down first or
+// when the connection fails.
+func serveLanguageServer(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	modulePath := fs.String("modulepath", os.Getenv("LYRA_MODULE_PATH"), "list of directories with modules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow lsp [-modulepath dirs]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// The language server only analyzes manifests, so lookups, tracing, and cassettes don't apply.
+	if err := puppetwf.ServeLanguageServer(os.Stdin, os.Stdout, puppetwf.WithModulePath(puppetwf.ModulePath(*modulePath)...)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
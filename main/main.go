@@ -9,6 +9,27 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doc":
+			os.Exit(writeDoc(os.Args[2:]))
+		case "fmt":
+			os.Exit(formatManifests(os.Args[2:]))
+		case "handlers":
+			os.Exit(generateHandlers(os.Args[2:]))
+		case "lint":
+			os.Exit(lintManifests(os.Args[2:]))
+		case "lsp":
+			os.Exit(serveLanguageServer(os.Args[2:]))
+		case "schema":
+			os.Exit(importSchema(os.Args[2:]))
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		case "typeset":
+			os.Exit(generateTypeSet(os.Args[2:]))
+		}
+	}
+
 	// Configuring hclog like this allows Lyra to handle log levels automatically
 	hclog.DefaultOptions = &hclog.LoggerOptions{
 		Name:            "Puppet",
@@ -21,5 +42,26 @@ func main() {
 		// Tell issue reporting to amend all errors with a stack trace.
 		issue.IncludeStacktrace(true)
 	}
-	puppetwf.Start(`Puppet`)
+	puppetwf.Start(`Puppet`, options()...)
+}
+
+// options returns the service options given by environment variables.
+func options() []puppetwf.Option {
+	var options []puppetwf.Option
+	if lc := os.Getenv("LYRA_LOOKUP_CONFIG"); lc != "" {
+		options = append(options, puppetwf.WithLookupConfig(lc))
+	}
+	if tf := os.Getenv("LYRA_TRACE_FILE"); tf != "" {
+		options = append(options, puppetwf.WithTraceFile(tf))
+	}
+	if rc := os.Getenv("LYRA_RECORD_CASSETTE"); rc != "" {
+		options = append(options, puppetwf.WithRecording(rc))
+	}
+	if pc := os.Getenv("LYRA_REPLAY_CASSETTE"); pc != "" {
+		options = append(options, puppetwf.WithReplay(pc))
+	}
+	if mp := os.Getenv("LYRA_MODULE_PATH"); mp != "" {
+		options = append(options, puppetwf.WithModulePath(puppetwf.ModulePath(mp)...))
+	}
+	return options
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/lyraproj/semver/semver"
+)
+
+// importSchema writes a TypeSet .pp file for the JSON Schema or OpenAPI 3 document given as
+// argument. It returns the exit code of the process.
+func importSchema(args []string) (exitCode int) {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the TypeSet, such as Aws")
+	version := fs.String("version", "0.1.0", "version of the TypeSet")
+	dir := fs.String("dir", "types", "directory where the TypeSet file is written")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow schema -name Name [-version v] [-dir dir] file")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	v, err := semver.ParseVersion(*version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				fmt.Fprintln(os.Stderr, ri.Error())
+				exitCode = 1
+				return
+			}
+			panic(r)
+		}
+	}()
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.TypeSetFromSchema(c, *name, v, puppetwf.LoadSchema(c, fs.Arg(0)))
+		puppetwf.WriteTypeSet(ts, *dir)
+	})
+	fmt.Println(filepath.Join(*dir, filepath.Join(strings.Split(*name, "::")...)) + ".pp")
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+)
+
+// runTests runs the tests in the *_test.pp manifests found in the paths given as arguments and
+// reports the results on stdout. It returns the exit code of the process.
+func runTests(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	format := fs.String("format", "tap", "report format, tap or junit")
+	modulePath := fs.String("modulepath", os.Getenv("LYRA_MODULE_PATH"), "list of directories with modules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow test [-format tap|junit] [-modulepath dirs] [path ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	write := wftesting.WriteTAP
+	switch *format {
+	case "tap":
+	case "junit":
+		write = wftesting.WriteJUnit
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := wftesting.FindTests(paths...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var results []*wftesting.TestResult
+	for _, file := range files {
+		results = append(results, wftesting.RunTests(file, puppetwf.WithModulePath(puppetwf.ModulePath(*modulePath)...))...)
+	}
+	if err = write(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, r := range results {
+		if r.Failed() {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,20 @@
+package disks
+
+type Disk struct {
+	Name   string
+	DiskID *string `lyra:"provided"`
+}
+
+type DiskHandler struct{}
+
+func (h *DiskHandler) Create(desired *Disk) (*Disk, string, error) {
+	return desired, ``, nil
+}
+
+func (h *DiskHandler) Read(externalID string) (*Disk, error) {
+	return nil, nil
+}
+
+func (h *DiskHandler) Delete(externalID string) error {
+	return nil
+}
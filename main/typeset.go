@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/lyraproj/semver/semver"
+)
+
+var typeSetProgram = template.Must(template.New(`typeset`).Parse(`package main
+
+import (
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/lyraproj/semver/semver"
+
+	p {{printf "%q" .Package}}
+)
+
+func main() {
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.GenerateTypeSet(c, {{printf "%q" .Name}}, semver.MustParseVersion({{printf "%q" .Version}}),{{range .Types}}
+			puppetwf.Resource{Type: &p.{{.Type}}{}{{if .Handler}}, Handler: &p.{{.Handler}}{}{{end}}},{{end}}
+		)
+		puppetwf.WriteTypeSet(ts, {{printf "%q" .Dir}})
+	})
+}
+`))
+
+var errInterrupted = errors.New("interrupted")
+
+type typeSetType struct {
+	Type    string
+	Handler string
+}
+
+// generateTypeSet writes a TypeSet .pp file for Go structs in the package given as the first
+// argument. The remaining arguments name the structs, each optionally followed by a colon and the
+// name of the struct that handles it. Since the structs must be compiled, a program that uses the
+// package is generated and built using the go command. The command must therefore run in a module
+// that requires puppet-workflow and the package. It returns the exit code of the process.
+func generateTypeSet(args []string) int {
+	fs := flag.NewFlagSet("typeset", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the TypeSet, such as Aws")
+	version := fs.String("version", "0.1.0", "version of the TypeSet")
+	dir := fs.String("dir", "types", "directory where the TypeSet file is written")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puppet-workflow typeset -name Name [-version v] [-dir dir] package Type[:Handler] ...")
+		fmt.Fprintln(fs.Output(), "Must run in a module that requires puppet-workflow and the package.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" || fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := semver.ParseVersion(*version); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var tsTypes []typeSetType
+	for _, arg := range fs.Args()[1:] {
+		parts := strings.SplitN(arg, ":", 2)
+		tt := typeSetType{Type: parts[0]}
+		if len(parts) > 1 {
+			tt.Handler = parts[1]
+		}
+		tsTypes = append(tsTypes, tt)
+	}
+
+	out, err := filepath.Abs(*dir)
+	if err == nil {
+		err = runTypeSetProgram(map[string]interface{}{
+			"Package": fs.Arg(0),
+			"Name":    *name,
+			"Version": *version,
+			"Types":   tsTypes,
+			"Dir":     out,
+		})
+	}
+	if err == errInterrupted {
+		return 130
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(filepath.Join(out, filepath.Join(strings.Split(*name, "::")...)) + ".pp")
+	return 0
+}
+
+// runTypeSetProgram generates the program in a temporary directory, builds it, and runs it. The
+// program is built as if it was a package in the current directory using an overlay, so the current
+// directory must belong to a module that requires both puppet-workflow and the package that holds
+// the structs. Nothing is written to the current directory. The temporary directory is removed
+// also when the command is interrupted.
+func runTypeSetProgram(data map[string]interface{}) error {
+	tmp, err := ioutil.TempDir("", "typeset")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// An interrupt kills the go command or the program and is then reported as errInterrupted so
+	// that the temporary directory is removed before the process exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	src := filepath.Join(tmp, "main.go")
+	f, err := os.Create(src)
+	if err != nil {
+		return err
+	}
+	err = typeSetProgram.Execute(f, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	pkg := fmt.Sprintf("puppet-workflow-typeset-%d", os.Getpid())
+	overlay, err := json.Marshal(map[string]map[string]string{
+		"Replace": {filepath.Join(cwd, pkg, "main.go"): src}})
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(tmp, "overlay.json"), overlay, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	exe := filepath.Join(tmp, "typeset")
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	cmd := exec.CommandContext(ctx, "go", "build", "-overlay", filepath.Join(tmp, "overlay.json"), "-o", exe, "./"+pkg)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		return fmt.Errorf("unable to build the TypeSet generator, the current directory must belong to a module that requires puppet-workflow and %s: %v", data["Package"], err)
+	}
+	cmd = exec.CommandContext(ctx, exe)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil && ctx.Err() != nil {
+		return errInterrupted
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTypeSet(t *testing.T) {
+	if testing.Short() {
+		t.Skip(`compiles generated code`)
+	}
+	dir, err := ioutil.TempDir(``, `typeset`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Equal(t, 0, generateTypeSet([]string{`-name`, `Disks`, `-version`, `1.0.0`, `-dir`, dir,
+		`github.com/lyraproj/puppet-workflow/main/testdata/disks`, `Disk:DiskHandler`}))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, `Disks.pp`))
+	require.NoError(t, err)
+	require.Contains(t, string(content), `name => 'Disks'`)
+	require.Contains(t, string(content), `version => '1.0.0'`)
+	require.Contains(t, string(content), `'providedAttributes' => ['diskID']`)
+	require.Contains(t, string(content), `DiskHandler => {`)
+
+	// Nothing is left in the current directory
+	entries, err := ioutil.ReadDir(`.`)
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.NotContains(t, e.Name(), `typeset-`)
+	}
+}
@@ -25,6 +25,13 @@ type puppetStep struct {
 	expression parser.Expression
 	properties px.OrderedMap
 	step       wf.Step
+	resolving  bool
+	children   []*puppetStep
+	parameters []serviceapi.Parameter
+	returns    []serviceapi.Parameter
+	variables  []serviceapi.Parameter
+	locals     []*local
+	requires   []*local
 }
 
 func init() {
@@ -43,46 +50,70 @@ func (a *puppetStep) Name() string {
 
 func (a *puppetStep) Resolve(c px.Context) {
 	if a.step == nil {
+		a.resolving = true
+		defer func() { a.resolving = false }()
+
 		switch a.Style() {
 		case `stateHandler`:
 			a.step = wf.NewStateHandler(c, a.buildStateHandler)
 		case `workflow`:
 			a.step = wf.NewWorkflow(c, a.buildWorkflow)
+		case `call`:
+			a.step = wf.NewCall(c, a.buildCall)
 		case `resource`:
 			a.step = wf.NewResource(c, a.buildResource)
 		case `action`:
 			a.step = wf.NewAction(c, a.buildAction)
 		}
+		if a.parent == nil {
+			a.validateAliases()
+		}
 	}
 }
 
 func (a *puppetStep) buildStep(builder wf.Builder) {
+	a.parameters = a.extractParameters(a.properties, `parameters`, a.inferParameters)
+	a.returns = a.extractParameters(a.properties, `returns`, noParamsFunc)
+	a.requires = a.requiredLocals()
+	a.addLocalParameters(a.requires)
 	builder.Name(a.Name())
 	builder.When(a.getWhen())
-	builder.Parameters(a.extractParameters(a.properties, `parameters`, a.inferParameters)...)
-	builder.Returns(a.extractParameters(a.properties, `returns`, func() []serviceapi.Parameter { return []serviceapi.Parameter{} })...)
+	builder.Parameters(a.parameters...)
+	builder.Returns(a.returns...)
 }
 
 func newStep(c pdsl.EvaluationContext, parent *puppetStep, ex *parser.StepExpression) *puppetStep {
 	ca := &puppetStep{parent: parent, expression: ex}
+	sgs := strings.Split(ex.Name(), `::`)
+	ca.name = sgs[len(sgs)-1]
 	if props := ex.Properties(); props != nil {
-		v := pdsl.Evaluate(c, props)
-		dh, ok := v.(*types.Hash)
+		lh, ok := props.(*parser.LiteralHash)
 		if !ok {
+			v := pdsl.Evaluate(c, props)
 			panic(px.Error2(props, wf.FieldTypeMismatch, issue.H{`field`: `properties`, `expected`: `Hash`, `actual`: v.PType()}))
 		}
-		ca.properties = dh
+
+		// The locals are not evaluated until the workflow runs
+		es := make([]*types.HashEntry, 0, len(lh.Entries()))
+		for _, e := range lh.Entries() {
+			ke := e.(*parser.KeyedEntry)
+			k := pdsl.Evaluate(c, ke.Key())
+			if k.String() == `locals` {
+				ca.locals = newLocals(c, ca, ke.Value())
+				continue
+			}
+			es = append(es, types.WrapHashEntry(k, pdsl.Evaluate(c, ke.Value())))
+		}
+		ca.properties = types.WrapHash(es)
 	} else {
 		ca.properties = px.EmptyMap
 	}
-	sgs := strings.Split(ex.Name(), `::`)
-	ca.name = sgs[len(sgs)-1]
 	return ca
 }
 
 func (a *puppetStep) buildStateHandler(builder wf.StateHandlerBuilder) {
 	a.buildStep(builder)
-	builder.API(a.getAPI(builder.Context(), builder.GetParameters()))
+	builder.API(a.getAPI(builder.Context(), builder.GetName(), builder.GetParameters()))
 }
 
 func (a *puppetStep) buildResource(builder wf.ResourceBuilder) {
@@ -90,7 +121,9 @@ func (a *puppetStep) buildResource(builder wf.ResourceBuilder) {
 
 	a.buildStep(builder)
 	c := builder.Context().(pdsl.EvaluationContext)
-	builder.State(&state{ctx: c, stateType: a.getResourceType(c), unresolvedState: a.getState(c)})
+	st := a.getResourceType(c)
+	a.validateResourceReturns(st)
+	builder.State(&state{step: builder.GetName(), ctx: c, stateType: st, unresolvedState: a.getState(c), parameters: a.inheritDefaults(convertToPxParams(a.parameters)), locals: a.requires})
 	if extId, ok := a.getStringProperty(`externalId`); ok {
 		builder.ExternalId(extId)
 	}
@@ -102,9 +135,11 @@ func (a *puppetStep) buildAction(builder wf.ActionBuilder) {
 	if fd, ok := a.expression.(*parser.FunctionDefinition); ok {
 		fn := evaluator.NewPuppetFunction(fd)
 		fn.Resolve(builder.Context())
+		a.parameters = convertPxParams(fn.Parameters())
+		a.requires = a.requiredLocals()
+		a.addLocalParameters(a.requires)
 		builder.Name(fn.Name())
-		builder.Parameters(convertPxParams(fn.Parameters())...)
-		builder.Doer(&do{name: fn.Name(), body: fd.Body(), parameters: fn.Parameters()})
+		builder.Parameters(a.parameters...)
 		s := fn.Signature()
 		rt := s.ReturnType()
 		if rt != nil {
@@ -114,14 +149,16 @@ func (a *puppetStep) buildAction(builder wf.ActionBuilder) {
 				for i, e := range es {
 					ps[i] = serviceapi.NewParameter(e.Name(), ``, e.Value(), nil)
 				}
+				a.returns = ps
 				builder.Returns(ps...)
 			}
 		}
+		builder.Doer(&do{name: fn.Name(), body: fd.Body(), parameters: a.inheritDefaults(fn.Parameters()), returns: a.returns, returnType: rt, locals: a.requires})
 		return
 	}
 	if ae, ok := a.expression.(*parser.StepExpression); ok {
 		a.buildStep(builder)
-		builder.Doer(&do{name: builder.GetName(), body: ae.Definition(), parameters: convertToPxParams(builder.GetParameters())})
+		builder.Doer(&do{name: builder.GetName(), body: ae.Definition(), parameters: a.inheritDefaults(convertToPxParams(builder.GetParameters())), returns: a.returns, locals: a.requires})
 	}
 }
 
@@ -136,6 +173,7 @@ func (a *puppetStep) buildWorkflow(builder wf.WorkflowBuilder) {
 			a.workflowStep(builder, as)
 		} else if fn, ok := stmt.(*parser.FunctionDefinition); ok {
 			ac := &puppetStep{parent: a, expression: fn}
+			a.children = append(a.children, ac)
 			builder.Action(ac.buildAction)
 		} else {
 			defer a.amendError()
@@ -148,6 +186,7 @@ func (a *puppetStep) buildWorkflowInternals(builder wf.WorkflowBuilder) *parser.
 	defer a.amendError()
 
 	a.buildStep(builder)
+	a.validateLocals()
 	de := a.expression.(*parser.StepExpression).Definition()
 	if de == nil {
 		return nil
@@ -161,27 +200,38 @@ func (a *puppetStep) buildWorkflowInternals(builder wf.WorkflowBuilder) *parser.
 
 func (a *puppetStep) workflowStep(builder wf.WorkflowBuilder, as *parser.StepExpression) {
 	ac := newStep(builder.Context().(pdsl.EvaluationContext), a, as)
+	a.children = append(a.children, ac)
 	if _, ok := ac.properties.Get4(`iteration`); ok {
 		builder.Iterator(ac.buildIterator)
 	} else {
-		switch as.Style() {
-		case parser.StepStyleStateHandler:
+		switch ac.Style() {
+		case `stateHandler`:
 			builder.StateHandler(ac.buildStateHandler)
-		case parser.StepStyleWorkflow:
+		case `workflow`:
 			builder.Workflow(ac.buildWorkflow)
-		case parser.StepStyleResource:
+		case `call`:
+			builder.Call(ac.buildCall)
+		case `resource`:
 			builder.Resource(ac.buildResource)
-		case parser.StepStyleAction:
+		case `action`:
 			builder.Action(ac.buildAction)
 		}
 	}
 }
 
+// Style returns the style of the step. A workflow that has a `call` property is a call to
+// another step rather than a workflow in its own right.
 func (a *puppetStep) Style() string {
 	if _, ok := a.expression.(*parser.FunctionDefinition); ok {
 		return `action`
 	}
-	return string(a.expression.(*parser.StepExpression).Style())
+	style := a.expression.(*parser.StepExpression).Style()
+	if style == parser.StepStyleWorkflow {
+		if _, ok := a.properties.Get4(`call`); ok {
+			return `call`
+		}
+	}
+	return string(style)
 }
 
 func (a *puppetStep) inferParameters() []serviceapi.Parameter {
@@ -200,6 +250,8 @@ func (a *puppetStep) buildIterator(builder wf.IteratorBuilder) {
 		builder.StateHandler(a.buildStateHandler)
 	case `workflow`:
 		builder.Workflow(a.buildWorkflow)
+	case `call`:
+		builder.Call(a.buildCall)
 	case `resource`:
 		builder.Resource(a.buildResource)
 	case `action`:
@@ -230,6 +282,7 @@ func (a *puppetStep) buildIteratorInternals(builder wf.IteratorBuilder) {
 	if len(vars) == 0 {
 		vars = a.extractParameters(iteratorDef, `variables`, noParamsFunc)
 	}
+	a.variables = vars
 	builder.Variables(vars...)
 }
 
@@ -240,13 +293,14 @@ func (a *puppetStep) extractOver(props px.OrderedMap) px.Value {
 	return props.Get5(`over`, px.Undef)
 }
 
-func (a *puppetStep) getAPI(c px.Context, parameters []serviceapi.Parameter) px.PuppetObject {
+// getAPI returns the API of a state handler. The given name is the qualified name of the step.
+func (a *puppetStep) getAPI(c px.Context, name string, parameters []serviceapi.Parameter) px.PuppetObject {
 	var de parser.Expression
 	if ae, ok := a.expression.(*parser.StepExpression); ok {
 		de = ae.Definition()
 	} else {
 		// The block is the function
-		return NewDo(a.Name(), convertToPxParams(parameters), a.expression)
+		return NewDo(name, convertToPxParams(parameters), a.expression)
 	}
 	if de == nil {
 		panic(c.Error(a.expression, wf.NoDefinition, issue.NoArgs))
@@ -293,9 +347,9 @@ func (a *puppetStep) getAPI(c px.Context, parameters []serviceapi.Parameter) px.
 		panic(c.Error(block, wf.MissingRequiredFunction, issue.H{`function`: missing}))
 	}
 	if update == nil {
-		return NewCRD(a.Name(), create, read, remove)
+		return NewCRD(name, create, read, remove)
 	}
-	return NewCRUD(a.Name(), create, read, update, remove)
+	return NewCRUD(name, create, read, update, remove)
 }
 
 func createFunction(c px.Context, fd *parser.FunctionDefinition) evaluator.PuppetFunction {
@@ -329,7 +383,11 @@ func (a *puppetStep) extractParameters(props px.OrderedMap, field string, dflt f
 	params := make([]serviceapi.Parameter, ia.Len())
 	ia.EachWithIndex(func(v px.Value, i int) {
 		if p, ok := v.(px.Parameter); ok {
-			params[i] = convertPxParam(p)
+			if field == `returns` {
+				params[i] = convertPxReturn(p)
+			} else {
+				params[i] = convertPxParam(p)
+			}
 		} else {
 			panic(a.Error(wf.ElementNotParameter, issue.H{`type`: v.PType(), `field`: field}))
 		}
@@ -413,17 +471,68 @@ func convertPxParams(ps []px.Parameter) []serviceapi.Parameter {
 	return cs
 }
 
+// convertPxParam converts a parameter into a service parameter. A parameter that has a
+// default value of from('name') or alias('name') becomes an alias for name. All other
+// default values are retained and wrapped in a Sensitive when the parameter is Sensitive.
 func convertPxParam(p px.Parameter) serviceapi.Parameter {
 	var val px.Value
 	alias := ``
 	if p.HasValue() {
 		val = p.Value()
-		if vs, ok := val.(px.StringValue); ok {
-			alias = vs.String()
+		if an, ok := aliasName(val); ok {
+			alias = an
 			val = nil
 		}
 	}
-	return serviceapi.NewParameter(p.Name(), alias, p.Type(), val)
+	return serviceapi.NewParameter(p.Name(), alias, p.Type(), sensitiveValue(p.Type(), val))
+}
+
+// convertPxReturn converts a return into a service parameter. The grammar for returns
+// only allows a name to be assigned to a return, so a string value is always an alias.
+func convertPxReturn(p px.Parameter) serviceapi.Parameter {
+	if p.HasValue() {
+		if vs, ok := p.Value().(px.StringValue); ok {
+			return serviceapi.NewParameter(p.Name(), vs.String(), p.Type(), nil)
+		}
+	}
+	return convertPxParam(p)
+}
+
+// aliasName returns the name given to a from() or alias() call if the value is the
+// Deferred result of such a call.
+func aliasName(v px.Value) (string, bool) {
+	d, ok := v.(types.Deferred)
+	if !ok || !(d.Name() == `from` || d.Name() == `alias`) {
+		return ``, false
+	}
+	args := d.Arguments()
+	if args.Len() == 1 {
+		if s, ok := args.At(0).(px.StringValue); ok {
+			return s.String(), true
+		}
+	}
+	panic(px.Error(InvalidAlias, issue.H{`function`: d.Name()}))
+}
+
+// inheritDefaults returns a copy of the given parameters where each parameter that has no default
+// value is given the default value of the parameter with the same name of the closest enclosing
+// workflow that declares one, such as lookup('aws.tags') for $tags. Deferred defaults are resolved
+// when the step runs.
+func (a *puppetStep) inheritDefaults(ps []px.Parameter) []px.Parameter {
+	ips := make([]px.Parameter, len(ps))
+	for i, p := range ps {
+		ips[i] = p
+		if p.HasValue() {
+			continue
+		}
+		for o := a.parent; o != nil; o = o.parent {
+			if op := findParameter(o.parameters, p.Name()); op != nil && op.Value() != nil {
+				ips[i] = px.NewParameter(p.Name(), p.Type(), op.Value(), p.CapturesRest())
+				break
+			}
+		}
+	}
+	return ips
 }
 
 func convertToPxParams(ps []serviceapi.Parameter) []px.Parameter {
@@ -1,32 +1,47 @@
 package puppetwf_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
+	"github.com/lyraproj/issue/issue"
 	"github.com/lyraproj/pcore/px"
 	"github.com/lyraproj/pcore/types"
 	"github.com/lyraproj/puppet-evaluator/pdsl"
 	"github.com/lyraproj/puppet-evaluator/puppet"
 	"github.com/lyraproj/puppet-workflow/puppetwf"
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
 	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/serviceapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func withSampleService(sf func(pdsl.EvaluationContext, serviceapi.Service)) {
 	puppet.Do(func(ctx pdsl.EvaluationContext) {
 		// Command to start plug-in and read a given manifest
-		err := os.Chdir(`testdata`)
+		wd, err := os.Getwd()
 		if err != nil {
 			panic(err)
 		}
-		cmd := exec.Command("go", "run", "../../main/main.go", "--debug")
+		err = os.Chdir(`testdata`)
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			_ = os.Chdir(wd)
+		}()
+		cmd := exec.Command("go", "run", "../../main", "--debug")
 
 		// Logger that prints JSON on Stderr
 		logger := hclog.New(&hclog.LoggerOptions{
@@ -60,16 +75,9 @@ func withSampleService(sf func(pdsl.EvaluationContext, serviceapi.Service)) {
 	})
 }
 
-/*
 func withSampleLocalService(sf func(pdsl.EvaluationContext, serviceapi.Service)) {
-	// Command to start plug-in and read a given manifest
-	err := os.Chdir(`testdata`)
-	if err != nil {
-		panic(err)
-	}
 	puppetwf.WithService(`Puppet`, sf)
 }
-*/
 
 func TestStep(t *testing.T) {
 	withSampleService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
@@ -86,92 +94,527 @@ func TestStep(t *testing.T) {
 		dv = dl.At(0)
 		assert.Implements(t, (*serviceapi.Definition)(nil), dv, "metadata definitions type")
 		def := dv.(serviceapi.Definition)
-		assert.Equal(t, `Service::Definition(
-  'identifier' => TypedName(
-    'namespace' => 'definition',
-    'name' => 'aws_example'
-  ),
-  'serviceId' => TypedName(
-    'namespace' => 'service',
-    'name' => 'Aws_examplePp'
-  ),
-  'properties' => {
-    'parameters' => [
-      Lyra::Parameter(
-        'name' => 'tags',
-        'type' => Hash[String, String],
-        'value' => Deferred(
-          'name' => 'lookup',
-          'arguments' => ['aws.tags']
-        )
-      )],
-    'returns' => [
-      Lyra::Parameter(
-        'name' => 'vpcId',
-        'type' => String
-      ),
-      Lyra::Parameter(
-        'name' => 'subnetId',
-        'type' => String
-      )],
-    'steps' => [
-      Service::Definition(
-        'identifier' => TypedName(
-          'namespace' => 'definition',
-          'name' => 'aws_example::vpc'
-        ),
-        'serviceId' => TypedName(
-          'namespace' => 'service',
-          'name' => 'Aws_examplePp'
-        ),
-        'properties' => {
-          'parameters' => [
-            Lyra::Parameter(
-              'name' => 'tags',
-              'type' => Any
-            )],
-          'returns' => [
-            Lyra::Parameter(
-              'name' => 'vpcId',
-              'type' => Any
-            )],
-          'resourceType' => Aws::Vpc,
-          'style' => 'resource',
-          'origin' => ''
-        }
-      ),
-      Service::Definition(
-        'identifier' => TypedName(
-          'namespace' => 'definition',
-          'name' => 'aws_example::subnet'
-        ),
-        'serviceId' => TypedName(
-          'namespace' => 'service',
-          'name' => 'Aws_examplePp'
-        ),
-        'properties' => {
-          'parameters' => [
-            Lyra::Parameter(
-              'name' => 'tags',
-              'type' => Any
-            ),
-            Lyra::Parameter(
-              'name' => 'vpcId',
-              'type' => Any
-            )],
-          'returns' => [
-            Lyra::Parameter(
-              'name' => 'subnetId',
-              'type' => Any
-            )],
-          'resourceType' => Aws::Subnet,
-          'style' => 'resource',
-          'origin' => ''
-        }
-      )],
-    'style' => 'workflow',
-    'origin' => ''
+		// The definition itself is covered by testdata/aws_example.golden
+		assert.Equal(t, `aws_example`, def.Identifier().Name())
+	})
+}
+
+func TestCall(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/call_example.pp")).(serviceapi.Definition)
+		defs := s.Invoke(ctx, rs.Identifier().Name(), "metadata").(px.List).At(1).(px.List)
+		var def serviceapi.Definition
+		defs.Each(func(v px.Value) {
+			if d := v.(serviceapi.Definition); d.Identifier().Name() == `call_example` {
+				def = d
+			}
+		})
+		require.NotNil(t, def)
+		call := def.Properties().Get5(`steps`, px.EmptyArray).(px.List).At(0).(serviceapi.Definition)
+		cp := call.Properties()
+		require.Equal(t, `call`, cp.Get5(`style`, px.Undef).String())
+		require.Equal(t, `attach`, cp.Get5(`call`, px.Undef).String())
+
+		p := cp.Get5(`parameters`, px.EmptyArray).(px.List).At(0).(serviceapi.Parameter)
+		require.Equal(t, `network`, p.Name())
+		require.Equal(t, `net`, p.Alias())
+		require.Equal(t, `String`, p.Type().String())
+
+		r := cp.Get5(`returns`, px.EmptyArray).(px.List).At(0).(serviceapi.Parameter)
+		require.Equal(t, `result`, r.Name())
+		require.Equal(t, `attachment`, r.Alias())
+	})
+}
+
+func TestCallOtherManifest(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `call`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	attach := manifest(`attach.pp`, `workflow attach {
+  parameters => (String $network),
+  returns => (String $attachment)
+} {
+  action attach_network {
+    parameters => ($network),
+    returns => ($attachment)
+  } {
+    return({ attachment => "${network}-attached" })
   }
-)`, px.ToPrettyString(def))
+}
+`)
+	caller := manifest(`caller.pp`, "workflow caller {\n  parameters => (String $network),\n} {\n  workflow attached {\n    call => 'attach'\n  }\n}\n")
+	inner := manifest(`inner.pp`, "workflow inner {\n  parameters => (String $network),\n} {\n  workflow attached {\n    call => 'attach_network'\n  }\n}\n")
+	duplicate := manifest(`duplicate.pp`, "workflow attach {} {}\n")
+
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		load := func(path string) {
+			s.Invoke(ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(dir), types.WrapString(path))
+		}
+		load(attach)
+		load(caller)
+		requirePanicContains(t, `unable to find a step named 'attach_network'`, func() { load(inner) })
+		requirePanicContains(t, `step attach is already declared by `+attach+` (file: `+duplicate, func() { load(duplicate) })
+	})
+}
+
+func TestAlias(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/alias_example.pp")).(serviceapi.Definition)
+		def := s.Invoke(ctx, rs.Identifier().Name(), "metadata").(px.List).At(1).(px.List).At(0).(serviceapi.Definition)
+		props := def.Properties()
+
+		p := props.Get5(`parameters`, px.EmptyArray).(px.List).At(0).(serviceapi.Parameter)
+		require.Equal(t, ``, p.Alias())
+		require.Equal(t, `us-east-1`, p.Value().String())
+
+		r := props.Get5(`returns`, px.EmptyArray).(px.List).At(0).(serviceapi.Parameter)
+		require.Equal(t, `zone`, r.Name())
+		require.Equal(t, `availabilityZone`, r.Alias())
+
+		zps := props.Get5(`steps`, px.EmptyArray).(px.List).At(0).(serviceapi.Definition).Properties().Get5(`parameters`, px.EmptyArray).(px.List)
+		p = zps.At(0).(serviceapi.Parameter)
+		require.Equal(t, `region`, p.Alias())
+		require.Nil(t, p.Value())
+		p = zps.At(1).(serviceapi.Parameter)
+		require.Equal(t, ``, p.Alias())
+		require.Equal(t, `a`, p.Value().String())
+	})
+}
+
+func TestUnknownAlias(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		requirePanicContains(t, `is an alias for 'regin' which is not produced by any step`, func() {
+			s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/unknown_alias.pp"))
+		})
 	})
 }
+
+func TestActionReturns(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/action_returns.pp")).(serviceapi.Definition)
+		invoke := func(kind string) px.Value {
+			return s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Action_returns::Zone`), types.WrapString(`do`),
+				px.SingletonMap(`kind`, types.WrapString(kind)))
+		}
+		require.Equal(t, `{'zone' => 'a'}`, invoke(`ok`).String())
+		requirePanicContains(t, `did not return a value for 'zone'`, func() { invoke(`missing`) })
+		requirePanicContains(t, `returned 'other' which is not a declared return`, func() { invoke(`extra`) })
+		requirePanicContains(t, `returned Integer[3, 3] for 'zone' which expects String`, func() { invoke(`type`) })
+		requirePanicContains(t, `file: testdata/action_returns.pp, line: 17`, func() { invoke(`type`) })
+		requirePanicContains(t, `returned Integer[4, 4] for 'zone' which expects String (file: testdata/action_returns.pp, line: 15`, func() { invoke(`returned`) })
+	})
+}
+
+func requirePanicContains(t *testing.T, expected string, f func()) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		require.NotNil(t, r)
+		require.Contains(t, fmt.Sprint(r), expected)
+	}()
+	f()
+}
+
+func TestLocals(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/locals_example.pp")).(serviceapi.Definition)
+		def := s.Invoke(ctx, rs.Identifier().Name(), "metadata").(px.List).At(1).(px.List).At(0).(serviceapi.Definition)
+		steps := def.Properties().Get5(`steps`, px.EmptyArray).(px.List)
+		names := func(d serviceapi.Definition) []string {
+			ps := d.Properties().Get5(`parameters`, px.EmptyArray).(px.List)
+			ns := make([]string, ps.Len())
+			ps.EachWithIndex(func(p px.Value, i int) { ns[i] = p.(serviceapi.Parameter).Name() })
+			return ns
+		}
+		require.Equal(t, []string{`env`, `tags`}, names(steps.At(0).(serviceapi.Definition)))
+		require.Equal(t, []string{`env`, `tags`}, names(steps.At(1).(serviceapi.Definition)))
+
+		args := types.WrapStringToValueMap(map[string]px.Value{`env`: types.WrapString(`dev`), `tags`: px.SingletonMap(`a`, types.WrapString(`b`))})
+		v := s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Locals_example::Naming`), types.WrapString(`do`), args)
+		require.Equal(t, `{'name' => 'dev-app-x:dev'}`, v.String())
+
+		v = s.Invoke(ctx, rs.Identifier().Name(), `state`, types.WrapString(`locals_example::thing`), args)
+		require.Equal(t, `Locals::Thing('name' => 'dev-app', 'tags' => {'a' => 'b', 'env' => 'dev'})`, v.String())
+
+		args = types.WrapStringToValueMap(map[string]px.Value{`env`: types.WrapString(`prod`), `tags`: px.EmptyMap})
+		v = s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Locals_example::Naming`), types.WrapString(`do`), args)
+		require.Equal(t, `{'name' => 'prod-app-x:prod'}`, v.String())
+	})
+}
+
+func TestLocalsEvaluatedOncePerRun(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `locals`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, `once.pp`)
+	log := filepath.Join(dir, `exec.log`)
+	require.NoError(t, ioutil.WriteFile(manifest, []byte(`workflow once {
+  parameters => (String $env, String $log),
+  returns => (String $first, String $second),
+  locals => { stamp => exec('sh', '-c', "echo ${env} >> ${log}; echo ${env}") }
+} {
+  action first { returns => (String $first) } { return({ first => $stamp }) }
+  action second { returns => (String $second) } { return({ second => $stamp }) }
+}
+`), 0644))
+
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(dir), types.WrapString(manifest)).(serviceapi.Definition)
+		run := func(env string) {
+			args := types.WrapStringToValueMap(map[string]px.Value{`env`: types.WrapString(env), `log`: types.WrapString(log)})
+			s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Once::First`), types.WrapString(`do`), args)
+			s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Once::Second`), types.WrapString(`do`), args)
+		}
+		run(`dev`)
+		content, err := ioutil.ReadFile(log)
+		require.NoError(t, err)
+		require.Equal(t, "dev\n", string(content))
+
+		run(`prod`)
+		content, err = ioutil.ReadFile(log)
+		require.NoError(t, err)
+		require.Equal(t, "dev\nprod\n", string(content))
+	})
+}
+
+func TestUnknownLocalReference(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		requirePanicContains(t, `references 'environment' which is neither a parameter nor a local`, func() {
+			s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/unknown_local_reference.pp"))
+		})
+	})
+}
+
+func TestSensitive(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/sensitive_example.pp")).(serviceapi.Definition)
+		md := s.Invoke(ctx, rs.Identifier().Name(), "metadata").(px.List).At(1).String()
+		require.NotContains(t, md, `hunter2`)
+		require.Contains(t, md, `Sensitive [value redacted]`)
+
+		invoke := func(password string) px.Value {
+			return s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Sensitive_example::Check`), types.WrapString(`do`),
+				px.SingletonMap(`password`, types.WrapString(password)))
+		}
+		require.Equal(t, `{'valid' => true}`, invoke(`secret`).String())
+		requirePanicContains(t, `invalid password '[value redacted]'`, func() { invoke(`wrong`) })
+	})
+}
+
+func TestSensitiveResource(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/sensitive_resource.pp`)
+		st := m.State(`sensitive_resource::account`, map[string]interface{}{`user`: `bob`, `password`: `hunter2`})
+		require.Equal(t, `Sensitive_resource::Account`, st.PType().Name())
+		require.Equal(t, `Sensitive [value redacted]`, st.InitHash().Get5(`password`, px.Undef).String())
+		require.NotContains(t, st.String(), `hunter2`)
+
+		state, id := m.Handler(`account_handler`).Create(st)
+		require.Equal(t, `id-bob`, id)
+		require.Equal(t, `hunter2`, state.Get5(`password`, px.Undef).String())
+	})
+}
+
+func TestLookup(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(cwd) }()
+
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/lookup_example.pp")).(serviceapi.Definition)
+		result := s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Lookup_example::Tags`), types.WrapString(`do`), px.EmptyMap)
+		require.Equal(t, `{'tags' => {'created_by' => 'lyra', 'zone' => 'eu-west-1a', 'owner' => 'nobody'}, 'password' => 's3cr3t'}`, result.String())
+	}, puppetwf.WithLookupConfig(`testdata/lookup/lookup.yaml`))
+}
+
+func TestLookupWorkflowParameterDefault(t *testing.T) {
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/aws_example.pp")).(serviceapi.Definition)
+		v := s.Invoke(ctx, rs.Identifier().Name(), `state`, types.WrapString(`aws_example::vpc`), px.EmptyMap).(px.PuppetObject)
+		tags, _ := v.Get(`tags`)
+		require.Equal(t, `{'created_by' => 'lyra', 'zone' => 'eu-west-1a'}`, tags.String())
+	}, puppetwf.WithLookupConfig(`testdata/lookup/lookup.yaml`))
+}
+
+type recordingExporter struct {
+	spans []*puppetwf.Span
+}
+
+func (r *recordingExporter) Export(span *puppetwf.Span) {
+	r.spans = append(r.spans, span)
+}
+
+func TestStepLoggingAndSpans(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(cwd) }()
+
+	out := bytes.NewBufferString(``)
+	log := hclog.New(&hclog.LoggerOptions{Level: hclog.Debug, JSONFormat: true, Output: out})
+	exporter := &recordingExporter{}
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/action_returns.pp")).(serviceapi.Definition)
+		s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Action_returns::Zone`), types.WrapString(`do`), px.SingletonMap(`kind`, types.WrapString(`ok`)))
+		requirePanicContains(t, `did not return a value`, func() {
+			s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Action_returns::Zone`), types.WrapString(`do`), px.SingletonMap(`kind`, types.WrapString(`missing`)))
+		})
+	}, puppetwf.WithLogger(log), puppetwf.WithSpanExporter(exporter))
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(strings.SplitN(out.String(), "\n", 2)[0]), &entry))
+	require.Equal(t, `action_returns`, entry[`workflow`])
+	require.Equal(t, `action_returns::zone`, entry[`step`])
+	require.Equal(t, `action`, entry[`style`])
+	require.NotEmpty(t, entry[`invocation`])
+
+	require.Equal(t, 2, len(exporter.spans))
+	require.Equal(t, `action_returns::zone.do`, exporter.spans[0].Name)
+	require.Equal(t, `OK`, exporter.spans[0].Status)
+	require.Equal(t, `ERROR`, exporter.spans[1].Status)
+	require.NotEqual(t, exporter.spans[0].TraceID, exporter.spans[1].TraceID)
+}
+
+func TestResourceSpans(t *testing.T) {
+	exporter := &recordingExporter{}
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/locals_example.pp")).(serviceapi.Definition)
+		args := types.WrapStringToValueMap(map[string]px.Value{`env`: types.WrapString(`dev`), `tags`: px.EmptyMap})
+		s.Invoke(ctx, rs.Identifier().Name(), `state`, types.WrapString(`locals_example::thing`), args)
+	}, puppetwf.WithSpanExporter(exporter))
+
+	require.Equal(t, 1, len(exporter.spans))
+	require.Equal(t, `locals_example::thing.resolveState`, exporter.spans[0].Name)
+	require.Equal(t, `locals_example`, exporter.spans[0].Attributes[`workflow`])
+	require.Equal(t, `resource`, exporter.spans[0].Attributes[`style`])
+}
+
+func TestTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `trace`)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, `trace.json`)
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/action_returns.pp")).(serviceapi.Definition)
+		s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Action_returns::Zone`), types.WrapString(`do`), px.SingletonMap(`kind`, types.WrapString(`ok`)))
+	}, puppetwf.WithTraceFile(path))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"name":"action_returns::zone.do"`)
+
+	requirePanicContains(t, `unable to write trace file`, func() {
+		puppetwf.NewFileExporter(filepath.Join(dir, `no_such_dir`, `trace.json`))
+	})
+}
+
+func TestPuppetLogging(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(cwd) }()
+
+	out := bytes.NewBufferString(``)
+	log := hclog.New(&hclog.LoggerOptions{Level: hclog.Info, JSONFormat: true, Output: out})
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/logging_example.pp")).(serviceapi.Definition)
+		s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Logging_example::Greet`), types.WrapString(`do`), px.SingletonMap(`name`, types.WrapString(`bob`)))
+	}, puppetwf.WithLogger(log))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, 1, len(lines), `debug output must be suppressed at info level`)
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, `greeting bob`, entry[`@message`])
+	require.Equal(t, `info`, entry[`@level`])
+	require.Equal(t, `testdata/logging_example.pp`, entry[`file`])
+	require.Equal(t, float64(10), entry[`line`])
+	require.Equal(t, `logging_example::greet`, entry[`step`])
+}
+
+func TestRuntimeError(t *testing.T) {
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/sensitive_example.pp")).(serviceapi.Definition)
+		defer func() {
+			r := recover()
+			ri, ok := r.(issue.Reported)
+			require.True(t, ok)
+			require.Equal(t, issue.Code(puppetwf.StepRuntimeError), ri.Code())
+			require.Equal(t, `sensitive_example/check`, ri.Argument(`path`))
+			path, _ := filepath.Abs(`testdata/sensitive_example.pp`)
+			require.Equal(t, path, ri.Argument(`manifest`))
+			require.Equal(t, 15, ri.Location().Line())
+
+			eo := serviceapi.ErrorFromReported(ctx, ri)
+			require.Equal(t, puppetwf.StepRuntimeError, eo.IssueCode())
+			details := eo.Details().String()
+			require.Contains(t, details, `testdata/sensitive_example.pp`)
+			require.Contains(t, details, `'inputs' => {'password' => '[value redacted]'}`)
+			require.NotContains(t, details, `wrong`)
+		}()
+		s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Sensitive_example::Check`), types.WrapString(`do`),
+			px.SingletonMap(`password`, types.WrapString(`wrong`)))
+	})
+}
+
+func TestRuntimeErrorInCreate(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/sensitive_resource.pp`)
+		st := m.State(`sensitive_resource::account`, map[string]interface{}{`user`: `bob`, `password`: `wrong`})
+		defer func() {
+			ri, ok := recover().(issue.Reported)
+			require.True(t, ok)
+			require.Equal(t, issue.Code(puppetwf.StepRuntimeError), ri.Code())
+			require.Equal(t, `account_handler`, ri.Argument(`path`))
+			require.Equal(t, `create`, ri.Argument(`method`))
+			require.True(t, strings.HasSuffix(ri.Argument(`manifest`).(string), `sensitive_resource.pp`))
+
+			details := serviceapi.ErrorFromReported(h.Context(), ri).Details().String()
+			require.Contains(t, details, `'password' => '[value redacted]'`)
+			require.Contains(t, details, `rejected password [value redacted]`)
+			require.NotContains(t, details, `wrong`)
+		}()
+		m.Handler(`account_handler`).Create(st)
+	})
+}
+
+func TestHandlerObserver(t *testing.T) {
+	var calls []string
+	observer := func(handler, method string, args []px.Value, result px.Value) {
+		calls = append(calls, fmt.Sprintf(`%s.%s %t`, handler, method, result != nil))
+	}
+	puppetwf.WithService(`Puppet`, func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, "loadManifest", types.WrapString("testdata"), types.WrapString("testdata/sensitive_resource.pp")).(serviceapi.Definition)
+		st := s.Invoke(ctx, rs.Identifier().Name(), `state`, types.WrapString(`sensitive_resource::account`),
+			types.WrapStringToValueMap(map[string]px.Value{`user`: types.WrapString(`bob`), `password`: types.WrapString(`secret`)}))
+		s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Account_handler`), types.WrapString(`create`), st)
+		require.Panics(t, func() {
+			s.Invoke(ctx, rs.Identifier().Name(), `invoke`, types.WrapString(`Account_handler`), types.WrapString(`create`), px.New(ctx, st.PType(), st.(px.PuppetObject).InitHash().Merge(px.SingletonMap(`password`, types.WrapString(`wrong`)))))
+		})
+	}, puppetwf.WithHandlerObserver(observer))
+	require.Equal(t, []string{`account_handler.create true`, `account_handler.create false`}, calls)
+}
+
+func TestServiceName(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `naming`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := func(path, content string) string {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	vpc := manifest(`aws/vpc.pp`, "workflow vpc {} {}\n")
+	dotted := manifest(`a.b.pp`, "workflow dotted {} {}\n")
+	plain := manifest(`ab.pp`, "workflow plain {} {}\n")
+	upper := manifest(`AB.pp`, "workflow upper {} {}\n")
+	named := manifest(`ab/plain.pp`, "serviceName('My::Plain')\nworkflow named {} {}\n")
+	invalid := manifest(`invalid.pp`, "serviceName('my service')\n")
+	failing := manifest(`failing.pp`, "workflow failing {} {}\nfail('broken')\n")
+
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		load := func(path string) string {
+			return s.Invoke(ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(dir), types.WrapString(path)).(serviceapi.Definition).Identifier().Name()
+		}
+		require.Equal(t, `Aws::Vpc`, load(vpc))
+		requirePanicContains(t, `the service name of `+dotted+` can't be derived`, func() { load(dotted) })
+		require.Equal(t, `Ab`, load(plain))
+		requirePanicContains(t, `service name AB of `+upper+` is already used by `+plain, func() { load(upper) })
+		require.Equal(t, `My::Plain`, load(named))
+		requirePanicContains(t, `serviceName() must be called with one literal String`, func() { load(invalid) })
+		requirePanicContains(t, `broken`, func() { load(failing) })
+		_, ok := puppetwf.ManifestPath(ctx, `Failing`)
+		require.False(t, ok)
+
+		path, ok := puppetwf.ManifestPath(ctx, `My::Plain`)
+		require.True(t, ok)
+		require.Equal(t, named, path)
+		require.Equal(t, vpc, s.Invoke(ctx, puppetwf.ManifestLoaderID, `manifestPath`, types.WrapString(`Aws::Vpc`)).String())
+		_, ok = puppetwf.ManifestPath(ctx, `No::Such`)
+		require.False(t, ok)
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `metadata`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	typeSet := "type Disks = TypeSet[{pcore_version => '1.0.0', version => '0.2.0', types => {Disk => {attributes => {name => String}}}}]\n"
+	manifests := map[string]string{
+		`version.pp`:  "metadata({requires => {'Disks' => '>=1.0.0'}})\n" + typeSet,
+		`missing.pp`:  "metadata({requires => {'Volumes' => '1.x'}})\n",
+		`invalid.pp`:  "metadata({version => 1})\n",
+		`semver.pp`:   "metadata({version => 'one'})\n",
+		`twice.pp`:    "serviceName('Twice')\nmetadata({name => 'Twice'})\n",
+		`variable.pp`: "$md = {}\nmetadata($md)\n",
+	}
+	for name, content := range manifests {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	withSampleLocalService(func(ctx pdsl.EvaluationContext, s serviceapi.Service) {
+		load := func(name string) {
+			s.Invoke(ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(dir), types.WrapString(filepath.Join(dir, name)))
+		}
+		requirePanicContains(t, `requires the TypeSet Disks with a version in the range >=1.0.0, got 0.2.0`, func() { load(`version.pp`) })
+		requirePanicContains(t, `requires the TypeSet Volumes which cannot be found`, func() { load(`missing.pp`) })
+		requirePanicContains(t, `invalid metadata: function metadata: entry 'version' expects a String value, got Integer`, func() { load(`invalid.pp`) })
+		requirePanicContains(t, `invalid metadata:`, func() { load(`semver.pp`) })
+		requirePanicContains(t, `the service name can only be declared once`, func() { load(`twice.pp`) })
+		requirePanicContains(t, `metadata() must be called with one literal Hash`, func() { load(`variable.pp`) })
+
+		rs := s.Invoke(ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(`testdata`), types.WrapString(`testdata/metadata_example.pp`)).(serviceapi.Definition)
+		require.Equal(t, `Examples::Metadata`, rs.Identifier().Name())
+		defs := s.Invoke(ctx, rs.Identifier().Name(), `metadata`).(px.List).At(1).(px.List)
+		sd := defs.At(defs.Len() - 1).(serviceapi.Definition)
+		require.Equal(t, `TypedName('namespace' => 'service', 'name' => 'Examples::Metadata')`, sd.Identifier().String())
+		require.Equal(t, `{'style' => 'service', 'version' => SemVer('1.2.0'), 'description' => 'Creates a disk', 'requires' => {'Metadata_example' => SemVerRange('>=0.1.0 <1.0.0')}}`, sd.Properties().String())
+		md, ok := puppetwf.ManifestMetadata(ctx, `Examples::Metadata`)
+		require.True(t, ok)
+		require.Equal(t, `{'version' => SemVer('1.2.0'), 'description' => 'Creates a disk', 'requires' => {'Metadata_example' => SemVerRange('>=0.1.0 <1.0.0')}}`, md.String())
+		require.Equal(t, md.String(), s.Invoke(ctx, puppetwf.ManifestLoaderID, `manifestMetadata`, types.WrapString(`Examples::Metadata`)).String())
+		_, ok = puppetwf.ManifestMetadata(ctx, `No::Such`)
+		require.False(t, ok)
+	})
+}
+
+func TestModulePath(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `modules`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		`modules/disks/types/init_typeset.pp`: "type Disks = TypeSet[{pcore_version => '1.0.0', version => '0.1.0', types => {Disk => {attributes => {name => String}}}}]\n",
+		`modules/util/functions/disk_name.pp`: "function util::disk_name(String $n) >> String { \"disk-${n}\" }\n",
+		`modules/util/workflows/attach.pp`: "workflow util::attach {parameters => (String $disk), returns => (String $attachment)} {\n" +
+			"  action attach_disk {parameters => ($disk), returns => (String $attachment)} { { attachment => \"${disk}-attached\" } }\n}\n",
+		`project/main.pp`: "workflow main {parameters => (String $name), returns => (String $disk, String $attachment)} {\n" +
+			"  action disk {parameters => ($name), returns => (Disks::Disk $disk)} { { disk => Disks::Disk(util::disk_name($name)) } }\n" +
+			"  workflow attached {call => 'util::attach', parameters => ($disk), returns => ($attachment)}\n}\n",
+		`vendored/vendored.pp`:                        "workflow vendored {parameters => (String $name), returns => (String $disk)} {\n  action disk {parameters => ($name), returns => (String $disk)} { { disk => util::disk_name($name) } }\n}\n",
+		`vendored/vendor/util/functions/disk_name.pp`: "function util::disk_name(String $n) >> String { \"vendored-${n}\" }\n",
+		`ambiguous/ambiguous.pp`:                      "workflow ambiguous {parameters => (String $name), returns => (Disks::Disk $disk)} {\n  action disk {parameters => ($name), returns => (Disks::Disk $disk)} { { disk => Disks::Disk($name) } }\n}\n",
+		`ambiguous/types/disks/disk.pp`:               "type Disks::Disk = Object[{attributes => {name => String}}]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(filepath.Join(dir, `project/main.pp`))
+		returns := m.Definition(`main::attached`).Properties().Get5(`returns`, px.EmptyArray).(px.List)
+		require.Equal(t, `String`, returns.At(0).(serviceapi.Parameter).Type().String())
+		require.Contains(t, px.ToString(m.Invoke(`main::disk`, map[string]interface{}{`name`: `a`})), `'name' => 'disk-a'`)
+
+		m = h.Load(filepath.Join(dir, `vendored/vendored.pp`))
+		require.Contains(t, px.ToString(m.Invoke(`vendored::disk`, map[string]interface{}{`name`: `a`})), `'vendored-a'`)
+
+		requirePanicContains(t, `type Disks::Disk is defined by more than one module`, func() { h.Load(filepath.Join(dir, `ambiguous/ambiguous.pp`)) })
+	}, puppetwf.WithModulePath(filepath.Join(dir, `modules`)))
+}
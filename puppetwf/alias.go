@@ -0,0 +1,57 @@
+package puppetwf
+
+import (
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+)
+
+// validateAliases asserts that the alias of each parameter of the children of a workflow names
+// a parameter of the workflow, a variable of an iteration, or a value returned by a sibling, and
+// that the alias of each return of the workflow names a value returned by one of its children.
+// Nested workflows are validated recursively.
+func (a *puppetStep) validateAliases() {
+	if a.Style() != `workflow` {
+		return
+	}
+
+	produced := make(map[string]bool)
+	for _, c := range a.children {
+		for _, r := range c.returns {
+			produced[r.Name()] = true
+		}
+	}
+
+	for _, c := range a.children {
+		for _, p := range c.parameters {
+			an := p.Alias()
+			if an == `` || produced[an] || findParameter(a.parameters, an) != nil || findParameter(c.variables, an) != nil {
+				continue
+			}
+			panic(px.Error2(c.expression, UnknownAlias, issue.H{`field`: `parameter`, `step`: c.Name(), `name`: p.Name(), `alias`: an}))
+		}
+		c.validateAliases()
+	}
+
+	for _, r := range a.returns {
+		if an := r.Alias(); an != `` && !produced[an] {
+			panic(a.Error(UnknownAlias, issue.H{`field`: `return`, `step`: a.Name(), `name`: r.Name(), `alias`: an}))
+		}
+	}
+}
+
+// validateResourceReturns asserts that the alias of each return of a resource names an attribute
+// of the resource type.
+func (a *puppetStep) validateResourceReturns(t px.ObjectType) {
+	for _, r := range a.returns {
+		an := r.Alias()
+		if an == `` {
+			continue
+		}
+		if m, ok := t.Member(an); ok {
+			if _, ok := m.(px.Attribute); ok {
+				continue
+			}
+		}
+		panic(a.Error(UnknownAttributeAlias, issue.H{`step`: a.Name(), `name`: r.Name(), `alias`: an, `type`: t.Name()}))
+	}
+}
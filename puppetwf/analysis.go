@@ -0,0 +1,583 @@
+package puppetwf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// manifestAnalysis is what the language server knows about a manifest after loading it the same
+// way as LoadManifest does. Types and steps that are declared elsewhere are resolved while the
+// manifest is loaded, since the service that loads it does not outlive the analysis.
+type manifestAnalysis struct {
+	path       string
+	text       string
+	steps      []*puppetStep
+	attributes map[*puppetStep][]attributeDoc
+	types      map[string]*typeInfo
+	calls      map[string]*lspLocation
+	aliases    map[string]*parser.TypeAlias
+}
+
+type typeInfo struct {
+	hover    string
+	location *lspLocation
+}
+
+// analyzeManifest loads the given content of the manifest in the given file and returns the
+// analysis together with the diagnostics for the content. The analysis is nil when the manifest
+// cannot be loaded, and the diagnostics then contain the error.
+func analyzeManifest(path, text string, options []Option) (ma *manifestAnalysis, diagnostics []lspDiagnostic) {
+	defer func() {
+		if r := recover(); r != nil {
+			ma = nil
+			diagnostics = []lspDiagnostic{errorDiagnostic(path, text, wf.ToError(r))}
+		}
+	}()
+
+	WithService(`Puppet`, func(c pdsl.EvaluationContext, s serviceapi.Service) {
+		v, _ := c.Get(ManifestLoaderID)
+		_, ms, ast := v.(*manifestLoader).loadManifestSource(manifestModuleDir(path), path, []byte(text), true)
+		ma = &manifestAnalysis{
+			path:       path,
+			text:       text,
+			steps:      ms.steps,
+			attributes: make(map[*puppetStep][]attributeDoc),
+			types:      make(map[string]*typeInfo),
+			calls:      make(map[string]*lspLocation),
+			aliases:    make(map[string]*parser.TypeAlias)}
+		for _, stmt := range statements(ast) {
+			if ta, ok := stmt.(*parser.TypeAlias); ok {
+				ma.aliases[ta.Name()] = ta
+			}
+		}
+		ma.resolveSteps(ms.ctx, ms.steps)
+		diagnostics = ma.resolveTypes(ms.ctx, ast)
+		for _, w := range lintSteps(ms.steps, []byte(text)) {
+			diagnostics = append(diagnostics, lspDiagnostic{
+				Range:    rangeAt(text, w.Location()),
+				Severity: lspSeverityWarning,
+				Code:     string(w.Code()),
+				Source:   lspSource,
+				Message:  w.WithLocation(nil).Error()})
+		}
+	}, options...)
+	return
+}
+
+// manifestModuleDir returns the module directory of the manifest in the given file, which is the
+// directory of the file unless that is the workflows directory of a module.
+func manifestModuleDir(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) == `workflows` && isModule(filepath.Dir(dir)) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// resolveSteps records the attributes of the resource types of the given steps, and the location
+// of the steps that they call.
+func (ma *manifestAnalysis) resolveSteps(c px.Context, steps []*puppetStep) {
+	for _, a := range steps {
+		switch a.Style() {
+		case `resource`:
+			ma.attributes[a] = attributeDocs(c, a.getResourceType(c))
+		case `call`:
+			if name, ok := a.getStringProperty(`call`); ok {
+				if _, ok := ma.calls[name]; !ok {
+					ma.calls[name] = ma.stepLocation(c, name)
+				}
+			}
+		}
+		ma.resolveSteps(c, a.children)
+	}
+}
+
+// resolveTypes records the hover text and the location of each type that the given manifest
+// refers to, and returns diagnostics for the types that cannot be resolved. The references that
+// a type alias makes are not checked since a TypeSet refers to its own types by their short names.
+func (ma *manifestAnalysis) resolveTypes(c px.Context, ast parser.Expression) []lspDiagnostic {
+	var diagnostics []lspDiagnostic
+	ast.AllContents(nil, func(path []parser.Expression, e parser.Expression) {
+		qr, ok := e.(*parser.QualifiedReference)
+		if !ok {
+			return
+		}
+		name := qr.Name()
+		if _, ok := ma.types[name]; ok {
+			return
+		}
+		if t, ok := px.Load(c, px.NewTypedName(px.NsType, name)); ok {
+			ma.types[name] = &typeInfo{typeHover(c, t.(px.Type)), ma.typeLocation(c, name)}
+			return
+		}
+		for _, p := range path {
+			if _, ok := p.(*parser.TypeAlias); ok {
+				return
+			}
+		}
+		ri := px.Error2(qr, px.UnresolvedType, issue.H{`typeString`: name})
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    rangeAt(ma.text, ri.Location()),
+			Severity: lspSeverityError,
+			Code:     string(ri.Code()),
+			Source:   lspSource,
+			Message:  ri.WithLocation(nil).Error()})
+	})
+	return diagnostics
+}
+
+// typeLocation returns the location of the declaration of the type with the given name, which is
+// either declared by the manifest or found in the types directory of a module.
+func (ma *manifestAnalysis) typeLocation(c px.Context, name string) *lspLocation {
+	if ta, ok := ma.aliases[name]; ok {
+		return fileLocation(ma.path, ma.text, ta.ByteOffset(), len(name))
+	}
+	parts := strings.Split(name, `::`)
+	return findDeclaration(c, px.NewTypedName(px.NsType, name), `types`, func(text string) (int, int) {
+		// A type is declared by itself or by a TypeSet that is named after a part of its name
+		for n := len(parts); n > 0; n-- {
+			tp := regexp.MustCompile(`(?im)^\s*type\s+(` + regexp.QuoteMeta(strings.Join(parts[:n], `::`)) + `)\s*=`)
+			m := tp.FindStringSubmatchIndex(text)
+			if m == nil {
+				continue
+			}
+			if n < len(parts) {
+				leaf := parts[len(parts)-1]
+				if lm := regexp.MustCompile(`(?m)^\s*'?(` + regexp.QuoteMeta(leaf) + `)'?\s*=>`).FindStringSubmatchIndex(text[m[1]:]); lm != nil {
+					return m[1] + lm[2], len(leaf)
+				}
+			}
+			return m[2], m[3] - m[2]
+		}
+		return -1, 0
+	})
+}
+
+// stepLocation returns the location of the declaration of the step with the given name, which is
+// either declared by the manifest or found in the workflows directory of a module.
+func (ma *manifestAnalysis) stepLocation(c px.Context, name string) *lspLocation {
+	if a := findStep(ma.steps, name); a != nil {
+		return ma.stepNameLocation(a)
+	}
+	sp := regexp.MustCompile(`(?i)\b(?:action|resource|stateHandler|workflow)\s+(` + regexp.QuoteMeta(name) + `)\b`)
+	return findDeclaration(c, px.NewTypedName(px.NsStep, name), `workflows`, func(text string) (int, int) {
+		if m := sp.FindStringSubmatchIndex(text); m != nil {
+			return m[2], m[3] - m[2]
+		}
+		return -1, 0
+	})
+}
+
+// stepNameLocation returns the location of the name of the given step. The expression of a step
+// starts before its name, after its style. The name of a nested step is qualified by the names of
+// the steps that it is nested in, but only its last segment is written.
+func (ma *manifestAnalysis) stepNameLocation(a *puppetStep) *lspLocation {
+	start := a.expression.ByteOffset()
+	if start < 0 || start > len(ma.text) {
+		return nil
+	}
+	name := a.expression.(interface{ Name() string }).Name()
+	if a.parent != nil {
+		name = a.name
+	}
+	i := strings.Index(ma.text[start:], name)
+	if i < 0 {
+		return nil
+	}
+	return fileLocation(ma.path, ma.text, start+i, len(name))
+}
+
+// findDeclaration returns the location of a declaration in the files of the given directory of the
+// modules that have a definition for the given name. The find function returns the offset and
+// the length of the declaration in the text of a file, or a negative offset when the file doesn't
+// declare it.
+func findDeclaration(c px.Context, name px.TypedName, dir string, find func(text string) (int, int)) *lspLocation {
+	ml, ok := c.Loader().(*modulesLoader)
+	if !ok {
+		return nil
+	}
+	for _, m := range ml.modules {
+		if !hasEntry(m, name) {
+			continue
+		}
+		var found *lspLocation
+		_ = filepath.Walk(filepath.Join(m.Path(), dir), func(path string, info os.FileInfo, err error) error {
+			if err != nil || found != nil || info.IsDir() || !strings.HasSuffix(path, `.pp`) {
+				return nil
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			text := string(content)
+			if offset, length := find(text); offset >= 0 {
+				found = fileLocation(path, text, offset, length)
+			}
+			return nil
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// typeHover returns the hover text for the given type. The attributes of an object type are
+// listed since they are what a resource of the type declares.
+func typeHover(c px.Context, t px.Type) string {
+	b := bytes.NewBufferString("```puppet\n")
+	switch t := t.(type) {
+	case px.ObjectType:
+		b.WriteString(t.Name())
+		b.WriteString("\n```\n")
+		for _, ad := range attributeDocs(c, t) {
+			fmt.Fprintf(b, "\n- `%s`: `%s`", ad.name, ad.typ)
+			if flags := ad.flags(); len(flags) > 0 {
+				fmt.Fprintf(b, " (%s)", strings.Join(flags, `, `))
+			}
+		}
+	case *types.TypeAliasType:
+		fmt.Fprintf(b, "type %s = %s\n```", t.Name(), t.ResolvedType().String())
+	default:
+		b.WriteString(t.String())
+		b.WriteString("\n```")
+	}
+	return b.String()
+}
+
+// stepHover returns the hover text for the given step, which is its signature followed by the
+// comment that precedes it.
+func (ma *manifestAnalysis) stepHover(a *puppetStep) string {
+	b := bytes.NewBufferString("```puppet\n")
+	b.WriteString(a.Style())
+	b.WriteByte(' ')
+	b.WriteString(a.Name())
+	for _, f := range []struct {
+		key    string
+		ps     []serviceapi.Parameter
+		output bool
+	}{{`parameters`, a.parameters, false}, {`returns`, a.returns, true}} {
+		if len(f.ps) == 0 {
+			continue
+		}
+		ss := make([]string, len(f.ps))
+		for i, p := range f.ps {
+			ss[i] = ma.parameterType(a, p, f.output) + ` $` + p.Name()
+		}
+		fmt.Fprintf(b, "\n  %s => (%s)", f.key, strings.Join(ss, `, `))
+	}
+	b.WriteString("\n```")
+	if se, ok := a.expression.(*parser.StepExpression); ok {
+		if comment := docComment(se); comment != `` {
+			b.WriteString("\n\n")
+			b.WriteString(comment)
+		}
+	}
+	return b.String()
+}
+
+// parameterType returns the type of the given parameter, or return, of the given step. A parameter
+// that is declared without a type has the type of the variable of the enclosing workflow that it
+// receives its value from, and a return of a resource has the type of the attribute that it
+// returns.
+func (ma *manifestAnalysis) parameterType(a *puppetStep, p serviceapi.Parameter, output bool) string {
+	if _, ok := p.Type().(*types.AnyType); !ok && p.Type() != nil {
+		return p.Type().String()
+	}
+	name := sourceName(p)
+	if output {
+		for _, ad := range ma.attributes[a] {
+			if ad.name == name {
+				return ad.typ
+			}
+		}
+	} else if w := a.parent; w != nil {
+		if wp := findParameter(w.parameters, name); wp != nil {
+			return ma.parameterType(w, wp, false)
+		}
+		for _, c := range w.children {
+			if r := findParameter(c.returns, name); r != nil && c != a {
+				return ma.parameterType(c, r, true)
+			}
+		}
+	}
+	return `Any`
+}
+
+// findStep returns the step with the given name among the given steps, searching the steps nested
+// in a step after the step itself.
+func findStep(steps []*puppetStep, name string) *puppetStep {
+	for _, a := range steps {
+		if a.name == name || a.expression.(interface{ Name() string }).Name() == name {
+			return a
+		}
+	}
+	for _, a := range steps {
+		if c := findStep(a.children, name); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// step returns the step that the given frame belongs to.
+func (ma *manifestAnalysis) step(f *stepFrame) *puppetStep {
+	if f == nil {
+		return nil
+	}
+	steps := ma.steps
+	if p := f.outer(); p != nil {
+		pa := ma.step(p)
+		if pa == nil {
+			return nil
+		}
+		steps = pa.children
+	}
+	for _, a := range steps {
+		if a.name == leafName(f.name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// variableHover returns the hover text for the variable with the given name as seen by the given
+// step.
+func (ma *manifestAnalysis) variableHover(a *puppetStep, name string) string {
+	for s := a; s != nil; s = s.parent {
+		for _, k := range []struct {
+			kind   string
+			ps     []serviceapi.Parameter
+			output bool
+		}{{`parameter`, s.parameters, false}, {`return`, s.returns, true}, {`iteration variable`, s.variables, false}} {
+			if p := findParameter(k.ps, name); p != nil {
+				return fmt.Sprintf("```puppet\n%s $%s\n```\n%s of %s %s", ma.parameterType(s, p, k.output), name, k.kind, s.Style(), s.Name())
+			}
+		}
+		for _, l := range s.locals {
+			if l.name == name {
+				return fmt.Sprintf("```puppet\n$%s\n```\nlocal of %s %s", name, s.Style(), s.Name())
+			}
+		}
+	}
+	return ``
+}
+
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+	lspSource          = `puppet-workflow`
+)
+
+var embeddedLocation = regexp.MustCompile(`\s*\((?:file: ([^()]*?), )?line: (\d+)(?:, column: (\d+))?\)`)
+
+// errorDiagnostic returns the diagnostic for an error raised while the manifest in the given file
+// was loaded. An error that is raised while a step is built is located where it is raised, so the
+// location is taken from the innermost cause that is located in the manifest.
+func errorDiagnostic(path, text string, err error) lspDiagnostic {
+	var messages []string
+	var location issue.Location
+	var code issue.Code
+	for err != nil {
+		ri, ok := err.(issue.Reported)
+		if !ok {
+			messages = append(messages, err.Error())
+			break
+		}
+		if code == `` {
+			code = ri.Code()
+		}
+		msg := ri.WithLocation(nil).Error()
+		if i := strings.Index(msg, "\nCaused by: "); i >= 0 {
+			msg = msg[:i]
+		}
+		if l := ri.Location(); l != nil && filepath.Clean(l.File()) == filepath.Clean(path) {
+			location = l
+		}
+		for _, m := range embeddedLocation.FindAllStringSubmatch(msg, -1) {
+			if m[1] == path {
+				line, _ := strconv.Atoi(m[2])
+				pos, _ := strconv.Atoi(m[3])
+				location = issue.NewLocation(path, line, pos)
+			}
+		}
+		messages = append(messages, embeddedLocation.ReplaceAllString(msg, ``))
+		err = ri.Cause()
+	}
+	return lspDiagnostic{Range: rangeAt(text, location), Severity: lspSeverityError, Code: string(code), Source: lspSource, Message: strings.Join(messages, `: `)}
+}
+
+// rangeAt returns the range of the word at the given location in the given text. The column of a
+// location counts characters from 1, and whitespace at the location is skipped.
+func rangeAt(text string, location issue.Location) lspRange {
+	if location == nil || location.Line() <= 0 {
+		return lspRange{}
+	}
+	offset := 0
+	for line := 1; line < location.Line(); line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			break
+		}
+		offset += i + 1
+	}
+	for col := 1; col < location.Pos() && offset < len(text) && text[offset] != '\n'; col++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	for offset < len(text) && (text[offset] == ' ' || text[offset] == '\t') {
+		offset++
+	}
+	start, end := wordAt(text, offset)
+	if start != offset || end == start {
+		start, end = offset, offset
+		if end < len(text) && text[end] != '\n' {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+	}
+	return lspRange{positionAt(text, start), positionAt(text, end)}
+}
+
+// wordAt returns the start and end of the name or variable at the given offset of the text.
+func wordAt(text string, offset int) (int, int) {
+	isNameChar := func(c byte) bool { return isWordChar(c) || c == ':' }
+	start := offset
+	for start > 0 && isNameChar(text[start-1]) {
+		start--
+	}
+	if start > 0 && text[start-1] == '$' {
+		start--
+	}
+	end := offset
+	if end < len(text) && text[end] == '$' && end == start {
+		end++
+	}
+	for end < len(text) && isNameChar(text[end]) {
+		end++
+	}
+	return start, end
+}
+
+// stepFrame is a pair of braces in the text of a manifest. The properties and the body of a step
+// are frames with the style and the name of the step. The frames are found by scanning the text
+// so that they are known while the text does not parse.
+type stepFrame struct {
+	style      string
+	name       string
+	open       int
+	close      int
+	parent     *stepFrame
+	properties *stepFrame
+}
+
+var stepHeader = regexp.MustCompile(`\b(action|resource|stateHandler|workflow)\s+((?:[a-z]\w*::)*[a-z]\w*)\s*\z`)
+
+// scanFrames returns the frames of the given text in the order they are opened. Strings and
+// comments are skipped, and a frame that isn't closed ends at the end of the text.
+func scanFrames(text string) []*stepFrame {
+	var frames []*stepFrame
+	var open, closed *stepFrame
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '#':
+			if e := strings.IndexByte(text[i:], '\n'); e >= 0 {
+				i += e
+			} else {
+				i = len(text)
+			}
+		case '/':
+			if strings.HasPrefix(text[i:], `/*`) {
+				if e := strings.Index(text[i+2:], `*/`); e >= 0 {
+					i += e + 3
+				} else {
+					i = len(text)
+				}
+			}
+		case '\'', '"':
+			if e := stringEnd(text, i); e >= 0 {
+				i = e - 1
+			} else {
+				i = len(text)
+			}
+		case '{':
+			f := &stepFrame{open: i, close: len(text), parent: open}
+			prefix := strings.TrimRight(text[:i], " \t\r\n")
+			head := prefix
+			if len(head) > 200 {
+				head = head[len(head)-200:]
+			}
+			if m := stepHeader.FindStringSubmatch(head); m != nil {
+				f.style, f.name = m[1], m[2]
+			} else if closed != nil && closed.parent == open && closed.isProperties() && closed.close == len(prefix)-1 {
+				f.style, f.name, f.properties = closed.style, closed.name, closed
+			}
+			frames = append(frames, f)
+			open = f
+		case '}':
+			if open != nil {
+				open.close = i
+				closed = open
+				open = open.parent
+			}
+		}
+	}
+	return frames
+}
+
+func (f *stepFrame) isStep() bool {
+	return f.style != ``
+}
+
+func (f *stepFrame) isProperties() bool {
+	return f.isStep() && f.properties == nil
+}
+
+func (f *stepFrame) isBody() bool {
+	return f.properties != nil
+}
+
+// outer returns the frame of the step that the step of this frame is nested in.
+func (f *stepFrame) outer() *stepFrame {
+	for p := f.parent; p != nil; p = p.parent {
+		if p.isBody() {
+			return p
+		}
+	}
+	return nil
+}
+
+// innermostFrame returns the innermost of the given frames that contains the given offset.
+func innermostFrame(frames []*stepFrame, offset int) *stepFrame {
+	var inner *stepFrame
+	for _, f := range frames {
+		if f.open < offset && offset <= f.close {
+			inner = f
+		}
+	}
+	return inner
+}
+
+// stepFrameAt returns the frame of the innermost step that contains the given offset.
+func stepFrameAt(frames []*stepFrame, offset int) *stepFrame {
+	f := innermostFrame(frames, offset)
+	for f != nil && !f.isStep() {
+		f = f.parent
+	}
+	return f
+}
@@ -0,0 +1,153 @@
+package puppetwf
+
+import (
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/serviceapi"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// buildCall builds a step that, instead of declaring its children inline, refers to another
+// step by name. The parameters and returns of the call are validated against the parameters
+// and returns of the called step.
+func (a *puppetStep) buildCall(builder wf.CallBuilder) {
+	defer a.amendError()
+
+	if a.expression.(*parser.StepExpression).Definition() != nil {
+		panic(a.Error(CallHasDefinition, issue.H{`call`: a.callName()}))
+	}
+
+	name := a.callName()
+	cps, crs := a.calledSignature(builder.Context(), name)
+
+	builder.Name(a.Name())
+	builder.When(a.getWhen())
+	builder.CallTo(name)
+	a.parameters = a.callParameters(name, cps)
+	a.returns = a.callReturns(name, crs)
+	builder.Parameters(a.parameters...)
+	builder.Returns(a.returns...)
+}
+
+func (a *puppetStep) callName() string {
+	name, _ := a.getStringProperty(`call`)
+	return name
+}
+
+// callParameters returns the parameters of the call. When no parameters are declared, all
+// required parameters of the called step are passed on using their own name. A parameter
+// that doesn't declare a type will inherit the type of the called step's parameter.
+func (a *puppetStep) callParameters(name string, cps []serviceapi.Parameter) []serviceapi.Parameter {
+	if _, ok := a.properties.Get4(`parameters`); !ok {
+		ps := make([]serviceapi.Parameter, 0, len(cps))
+		for _, cp := range cps {
+			if cp.Value() == nil {
+				ps = append(ps, serviceapi.NewParameter(cp.Name(), ``, cp.Type(), nil))
+			}
+		}
+		return ps
+	}
+
+	ps := a.extractParameters(a.properties, `parameters`, noParamsFunc)
+	supplied := make(map[string]bool, len(ps))
+	for i, p := range ps {
+		cp := findParameter(cps, p.Name())
+		if cp == nil {
+			panic(a.Error(UnknownCallParameter, issue.H{`call`: name, `name`: p.Name()}))
+		}
+		if isAnyType(p.Type()) {
+			ps[i] = serviceapi.NewParameter(p.Name(), p.Alias(), cp.Type(), p.Value())
+		} else if !px.IsAssignable(cp.Type(), p.Type()) {
+			panic(a.Error(CallParameterTypeMismatch, issue.H{`call`: name, `name`: p.Name(), `expected`: cp.Type(), `actual`: p.Type()}))
+		}
+		supplied[p.Name()] = true
+	}
+	for _, cp := range cps {
+		if cp.Value() == nil && !supplied[cp.Name()] {
+			panic(a.Error(MissingCallParameter, issue.H{`call`: name, `name`: cp.Name()}))
+		}
+	}
+	return ps
+}
+
+// callReturns returns the returns of the call. When no returns are declared, all returns of
+// the called step are returned using their own name. A return may use an alias to denote the
+// name of the called step's return that it is assigned from.
+func (a *puppetStep) callReturns(name string, crs []serviceapi.Parameter) []serviceapi.Parameter {
+	if _, ok := a.properties.Get4(`returns`); !ok {
+		rs := make([]serviceapi.Parameter, len(crs))
+		for i, cr := range crs {
+			rs[i] = serviceapi.NewParameter(cr.Name(), ``, cr.Type(), nil)
+		}
+		return rs
+	}
+
+	rs := a.extractParameters(a.properties, `returns`, noParamsFunc)
+	for i, r := range rs {
+		from := r.Alias()
+		if from == `` {
+			from = r.Name()
+		}
+		cr := findParameter(crs, from)
+		if cr == nil {
+			panic(a.Error(UnknownCallReturn, issue.H{`call`: name, `name`: from}))
+		}
+		if isAnyType(r.Type()) {
+			rs[i] = serviceapi.NewParameter(r.Name(), r.Alias(), cr.Type(), r.Value())
+		} else if !px.IsAssignable(r.Type(), cr.Type()) {
+			panic(a.Error(CallReturnTypeMismatch, issue.H{`call`: name, `name`: r.Name(), `expected`: r.Type(), `actual`: cr.Type()}))
+		}
+	}
+	return rs
+}
+
+// calledSignature returns the parameters and returns of the step with the given name. The step
+// is first searched for among the steps of the current manifest and then among the steps of all
+// manifests that have been loaded by the manifest loader.
+func (a *puppetStep) calledSignature(c px.Context, name string) ([]serviceapi.Parameter, []serviceapi.Parameter) {
+	if v, ok := px.Load(c, px.NewTypedName(px.NsStep, name)); ok {
+		if ps, ok := v.(*puppetStep); ok {
+			if ps.resolving {
+				panic(a.Error(CallCycle, issue.H{`call`: name}))
+			}
+			ps.Resolve(c)
+			s := ps.Step()
+			return s.Parameters(), s.Returns()
+		}
+	}
+
+	if v, ok := c.Get(ManifestLoaderID); ok {
+		if def, ok := v.(*manifestLoader).stepDefinition(name); ok {
+			props := def.Properties()
+			return definitionParameters(props, `parameters`), definitionParameters(props, `returns`)
+		}
+	}
+	panic(a.Error(NoSuchCalledStep, issue.H{`call`: name}))
+}
+
+func definitionParameters(props px.OrderedMap, key string) []serviceapi.Parameter {
+	v, ok := props.Get4(key)
+	if !ok {
+		return []serviceapi.Parameter{}
+	}
+	l := v.(px.List)
+	ps := make([]serviceapi.Parameter, l.Len())
+	l.EachWithIndex(func(v px.Value, i int) { ps[i] = v.(serviceapi.Parameter) })
+	return ps
+}
+
+func findParameter(ps []serviceapi.Parameter, name string) serviceapi.Parameter {
+	for _, p := range ps {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func isAnyType(t px.Type) bool {
+	_, ok := t.(*types.AnyType)
+	return ok
+}
@@ -0,0 +1,264 @@
+package puppetwf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/serialization"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+const CassetteKey = `Puppet::Cassette`
+
+// Cassette records the calls made to the create, read, update, and delete functions of state
+// handlers, or replays calls that have been recorded earlier.
+//
+// A cassette is a JSON file that contains an array with one entry per call. Each entry holds the
+// name of the handler, the method, the arguments and either the result or the error of the call,
+// all with Sensitive values redacted. Each call is added to the file as soon as it returns, so a
+// recording is complete up to the last call also when the service doesn't end normally.
+//
+// When replaying, the calls to each handler must be made in the same order as they were recorded
+// and with the same redacted arguments. The recorded result is then returned without calling the
+// handler.
+//
+// Only calls to state handlers declared in manifests and to memory handlers are recorded and
+// replayed. Handlers that are implemented in Go and registered using registerHandler are called
+// directly by the service.
+type Cassette struct {
+	path      string
+	replay    bool
+	lock      sync.Mutex
+	file      *os.File
+	end       int64
+	recorded  []json.RawMessage
+	handlers  []string
+	positions map[string]int
+}
+
+// WithRecording records all calls to state handlers to the cassette file with the given path.
+func WithRecording(path string) Option {
+	return func(c px.Context) {
+		c.Set(CassetteKey, &Cassette{path: path})
+	}
+}
+
+// WithReplay serves all calls to state handlers from the cassette file with the given path.
+func WithReplay(path string) Option {
+	return func(c px.Context) {
+		cs := &Cassette{path: path, replay: true, positions: make(map[string]int)}
+		cs.load()
+		c.Set(CassetteKey, cs)
+	}
+}
+
+// intercept records or replays a call to the given method of the given handler when the context
+// has a cassette. Otherwise it just makes the call.
+func intercept(c px.Context, handler, method string, args []px.Value, call func() px.Value) px.Value {
+	if v, ok := c.Get(CassetteKey); ok {
+		cs := v.(*Cassette)
+		if cs.replay {
+			return cs.play(c, handler, method, args)
+		}
+		return cs.record(c, handler, method, args, call)
+	}
+	return call()
+}
+
+func (cs *Cassette) record(c px.Context, handler, method string, args []px.Value, call func() px.Value) (result px.Value) {
+	entries := []*types.HashEntry{
+		types.WrapHashEntry2(`handler`, types.WrapString(handler)),
+		types.WrapHashEntry2(`method`, types.WrapString(method)),
+		types.WrapHashEntry2(`args`, redactedValue(types.WrapValues(args)))}
+	defer func() {
+		if r := recover(); r != nil {
+			if r == wf.NotFound {
+				entries = append(entries, types.WrapHashEntry2(`notFound`, types.BooleanTrue))
+			} else {
+				entries = append(entries, types.WrapHashEntry2(`error`, types.WrapString(redact(wf.ToError(r).Error(), secrets(args...)))))
+			}
+			cs.add(c, types.WrapHash(entries))
+			panic(r)
+		}
+		entries = append(entries, types.WrapHashEntry2(`result`, redactedValue(result)))
+		cs.add(c, types.WrapHash(entries))
+	}()
+	return call()
+}
+
+// add adds the given interaction to the recording. The file always contains a JSON array. The
+// interaction is written in place of the closing bracket of the array, followed by a new closing
+// bracket.
+func (cs *Cassette) add(c px.Context, interaction px.OrderedMap) {
+	b := bytes.NewBufferString(``)
+	serialization.NewSerializer(c, px.EmptyMap).Convert(interaction, serialization.NewJsonStreamer(b))
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	err := cs.open()
+	if err == nil {
+		content := b.Bytes()
+		if cs.end > 1 {
+			content = append([]byte{','}, content...)
+		}
+		content = append(content, ']')
+		if _, err = cs.file.WriteAt(content, cs.end); err == nil {
+			cs.end += int64(len(content)) - 1
+		}
+	}
+	if err != nil {
+		panic(px.Error(CassetteWriteFailed, issue.H{`path`: cs.path, `detail`: err.Error()}))
+	}
+}
+
+// open creates the cassette file with an empty array unless it has been created already. It must
+// be called with the lock held.
+func (cs *Cassette) open() error {
+	if cs.file != nil {
+		return nil
+	}
+	f, err := os.Create(cs.path)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(`[]`); err != nil {
+		f.Close()
+		return err
+	}
+	cs.file = f
+	cs.end = 1
+	return nil
+}
+
+// Close closes the cassette file. The file contains an empty array when no calls were recorded.
+// It does nothing when the cassette is replayed.
+func (cs *Cassette) Close() error {
+	if cs.replay {
+		return nil
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	err := cs.open()
+	if err == nil {
+		err = cs.file.Close()
+	}
+	if err != nil {
+		return px.Error(CassetteWriteFailed, issue.H{`path`: cs.path, `detail`: err.Error()})
+	}
+	return nil
+}
+
+// load reads the recorded interactions. The interactions are kept in JSON form until they are
+// replayed because the types of their values are not known before the manifests are loaded.
+func (cs *Cassette) load() {
+	content, err := ioutil.ReadFile(cs.path)
+	if err != nil {
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: cs.path, `detail`: err.Error()}))
+	}
+	if err = json.Unmarshal(content, &cs.recorded); err != nil {
+		panic(px.Error(InvalidCassette, issue.H{`path`: cs.path}))
+	}
+	for _, raw := range cs.recorded {
+		var i struct {
+			Handler string `json:"handler"`
+		}
+		if err = json.Unmarshal(raw, &i); err != nil {
+			panic(px.Error(InvalidCassette, issue.H{`path`: cs.path}))
+		}
+		cs.handlers = append(cs.handlers, i.Handler)
+	}
+}
+
+// play returns the result of the next recorded call to the given handler. It panics if that call
+// was made to another method or with other arguments.
+func (cs *Cassette) play(c px.Context, handler, method string, args []px.Value) px.Value {
+	cs.lock.Lock()
+	var raw json.RawMessage
+	n := 0
+	for i, h := range cs.handlers {
+		if h != handler {
+			continue
+		}
+		if n == cs.positions[handler] {
+			raw = cs.recorded[i]
+			break
+		}
+		n++
+	}
+	cs.positions[handler]++
+	cs.lock.Unlock()
+
+	var interaction px.OrderedMap
+	if raw != nil {
+		ds := serialization.NewDeserializer(c, px.EmptyMap)
+		serialization.JsonToData(cs.path, bytes.NewReader(raw), ds)
+		interaction, _ = ds.Value().(px.OrderedMap)
+	}
+	actual := fmt.Sprintf("%s(%s)", method, px.ToPrettyString(redactedValue(types.WrapValues(args))))
+	if interaction == nil {
+		panic(px.Error(CassetteMismatch, issue.H{`path`: cs.path, `handler`: handler, `diff`: `unexpected call ` + actual}))
+	}
+	expected := fmt.Sprintf("%s(%s)", interaction.Get5(`method`, px.Undef), px.ToPrettyString(interaction.Get5(`args`, px.EmptyArray)))
+	if expected != actual {
+		panic(px.Error(CassetteMismatch, issue.H{`path`: cs.path, `handler`: handler, `diff`: lineDiff(expected, actual)}))
+	}
+
+	if nf, ok := interaction.Get4(`notFound`); ok && nf.Equals(types.BooleanTrue, nil) {
+		panic(wf.NotFound)
+	}
+	if msg, ok := interaction.Get4(`error`); ok {
+		panic(px.Error(ReplayedError, issue.H{`handler`: handler, `method`: method, `message`: msg.String()}))
+	}
+	return interaction.Get5(`result`, px.Undef)
+}
+
+// lineDiff returns a diff between expected and actual where each line of expected that is missing
+// in actual is prefixed with a minus and each line in actual that is missing in expected is prefixed
+// with a plus. Lines that are equal are prefixed with a space.
+func lineDiff(expected, actual string) string {
+	el := strings.Split(expected, "\n")
+	al := strings.Split(actual, "\n")
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(el)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(al)+1)
+	}
+	for i := len(el) - 1; i >= 0; i-- {
+		for j := len(al) - 1; j >= 0; j-- {
+			if el[i] == al[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	b := bytes.NewBufferString("--- recorded\n+++ actual\n")
+	i, j := 0, 0
+	for i < len(el) || j < len(al) {
+		switch {
+		case i < len(el) && j < len(al) && el[i] == al[j]:
+			b.WriteString(` ` + el[i] + "\n")
+			i++
+			j++
+		case i < len(el) && (j == len(al) || lcs[i+1][j] >= lcs[i][j+1]):
+			b.WriteString(`-` + el[i] + "\n")
+			i++
+		default:
+			b.WriteString(`+` + al[j] + "\n")
+			j++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package puppetwf_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `cassette`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cassette := filepath.Join(dir, `cassette.json`)
+
+	run := func(h *wftesting.Harness, name string) (px.OrderedMap, string) {
+		m := h.Load(`testing/testdata/handler_example.pp`)
+		handler := m.Handler(`thing_handler`)
+		h.Handle(`Handler_example::Thing`, handler)
+		state, id := m.Apply(`handler_example::thing`, map[string]interface{}{`name`: name})
+		handler.Read(id)
+		return state, id
+	}
+
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		_, id := run(h, `one`)
+		require.Equal(t, `id-one`, id)
+
+		// Calls are written as they are recorded
+		var interactions []interface{}
+		content, err := ioutil.ReadFile(cassette)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(content, &interactions))
+		require.Len(t, interactions, 2)
+	}, puppetwf.WithRecording(cassette))
+
+	content, err := ioutil.ReadFile(cassette)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"handler":"thing_handler","method":"create"`)
+	require.Contains(t, string(content), `"method":"read","args":["id-one"]`)
+
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		state, id := run(h, `one`)
+		require.Equal(t, `id-one`, id)
+		require.Equal(t, `id-one`, state.Get5(`id`, px.Undef).String())
+	}, puppetwf.WithReplay(cassette))
+
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		requirePanicContains(t, "-    'name' => 'one'\n+    'name' => 'two'", func() { run(h, `two`) })
+	}, puppetwf.WithReplay(cassette))
+
+	wftesting.Run(t, func(h *wftesting.Harness) {}, puppetwf.WithRecording(cassette))
+	content, err = ioutil.ReadFile(cassette)
+	require.NoError(t, err)
+	require.Equal(t, `[]`, string(content))
+}
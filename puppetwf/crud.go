@@ -10,13 +10,17 @@ import (
 	"github.com/lyraproj/puppet-evaluator/evaluator"
 	"github.com/lyraproj/puppet-evaluator/pdsl"
 	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/serviceapi"
 	"github.com/lyraproj/servicesdk/wf"
 )
 
 type do struct {
 	name       string
 	parameters []px.Parameter
+	returns    []serviceapi.Parameter
+	returnType px.Type
 	body       parser.Expression
+	locals     []*local
 }
 
 func (c *do) Name() string {
@@ -54,33 +58,176 @@ func (c *do) Call(ctx px.Context, method px.ObjFunc, args []px.Value, block px.L
 	if method.Name() != `do` {
 		return nil, false
 	}
-	if block != nil {
-		panic(px.Error(px.IllegalArguments, issue.H{`function`: c.name, `message`: `nested lambdas are not supported`}))
-	}
+	defer observe(ctx, c.name, `action`, method.Name())()
+	defer amendRuntimeError(ctx, c.name, method.Name(), c.body, sensitiveArguments(c.parameters, args[0].(px.OrderedMap)))
+	am := sensitiveArguments(c.parameters, resolveDefaults(ctx, c.parameters, args[0].(px.OrderedMap)))
+	defer redactErrors(c.name, secrets(am))
+	return c.checkResult(c.invoke(ctx, method, am, block)), true
+}
 
-	ok = true
+// invoke evaluates the body of the action and returns the result together with the location of the
+// expression that produced it, which is either a return() call or the last statement of the body.
+func (c *do) invoke(ctx px.Context, method px.ObjFunc, am px.OrderedMap, block px.Lambda) (result px.Value, location issue.Location) {
+	location = lastStatement(c.body)
 	defer func() {
 		if err := recover(); err != nil {
 			switch err := err.(type) {
 			case *errors.NextIteration:
-				result = err.Value()
+				result, location = err.Value(), err.Location()
 			case *errors.Return:
-				result = err.Value()
+				result, location = err.Value(), err.Location()
 			default:
 				panic(err)
 			}
 		}
 	}()
 
-	am := args[0].(px.OrderedMap)
 	input := make([]px.Value, len(c.parameters))
 	for i, p := range c.parameters {
 		input[i] = am.Get5(p.Name(), px.Undef)
 	}
-	result = evaluator.CallBlock(ctx.(pdsl.EvaluationContext), c.name, c.parameters, method.Type().(*types.CallableType), c.body, input)
+	ec := withStepLogging(ctx)
+	if block != nil {
+		if !yields(c.body) {
+			panic(px.Error(px.IllegalArguments, issue.H{`function`: c.name, `message`: `the action does not accept a block`}))
+		}
+		// The body reaches the block using yield(). The block must not see the parameters and
+		// variables of the action body so it is evaluated using a fork of the calling context.
+		ec = withStepLogging(ec.Fork())
+		ec.Set(blockKey, &closure{Lambda: block, ctx: ctx.Fork()})
+	}
+	if len(c.locals) == 0 {
+		return evaluator.CallBlock(ec, c.name, c.parameters, method.Type().(*types.CallableType), c.body, input), location
+	}
+
+	// Locals are computed from the arguments in a scope that encloses the scope of the body.
+	scope := ec.Scope().(pdsl.Scope)
+	return scope.WithLocalScope(func() px.Value {
+		am.EachPair(func(k, v px.Value) {
+			scope.Set(k.String(), v)
+		})
+		evaluateLocals(ec, scope, c.locals)
+		return evaluator.CallBlock(ec, c.name, c.parameters, method.Type().(*types.CallableType), c.body, input)
+	}), location
+}
+
+// lastStatement returns the last statement of the given body, or the body itself when it isn't a
+// block of statements.
+func lastStatement(body parser.Expression) parser.Expression {
+	if b, ok := body.(*parser.BlockExpression); ok {
+		if ss := b.Statements(); len(ss) > 0 {
+			return ss[len(ss)-1]
+		}
+	}
+	return body
+}
+
+// resolveDefaults adds the resolved default value of each parameter that has no argument and a
+// Deferred default value, such as lookup('key'), to the given arguments. A Deferred argument for a
+// parameter that has a default, such as a default that an engine passes on unresolved, is resolved
+// too.
+func resolveDefaults(ctx px.Context, ps []px.Parameter, am px.OrderedMap) px.OrderedMap {
+	for _, p := range ps {
+		if !p.HasValue() {
+			continue
+		}
+		d, ok := am.Get5(p.Name(), p.Value()).(types.Deferred)
+		if !ok {
+			continue
+		}
+		am = am.Merge(px.SingletonMap(p.Name(), types.ResolveDeferred(ctx, d, ctx.Scope())))
+	}
+	return am
+}
+
+// checkResult asserts that the result of the action conforms to its declared return type and
+// returns. The result must be a hash that contains an entry for each declared return, keyed by the
+// alias of the return or its name when it has no alias, and no other entries. Errors are reported
+// at the given location of the expression that produced the result.
+func (c *do) checkResult(result px.Value, location issue.Location) px.Value {
+	if c.returnType != nil && !px.IsInstance(c.returnType, result) {
+		panic(px.Error2(location, ActionResultTypeMismatch, issue.H{`step`: c.name, `expected`: c.returnType, `actual`: px.DetailedValueType(result)}))
+	}
+	if len(c.returns) == 0 {
+		return result
+	}
+
+	rh, ok := result.(px.OrderedMap)
+	if !ok {
+		panic(px.Error2(location, ActionResultNotHash, issue.H{`step`: c.name, `actual`: px.DetailedValueType(result)}))
+	}
+	keys := make(map[string]bool, len(c.returns))
+	for _, r := range c.returns {
+		key := r.Alias()
+		if key == `` {
+			key = r.Name()
+		}
+		keys[key] = true
+		v, ok := rh.Get4(key)
+		if !ok {
+			if px.IsInstance(r.Type(), px.Undef) {
+				continue
+			}
+			panic(px.Error2(location, ActionMissingReturn, issue.H{`step`: c.name, `name`: key}))
+		}
+		if !px.IsInstance(r.Type(), v) {
+			panic(px.Error2(location, ActionReturnTypeMismatch, issue.H{`step`: c.name, `name`: key, `expected`: r.Type(), `actual`: px.DetailedValueType(v)}))
+		}
+	}
+	rh.EachKey(func(k px.Value) {
+		if !keys[k.String()] {
+			panic(px.Error2(location, ActionUnexpectedReturn, issue.H{`step`: c.name, `name`: k.String()}))
+		}
+	})
+	return result
+}
+
+// closure is a block that is evaluated in the context where it was passed to an action
+type closure struct {
+	px.Lambda
+	ctx px.Context
+}
+
+func (c *closure) Call(_ px.Context, block px.Lambda, args ...px.Value) px.Value {
+	return c.Lambda.Call(c.ctx, block, args...)
+}
+
+// yields returns true if the given body contains a call to yield().
+func yields(body parser.Expression) (found bool) {
+	if body == nil {
+		return false
+	}
+	body.AllContents(nil, func(_ []parser.Expression, e parser.Expression) {
+		if call, ok := e.(*parser.CallNamedFunctionExpression); ok {
+			if qn, ok := call.Functor().(*parser.QualifiedName); ok && qn.Name() == `yield` {
+				found = true
+			}
+		}
+	})
 	return
 }
 
+const HandlerObserverKey = `Puppet::HandlerObserver`
+
+// HandlerObserver is notified after each call to the create, read, update, or delete function of
+// a state handler. The result is nil when the call failed.
+type HandlerObserver func(handler, method string, args []px.Value, result px.Value)
+
+// WithHandlerObserver makes all calls to state handlers notify the given observer.
+func WithHandlerObserver(o HandlerObserver) Option {
+	return func(c px.Context) {
+		c.Set(HandlerObserverKey, o)
+	}
+}
+
+// notifyHandlerObserver must be deferred. It notifies the observer of the given context, if any,
+// of a call to a state handler. The given result is nil when the call failed.
+func notifyHandlerObserver(c px.Context, handler, method string, args []px.Value, result *px.Value) {
+	if o, ok := c.Get(HandlerObserverKey); ok {
+		o.(HandlerObserver)(handler, method, args, *result)
+	}
+}
+
 type crd struct {
 	name   string
 	create px.InvokableValue
@@ -122,8 +269,16 @@ func (c *crd) InitHash() px.OrderedMap {
 func (c *crd) Call(ctx px.Context, method px.ObjFunc, args []px.Value, block px.Lambda) (result px.Value, ok bool) {
 	var f px.InvokableValue
 	switch method.Name() {
+	case `create`, `read`, `delete`:
+		defer observe(ctx, c.name, `handler`, method.Name())()
+		defer amendRuntimeError(ctx, c.name, method.Name(), nil, types.WrapValues(args))
+		defer notifyHandlerObserver(ctx, c.name, method.Name(), args, &result)
+	}
+	switch method.Name() {
 	case `create`:
-		f = c.create
+		return intercept(ctx, c.name, method.Name(), args, func() px.Value {
+			return c.createOrUpdate(ctx, c.create, args, block)
+		}), true
 	case `read`:
 		f = c.read
 	case `delete`:
@@ -131,7 +286,20 @@ func (c *crd) Call(ctx px.Context, method px.ObjFunc, args []px.Value, block px.
 	default:
 		return nil, false
 	}
-	return f.Call(ctx, block, args...), true
+	return intercept(ctx, c.name, method.Name(), args, func() px.Value {
+		return f.Call(withStepLogging(ctx), block, args...)
+	}), true
+}
+
+// createOrUpdate calls the given create or update function. This is the only place where
+// Sensitive values are unwrapped before they are handed over to the handler.
+func (c *crd) createOrUpdate(ctx px.Context, f px.InvokableValue, args []px.Value, block px.Lambda) px.Value {
+	defer redactErrors(c.name, secrets(args...))
+	uargs := make([]px.Value, len(args))
+	for i, arg := range args {
+		uargs[i] = unwrapSensitive(ctx, arg)
+	}
+	return f.Call(withStepLogging(ctx), block, uargs...)
 }
 
 type crud struct {
@@ -153,13 +321,18 @@ func (c *crud) ToString(bld io.Writer, format px.FormatContext, g px.RDetect) {
 
 func (c *crud) Call(ctx px.Context, method px.ObjFunc, args []px.Value, block px.Lambda) (result px.Value, ok bool) {
 	if method.Name() == `update` {
-		return c.update.Call(ctx, block, args...), true
+		defer observe(ctx, c.name, `handler`, method.Name())()
+		defer amendRuntimeError(ctx, c.name, method.Name(), nil, types.WrapValues(args))
+		defer notifyHandlerObserver(ctx, c.name, method.Name(), args, &result)
+		return intercept(ctx, c.name, method.Name(), args, func() px.Value {
+			return c.createOrUpdate(ctx, c.update, args, block)
+		}), true
 	}
 	return c.crd.Call(ctx, method, args, block)
 }
 
 func NewDo(name string, parameters []px.Parameter, block parser.Expression) px.PuppetObject {
-	return &do{name, parameters, block}
+	return &do{name: name, parameters: parameters, body: block}
 }
 
 func NewCRD(name string, create, read, delete px.InvokableValue) px.PuppetObject {
@@ -0,0 +1,109 @@
+package puppetwf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/issue/issue"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/evaluator"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-evaluator/puppet"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/wf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoWithBlock(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		ast := c.ParseAndValidate(`action.pp`, `
+function with_prefix(String $prefix) {
+  $suffix = 'inner'
+  yield("${prefix}-x")
+}`, false)
+		fd := ast.(*parser.Program).Definitions()[0].(*parser.FunctionDefinition)
+		fn := evaluator.NewPuppetFunction(fd)
+		fn.Resolve(c)
+		d := &do{name: fn.Name(), parameters: fn.Parameters(), body: fd.Body()}
+
+		pdsl.TopEvaluate(c, c.ParseAndValidate(`caller.pp`, `$suffix = 'outer'`, false))
+		block := parseBlock(c, `|$s| { "${s}-${suffix}" }`)
+
+		m, _ := wf.DoType.(px.ObjectType).Member(`do`)
+		result, ok := d.Call(c, m.(px.ObjFunc), []px.Value{px.SingletonMap(`prefix`, types.WrapString(`p`))}, block)
+		require.True(t, ok)
+		require.Equal(t, `p-x-outer`, result.String())
+	})
+}
+
+func TestDoWithUnexpectedBlock(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		d := &do{name: `no_block`, parameters: []px.Parameter{}}
+		m, _ := wf.DoType.(px.ObjectType).Member(`do`)
+		r := recoverFrom(func() {
+			d.Call(c, m.(px.ObjFunc), []px.Value{px.EmptyMap}, parseBlock(c, `|$s| { $s }`))
+		})
+		ri, ok := r.(issue.Reported)
+		require.True(t, ok)
+		require.Equal(t, issue.Code(StepRuntimeError), ri.Code())
+		cause, ok := ri.Cause().(issue.Reported)
+		require.True(t, ok)
+		require.Equal(t, issue.Code(px.IllegalArguments), cause.Code())
+		require.Contains(t, cause.Error(), `the action does not accept a block`)
+	})
+}
+
+func TestYieldWithoutBlock(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		r := recoverFrom(func() {
+			pdsl.TopEvaluate(c, c.ParseAndValidate(`yield.pp`, `yield(1)`, false))
+		})
+		ri, ok := r.(issue.Reported)
+		require.True(t, ok)
+		require.Equal(t, issue.Code(NoBlockGiven), ri.Code())
+	})
+}
+
+func parseBlock(c pdsl.EvaluationContext, src string) px.Lambda {
+	ast := c.ParseAndValidate(`block.pp`, `with(1) `+src, false)
+	call := ast.(*parser.Program).Body().(*parser.BlockExpression).Statements()[0]
+	le := call.(*parser.CallNamedFunctionExpression).Lambda().(*parser.LambdaExpression)
+	return evaluator.NewPuppetLambda(le, c)
+}
+
+func TestCrdUnwrapsSensitiveOnCreate(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		echo := parseBlock(c, `|$h| { $h }`)
+		cr := &crd{name: `echo`, create: echo, read: echo, delete: echo}
+		arg := px.SingletonMap(`password`, types.WrapSensitive(types.WrapString(`hunter2`)))
+
+		m, _ := wf.CrdType.(px.ObjectType).Member(`create`)
+		result, _ := cr.Call(c, m.(px.ObjFunc), []px.Value{arg}, nil)
+		require.Equal(t, `{'password' => 'hunter2'}`, result.String())
+
+		m, _ = wf.CrdType.(px.ObjectType).Member(`read`)
+		result, _ = cr.Call(c, m.(px.ObjFunc), []px.Value{arg}, nil)
+		require.Equal(t, `{'password' => Sensitive [value redacted]}`, result.String())
+	})
+}
+
+func TestHandlerLogging(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		ast := c.ParseAndValidate(`handler.pp`, "function create($h) {\n  warning('creating')\n  $h\n}", false)
+		create := createFunction(c, ast.(*parser.Program).Definitions()[0].(*parser.FunctionDefinition))
+
+		out := bytes.NewBufferString(``)
+		WithLogger(hclog.New(&hclog.LoggerOptions{Level: hclog.Info, Output: out}))(c)
+		defer c.Delete(LoggerKey)
+
+		cr := &crd{name: `example::handler`, create: create, read: create, delete: create}
+		m, _ := wf.CrdType.(px.ObjectType).Member(`create`)
+		cr.Call(c, m.(px.ObjFunc), []px.Value{px.EmptyMap}, nil)
+		require.Contains(t, out.String(), `[WARN]  creating:`)
+		require.Contains(t, out.String(), `style=handler`)
+		require.Contains(t, out.String(), `file=handler.pp line=2`)
+	})
+}
@@ -0,0 +1,666 @@
+package puppetwf
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/utils"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/annotation"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+const (
+	// MarkdownDoc is the format of reference documentation written as Markdown.
+	MarkdownDoc = `markdown`
+
+	// HTMLDoc is the format of reference documentation written as a HTML page.
+	HTMLDoc = `html`
+)
+
+// WriteDoc loads the manifests and the TypeSet files found in the given paths and writes
+// reference documentation for them to w in the given format, MarkdownDoc or HTMLDoc. A path is
+// either a file or a directory that is searched for .pp files. A .pp file in a directory named
+// types is a TypeSet file, other .pp files, except tests ending with _test.pp, are manifests.
+//
+// The documentation lists the parameters, returns, and steps of each workflow, including the
+// when condition and the iteration of each step, and the attributes of each resource type with
+// the handler that serves it. Comments that immediately precede a step, or a type declaration,
+// in a manifest become its description:
+//
+//	# Creates the VPC that all subnets belong to.
+//	resource vpc {
+//	  ...
+//	}
+func WriteDoc(w io.Writer, format string, paths []string, options ...Option) {
+	if format != MarkdownDoc && format != HTMLDoc {
+		panic(px.Error(UnknownDocFormat, issue.H{`format`: format}))
+	}
+	d := &doc{handlers: make(map[string]string), types: make(map[string]*typeDoc)}
+	WithService(`Puppet`, func(c pdsl.EvaluationContext, s serviceapi.Service) {
+		v, _ := c.Get(ManifestLoaderID)
+		ml := v.(*manifestLoader)
+		for _, f := range sourceFiles(paths) {
+			if f.typeSet {
+				d.addTypeSet(c, ReadTypeSet(c, f.path))
+			} else {
+				d.addManifest(ml, f.moduleDir, f.path)
+			}
+		}
+		d.resolveHandlers()
+	}, options...)
+
+	b := bytes.NewBufferString(``)
+	if format == HTMLDoc {
+		d.writeHTML(b)
+	} else {
+		d.writeMarkdown(b)
+	}
+	if _, err := w.Write(b.Bytes()); err != nil {
+		panic(err)
+	}
+}
+
+type sourceFile struct {
+	moduleDir string
+	path      string
+	typeSet   bool
+}
+
+// sourceFiles returns the manifests and TypeSet files found in the given paths. The module directory
+// of a manifest is the directory that was given, or the directory of the file when a file was
+// given.
+func sourceFiles(paths []string) []sourceFile {
+	var files []sourceFile
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			panic(px.Error(px.UnableToReadFile, issue.H{`path`: root, `detail`: err.Error()}))
+		}
+		if !info.IsDir() {
+			files = append(files, sourceFile{filepath.Dir(root), root, filepath.Base(filepath.Dir(root)) == `types`})
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != root && strings.HasPrefix(info.Name(), `.`) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, `.pp`) && !strings.HasSuffix(path, `_test.pp`) {
+				rel, _ := filepath.Rel(root, path)
+				files = append(files, sourceFile{root, path, contains(strings.Split(filepath.ToSlash(filepath.Dir(rel)), `/`), `types`)})
+			}
+			return nil
+		})
+		if err != nil {
+			panic(px.Error(px.UnableToReadFile, issue.H{`path`: root, `detail`: err.Error()}))
+		}
+	}
+	return files
+}
+
+type doc struct {
+	manifests []*manifestDoc
+	types     map[string]*typeDoc
+	handlers  map[string]string
+}
+
+type manifestDoc struct {
+	path        string
+	service     string
+	version     string
+	description string
+	steps       []*stepDoc
+}
+
+type stepDoc struct {
+	name         string
+	style        string
+	description  string
+	when         string
+	call         string
+	resourceType string
+	parameters   []paramDoc
+	returns      []paramDoc
+	iteration    *iterationDoc
+	steps        []*stepDoc
+}
+
+type paramDoc struct {
+	name  string
+	typ   string
+	value string
+	alias string
+}
+
+type iterationDoc struct {
+	style     string
+	over      string
+	into      string
+	variables []paramDoc
+}
+
+type typeDoc struct {
+	name        string
+	description string
+	handler     string
+	attributes  []attributeDoc
+}
+
+type attributeDoc struct {
+	name      string
+	typ       string
+	value     string
+	immutable bool
+	provided  bool
+}
+
+// docComments holds the comments that precede the steps and the type declarations of a manifest,
+// keyed by the name of the step definition and the name of the type respectively.
+type docComments struct {
+	steps map[string]string
+	types map[string]string
+}
+
+func (d *doc) addManifest(ml *manifestLoader, moduleDir, path string) {
+	name, ms, ast := ml.loadManifest(moduleDir, path, true)
+	c := ms.ctx
+	comments := collectComments(ast)
+	md := &manifestDoc{path: filepath.ToSlash(path), service: name}
+	ts, defs := ms.Metadata()
+	for _, def := range defs {
+		props := def.Properties()
+		switch props.Get5(`style`, px.Undef).String() {
+		case `service`:
+			if v, ok := props.Get4(`version`); ok {
+				md.version = v.String()
+			}
+			if v, ok := props.Get4(`description`); ok {
+				md.description = v.String()
+			}
+		case `callable`:
+			if t, ok := props.Get4(`handlerFor`); ok {
+				d.handlers[t.(px.Type).Name()] = def.Identifier().Name()
+			}
+		default:
+			md.steps = append(md.steps, d.step(c, def, comments))
+		}
+	}
+	if ts != nil {
+		ts.Types().EachValue(func(v px.Value) {
+			if ot, ok := v.(px.ObjectType); ok && isResourceType(c, ot) {
+				d.addType(c, ot, comments.types[ot.Name()])
+			}
+		})
+	}
+	d.manifests = append(d.manifests, md)
+}
+
+func (d *doc) addTypeSet(c px.Context, ts px.TypeSet) {
+	ts.Types().EachValue(func(v px.Value) {
+		ot, ok := v.(px.ObjectType)
+		if !ok {
+			return
+		}
+		if isHandlerType(ot) {
+			if rt := handledType(ot); rt != nil {
+				if _, ok := d.handlers[rt.Name()]; !ok {
+					d.handlers[rt.Name()] = ot.Name()
+				}
+			}
+		} else if isResourceType(c, ot) {
+			d.addType(c, ot, ``)
+		}
+	})
+}
+
+func (d *doc) addType(c px.Context, ot px.ObjectType, description string) {
+	if td, ok := d.types[ot.Name()]; ok {
+		if td.description == `` {
+			td.description = description
+		}
+		return
+	}
+	d.types[ot.Name()] = &typeDoc{name: ot.Name(), description: description, handler: typeSetHandler(c, ot.Name()), attributes: attributeDocs(c, ot)}
+}
+
+func attributeDocs(c px.Context, ot px.ObjectType) []attributeDoc {
+	var immutable, provided []string
+	if a, ok := ot.Annotations(c).Get(annotation.ResourceType); ok {
+		immutable = a.(annotation.Resource).ImmutableAttributes()
+		provided = a.(annotation.Resource).ProvidedAttributes()
+	}
+	var ads []attributeDoc
+	for _, a := range ot.AttributesInfo().Attributes() {
+		ad := attributeDoc{name: a.Name(), typ: a.Type().String(), immutable: contains(immutable, a.Name()), provided: contains(provided, a.Name())}
+		if a.HasValue() {
+			ad.value = valueString(a.Value())
+		}
+		ads = append(ads, ad)
+	}
+	return ads
+}
+
+// resolveHandlers assigns the handlers that manifests register, or that TypeSet files declare, to
+// the resource types. They take precedence over a handler type that is declared in the TypeSet of
+// the resource type.
+func (d *doc) resolveHandlers() {
+	for name, td := range d.types {
+		if h, ok := d.handlers[name]; ok {
+			td.handler = h
+		}
+	}
+}
+
+// typeSetHandler returns the name of the handler type that is declared for the resource type with
+// the given name in its TypeSet, or an empty string when there is no such handler type.
+func typeSetHandler(c px.Context, name string) string {
+	i := strings.LastIndex(name, `::`)
+	if i < 0 {
+		return ``
+	}
+	handler := ``
+	if t, ok := px.Load(c, px.NewTypedName(px.NsType, name[:i])); ok {
+		if ts, ok := t.(px.TypeSet); ok {
+			ts.Types().EachValue(func(v px.Value) {
+				if ot, ok := v.(px.ObjectType); ok && isHandlerType(ot) {
+					if rt := handledType(ot); rt != nil && rt.Name() == name {
+						handler = ot.Name()
+					}
+				}
+			})
+		}
+	}
+	return handler
+}
+
+// step returns the documentation of the step with the given definition. An iterator is documented
+// as the step that it iterates.
+func (d *doc) step(c px.Context, def serviceapi.Definition, comments *docComments) *stepDoc {
+	props := def.Properties()
+	style := props.Get5(`style`, px.Undef).String()
+	if style == `iterator` {
+		if producer, ok := props.Get5(`producer`, px.Undef).(serviceapi.Definition); ok {
+			sd := d.step(c, producer, comments)
+			sd.iteration = &iterationDoc{
+				style:     props.Get5(`iterationStyle`, px.Undef).String(),
+				over:      valueString(props.Get5(`over`, px.Undef)),
+				variables: paramDocs(props, `variables`)}
+			if into, ok := props.Get4(`into`); ok {
+				sd.iteration.into = into.String()
+			}
+			return sd
+		}
+	}
+
+	name := def.Identifier().Name()
+	sd := &stepDoc{
+		name:        name,
+		style:       style,
+		description: comments.steps[name],
+		parameters:  paramDocs(props, `parameters`),
+		returns:     paramDocs(props, `returns`)}
+	if v, ok := props.Get4(`when`); ok {
+		sd.when = v.String()
+	}
+	if v, ok := props.Get4(`call`); ok {
+		sd.call = v.String()
+	}
+	if v, ok := props.Get4(`resourceType`); ok {
+		if ot, ok := v.(px.ObjectType); ok {
+			sd.resourceType = ot.Name()
+			d.addType(c, ot, ``)
+		}
+	}
+	if v, ok := props.Get4(`steps`); ok {
+		v.(px.List).Each(func(sv px.Value) {
+			sd.steps = append(sd.steps, d.step(c, sv.(serviceapi.Definition), comments))
+		})
+	}
+	return sd
+}
+
+func paramDocs(props px.OrderedMap, key string) []paramDoc {
+	var ps []paramDoc
+	if v, ok := props.Get4(key); ok {
+		v.(px.List).Each(func(pv px.Value) {
+			p := pv.(serviceapi.Parameter)
+			pd := paramDoc{name: p.Name(), typ: p.Type().String(), alias: p.Alias()}
+			if p.Value() != nil {
+				pd.value = valueString(p.Value())
+			}
+			ps = append(ps, pd)
+		})
+	}
+	return ps
+}
+
+// valueString returns the given value as it would be written in a manifest. A Deferred function
+// call, such as the default value lookup('key'), is written as that call.
+func valueString(v px.Value) string {
+	if df, ok := v.(types.Deferred); ok {
+		args := make([]string, 0, df.Arguments().Len())
+		df.Arguments().Each(func(a px.Value) { args = append(args, valueString(a)) })
+		return df.Name() + `(` + strings.Join(args, `, `) + `)`
+	}
+	if s, ok := v.(px.StringValue); ok {
+		b := bytes.NewBufferString(``)
+		utils.PuppetQuote(b, s.String())
+		return b.String()
+	}
+	return px.ToString(v)
+}
+
+// isHandlerType returns true if the given type has functions but no attributes, which is how
+// TypeSets declare the handlers of their resource types.
+func isHandlerType(ot px.ObjectType) bool {
+	return len(ot.AttributesInfo().Attributes()) == 0 && len(ot.Functions(true)) > 0
+}
+
+func isResourceType(c px.Context, ot px.ObjectType) bool {
+	_, ok := ot.Annotations(c).Get(annotation.ResourceType)
+	return ok
+}
+
+// collectComments returns the comments that precede the steps and the type declarations of the
+// given manifest.
+func collectComments(ast parser.Expression) *docComments {
+	dc := &docComments{steps: make(map[string]string), types: make(map[string]string)}
+	var walk func(prefix string, stmts []parser.Expression)
+	walk = func(prefix string, stmts []parser.Expression) {
+		for _, stmt := range stmts {
+			switch stmt := stmt.(type) {
+			case *parser.StepExpression:
+				name := prefix + leafName(stmt.Name())
+				dc.steps[name] = docComment(stmt)
+				if block, ok := stmt.Definition().(*parser.BlockExpression); ok {
+					walk(name+`::`, block.Statements())
+				}
+			case *parser.FunctionDefinition:
+				dc.steps[prefix+stmt.Name()] = docComment(stmt)
+			case *parser.TypeAlias:
+				dc.types[stmt.Name()] = docComment(stmt)
+			}
+		}
+	}
+	walk(``, statements(ast))
+	return dc
+}
+
+// statements returns the top level statements of the given program.
+func statements(ast parser.Expression) []parser.Expression {
+	if p, ok := ast.(*parser.Program); ok {
+		ast = p.Body()
+	}
+	if b, ok := ast.(*parser.BlockExpression); ok {
+		return b.Statements()
+	}
+	return []parser.Expression{ast}
+}
+
+// docComment returns the text of the comment lines that immediately precede the given expression
+// with the leading # and one space removed from each line. The expression of a step starts at its
+// name, so the style of the step may precede it on the same line.
+func docComment(e parser.Expression) string {
+	lines := strings.Split(e.Locator().String()[:e.ByteOffset()], "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != `` && !keyword.MatchString(last) {
+		return ``
+	}
+	var comment []string
+	for i := len(lines) - 2; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, `#`) {
+			break
+		}
+		comment = append([]string{strings.TrimPrefix(strings.TrimPrefix(line, `#`), ` `)}, comment...)
+	}
+	return strings.Join(comment, "\n")
+}
+
+var keyword = regexp.MustCompile(`\A[a-z][A-Za-z]*\z`)
+
+func leafName(name string) string {
+	return name[strings.LastIndex(name, `:`)+1:]
+}
+
+func (d *doc) sortedTypes() []*typeDoc {
+	tds := make([]*typeDoc, 0, len(d.types))
+	for _, td := range d.types {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i].name < tds[j].name })
+	return tds
+}
+
+func (d *doc) writeMarkdown(b *bytes.Buffer) {
+	b.WriteString("# Reference\n")
+	if len(d.manifests) > 0 {
+		b.WriteString("\n## Manifests\n")
+	}
+	for _, md := range d.manifests {
+		fmt.Fprintf(b, "\n### %s\n\nFile: `%s`", md.service, md.path)
+		if md.version != `` {
+			fmt.Fprintf(b, "  \nVersion: %s", md.version)
+		}
+		b.WriteByte('\n')
+		if md.description != `` {
+			fmt.Fprintf(b, "\n%s\n", md.description)
+		}
+		for _, sd := range md.steps {
+			writeMarkdownStep(b, sd, 4)
+		}
+	}
+
+	tds := d.sortedTypes()
+	if len(tds) > 0 {
+		b.WriteString("\n## Resource types\n")
+	}
+	for _, td := range tds {
+		fmt.Fprintf(b, "\n### %s\n", td.name)
+		if td.description != `` {
+			fmt.Fprintf(b, "\n%s\n", td.description)
+		}
+		if td.handler != `` {
+			fmt.Fprintf(b, "\nHandler: `%s`\n", td.handler)
+		}
+		if len(td.attributes) > 0 {
+			b.WriteString("\n| Attribute | Type | Default | Flags |\n| --- | --- | --- | --- |\n")
+			for _, a := range td.attributes {
+				fmt.Fprintf(b, "| %s | %s | %s | %s |\n", a.name, mdCode(a.typ), mdCode(a.value), strings.Join(a.flags(), `, `))
+			}
+		}
+	}
+}
+
+func writeMarkdownStep(b *bytes.Buffer, sd *stepDoc, level int) {
+	if level > 6 {
+		level = 6
+	}
+	fmt.Fprintf(b, "\n%s %s\n\n", strings.Repeat(`#`, level), sd.name)
+	if sd.description != `` {
+		fmt.Fprintf(b, "%s\n\n", sd.description)
+	}
+	fmt.Fprintf(b, "- Style: %s\n", sd.style)
+	if sd.resourceType != `` {
+		fmt.Fprintf(b, "- Resource type: [%s](#%s)\n", sd.resourceType, mdAnchor(sd.resourceType))
+	}
+	if sd.call != `` {
+		fmt.Fprintf(b, "- Calls: `%s`\n", sd.call)
+	}
+	if sd.when != `` {
+		fmt.Fprintf(b, "- When: `%s`\n", sd.when)
+	}
+	if it := sd.iteration; it != nil {
+		fmt.Fprintf(b, "- Iteration: `%s` over `%s`", it.style, it.over)
+		if len(it.variables) > 0 {
+			vs := make([]string, len(it.variables))
+			for i, v := range it.variables {
+				vs[i] = v.typ + ` $` + v.name
+			}
+			fmt.Fprintf(b, " with `%s`", strings.Join(vs, `, `))
+		}
+		if it.into != `` {
+			fmt.Fprintf(b, " into `%s`", it.into)
+		}
+		b.WriteByte('\n')
+	}
+	writeMarkdownParams(b, `Parameters`, sd.parameters)
+	writeMarkdownParams(b, `Returns`, sd.returns)
+	for _, cd := range sd.steps {
+		writeMarkdownStep(b, cd, level+1)
+	}
+}
+
+func writeMarkdownParams(b *bytes.Buffer, title string, ps []paramDoc) {
+	if len(ps) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%s:\n\n| Name | Type | Default | Alias |\n| --- | --- | --- | --- |\n", title)
+	for _, p := range ps {
+		fmt.Fprintf(b, "| %s | %s | %s | %s |\n", p.name, mdCode(p.typ), mdCode(p.value), p.alias)
+	}
+}
+
+// mdCode returns the given string as inline code that can be used in a table cell.
+func mdCode(s string) string {
+	if s == `` {
+		return ``
+	}
+	return "`" + strings.Replace(strings.Replace(s, "\n", ` `, -1), `|`, `\|`, -1) + "`"
+}
+
+// mdAnchor returns the anchor that Markdown renderers generate for the given heading.
+func mdAnchor(heading string) string {
+	return strings.ToLower(strings.Replace(heading, `::`, ``, -1))
+}
+
+func (d *doc) writeHTML(b *bytes.Buffer) {
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Reference</title>\n</head>\n<body>\n<h1>Reference</h1>\n")
+	if len(d.manifests) > 0 {
+		b.WriteString("<h2>Manifests</h2>\n")
+	}
+	for _, md := range d.manifests {
+		fmt.Fprintf(b, "<h3>%s</h3>\n<p>File: <code>%s</code>", html.EscapeString(md.service), html.EscapeString(md.path))
+		if md.version != `` {
+			fmt.Fprintf(b, "<br>\nVersion: %s", html.EscapeString(md.version))
+		}
+		b.WriteString("</p>\n")
+		writeHTMLDescription(b, md.description)
+		for _, sd := range md.steps {
+			writeHTMLStep(b, sd, 4)
+		}
+	}
+
+	tds := d.sortedTypes()
+	if len(tds) > 0 {
+		b.WriteString("<h2>Resource types</h2>\n")
+	}
+	for _, td := range tds {
+		fmt.Fprintf(b, "<h3 id=\"%s\">%s</h3>\n", html.EscapeString(mdAnchor(td.name)), html.EscapeString(td.name))
+		writeHTMLDescription(b, td.description)
+		if td.handler != `` {
+			fmt.Fprintf(b, "<p>Handler: <code>%s</code></p>\n", html.EscapeString(td.handler))
+		}
+		if len(td.attributes) > 0 {
+			b.WriteString("<table>\n<tr><th>Attribute</th><th>Type</th><th>Default</th><th>Flags</th></tr>\n")
+			for _, a := range td.attributes {
+				fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+					html.EscapeString(a.name), htmlCode(a.typ), htmlCode(a.value), html.EscapeString(strings.Join(a.flags(), `, `)))
+			}
+			b.WriteString("</table>\n")
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+}
+
+func writeHTMLStep(b *bytes.Buffer, sd *stepDoc, level int) {
+	if level > 6 {
+		level = 6
+	}
+	fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, html.EscapeString(sd.name), level)
+	writeHTMLDescription(b, sd.description)
+	fmt.Fprintf(b, "<ul>\n<li>Style: %s</li>\n", html.EscapeString(sd.style))
+	if sd.resourceType != `` {
+		fmt.Fprintf(b, "<li>Resource type: <a href=\"#%s\">%s</a></li>\n", html.EscapeString(mdAnchor(sd.resourceType)), html.EscapeString(sd.resourceType))
+	}
+	if sd.call != `` {
+		fmt.Fprintf(b, "<li>Calls: %s</li>\n", htmlCode(sd.call))
+	}
+	if sd.when != `` {
+		fmt.Fprintf(b, "<li>When: %s</li>\n", htmlCode(sd.when))
+	}
+	if it := sd.iteration; it != nil {
+		fmt.Fprintf(b, "<li>Iteration: %s over %s", htmlCode(it.style), htmlCode(it.over))
+		if len(it.variables) > 0 {
+			vs := make([]string, len(it.variables))
+			for i, v := range it.variables {
+				vs[i] = v.typ + ` $` + v.name
+			}
+			fmt.Fprintf(b, " with %s", htmlCode(strings.Join(vs, `, `)))
+		}
+		if it.into != `` {
+			fmt.Fprintf(b, " into %s", htmlCode(it.into))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	writeHTMLParams(b, `Parameters`, sd.parameters)
+	writeHTMLParams(b, `Returns`, sd.returns)
+	for _, cd := range sd.steps {
+		writeHTMLStep(b, cd, level+1)
+	}
+}
+
+func writeHTMLParams(b *bytes.Buffer, title string, ps []paramDoc) {
+	if len(ps) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "<p>%s:</p>\n<table>\n<tr><th>Name</th><th>Type</th><th>Default</th><th>Alias</th></tr>\n", title)
+	for _, p := range ps {
+		fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n", html.EscapeString(p.name), htmlCode(p.typ), htmlCode(p.value), html.EscapeString(p.alias))
+	}
+	b.WriteString("</table>\n")
+}
+
+func writeHTMLDescription(b *bytes.Buffer, description string) {
+	if description == `` {
+		return
+	}
+	for _, para := range strings.Split(description, "\n\n") {
+		fmt.Fprintf(b, "<p>%s</p>\n", html.EscapeString(para))
+	}
+}
+
+func htmlCode(s string) string {
+	if s == `` {
+		return ``
+	}
+	return `<code>` + html.EscapeString(s) + `</code>`
+}
+
+func (a attributeDoc) flags() []string {
+	var flags []string
+	if a.immutable {
+		flags = append(flags, `immutable`)
+	}
+	if a.provided {
+		flags = append(flags, `provided`)
+	}
+	return flags
+}
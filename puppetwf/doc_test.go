@@ -0,0 +1,58 @@
+package puppetwf_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDoc(t *testing.T) {
+	b := bytes.NewBufferString(``)
+	puppetwf.WriteDoc(b, puppetwf.MarkdownDoc, []string{`testdata/doc_example.pp`})
+	if *update {
+		require.NoError(t, ioutil.WriteFile(`testdata/doc_example.md`, b.Bytes(), 0644))
+		return
+	}
+	expected, err := ioutil.ReadFile(`testdata/doc_example.md`)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), b.String())
+}
+
+func TestWriteDocHTML(t *testing.T) {
+	b := bytes.NewBufferString(``)
+	puppetwf.WriteDoc(b, puppetwf.HTMLDoc, []string{`testdata/doc_example.pp`})
+	doc := b.String()
+	require.Contains(t, doc, "<h4>doc_example</h4>\n<p>Provisions a VPC and a number of subnets in it.</p>\n<p>The subnets are created in parallel.</p>\n")
+	require.Contains(t, doc, `<li>Resource type: <a href="#awsvpc">Aws::Vpc</a></li>`)
+	require.Contains(t, doc, `<li>Iteration: <code>range</code> over <code>[1, 3]</code> with <code>Integer $n</code></li>`)
+	require.Contains(t, doc, `<tr><td>tenancy</td><td><code>String</code></td><td><code>&#39;default&#39;</code></td><td></td></tr>`)
+	require.Contains(t, doc, `<h3 id="awsvpc">Aws::Vpc</h3>`+"\n"+`<p>Handler: <code>Aws::VPCHandler</code></p>`)
+	require.Contains(t, doc, `<tr><td>labelId</td><td><code>Optional[String]</code></td><td><code>undef</code></td><td>provided</td></tr>`)
+}
+
+func TestWriteDocUnknownFormat(t *testing.T) {
+	requirePanicContains(t, `unknown documentation format 'pdf'`, func() {
+		puppetwf.WriteDoc(ioutil.Discard, `pdf`, []string{`testdata/doc_example.pp`})
+	})
+}
+
+func TestWriteDocDoesNotRunManifest(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `doc`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, `untrusted.pp`)
+	touched := filepath.Join(dir, `touched`)
+	require.NoError(t, ioutil.WriteFile(manifest, []byte(fmt.Sprintf("$x = exec('touch', '%s')\n\nworkflow untrusted {\n} {\n}\n", touched)), 0644))
+
+	b := bytes.NewBufferString(``)
+	puppetwf.WriteDoc(b, puppetwf.MarkdownDoc, []string{manifest})
+	require.Contains(t, b.String(), `untrusted`)
+	_, err = os.Stat(touched)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,96 @@
+package puppetwf
+
+import (
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/service"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// amendRuntimeError must be deferred. It recovers from a panic raised while running the given
+// method of a step and panics again with a StepRuntimeError that carries the workflow, the path
+// of the step, the path of the manifest that declares it, the method, and the inputs of the call
+// with all Sensitive values redacted. The error is located at the innermost location found in the
+// chain of causes, or at the given location when no such location exists. The original error
+// becomes the cause.
+//
+// The error is an issue.Reported so it will be transferred as a structured error when it crosses
+// the gRPC boundary.
+func amendRuntimeError(c px.Context, step, method string, location issue.Location, inputs px.Value) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if ri, ok := r.(issue.Reported); ok && (ri.Code() == StepRuntimeError || ri.Code() == service.NotFound) {
+		panic(r)
+	}
+	if r == wf.NotFound {
+		panic(r)
+	}
+
+	err := wf.ToError(r)
+	if loc := innermostLocation(err); loc != nil {
+		location = loc
+	}
+	panic(issue.NewNested(StepRuntimeError, issue.H{
+		`workflow`: workflowName(step),
+		`step`:     step,
+		`path`:     strings.Replace(step, `::`, `/`, -1),
+		`manifest`: manifestOf(c),
+		`method`:   method,
+		`inputs`:   redactedValue(inputs)}, location, err))
+}
+
+// manifestOf returns the path of the manifest that the given context was created for. The name of
+// the service that represents the manifest is returned when the path is unknown.
+func manifestOf(c px.Context) string {
+	v, ok := c.Get(serviceNameKey)
+	if !ok {
+		return `unknown manifest`
+	}
+	if path, ok := ManifestPath(c, v.(string)); ok {
+		return path
+	}
+	return v.(string)
+}
+
+// innermostLocation returns the location of the innermost issue.Reported in the chain of causes
+// that has a location.
+func innermostLocation(err error) (location issue.Location) {
+	for err != nil {
+		ri, ok := err.(issue.Reported)
+		if !ok {
+			break
+		}
+		if loc := ri.Location(); loc != nil && loc.File() != `` {
+			location = loc
+		}
+		err = ri.Cause()
+	}
+	return
+}
+
+// redactedValue returns a copy of the given value where each Sensitive value has been replaced
+// by a string that doesn't reveal the value.
+func redactedValue(v px.Value) px.Value {
+	if v == nil {
+		return px.Undef
+	}
+	if !hasSensitive(v) {
+		return v
+	}
+	switch v := v.(type) {
+	case *types.Sensitive:
+		return types.WrapString(`[value redacted]`)
+	case px.OrderedMap:
+		return v.MapValues(redactedValue)
+	case px.List:
+		return v.Map(redactedValue)
+	case px.PuppetObject:
+		return redactedValue(v.InitHash())
+	}
+	return v
+}
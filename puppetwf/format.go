@@ -0,0 +1,603 @@
+package puppetwf
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-parser/parser"
+)
+
+// Format returns the manifest in src in canonical style. The manifest is parsed with the same
+// parser that LoadManifest uses, so a manifest that cannot be loaded cannot be formatted either.
+// Comments are preserved and the canonical style is:
+//
+// Two spaces of indentation for each nesting level, no trailing whitespace, and at most one blank
+// line between lines. Blank lines that follow an opening brace or precede a closing one are removed.
+//
+// Hashes that span several lines have one entry per line, a comma after each entry, and the
+// arrows of the entries aligned.
+//
+// The parameters and returns of a step are written on one line when they are a single parameter
+// or only names of variables, and one per line followed by a comma otherwise.
+//
+// The formatted manifest must parse to the same AST as the original. Format panics with a
+// FormatFailed issue when it doesn't.
+func Format(path string, src []byte) []byte {
+	ast := parseManifest(path, string(src))
+	f := &formatter{path: path, tokens: lexManifest(path, string(src))}
+	f.formatParameterLists()
+	f.formatHashes()
+	f.normalizeSpace()
+	result := f.emit()
+
+	fast, err := parser.CreateParser(parser.WorkflowEnabled).Parse(path, string(result), false)
+	if err != nil {
+		panic(px.Error(FormatFailed, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	if ast.ToPN().String() != fast.ToPN().String() {
+		panic(px.Error(FormatFailed, issue.H{`path`: path, `detail`: `the formatted manifest has a different meaning`}))
+	}
+	return result
+}
+
+func parseManifest(path, src string) parser.Expression {
+	ast, err := parser.CreateParser(parser.WorkflowEnabled).Parse(path, src, false)
+	if err != nil {
+		panic(err)
+	}
+	return ast
+}
+
+type tokenKind int
+
+const (
+	tkWord tokenKind = iota
+	tkPunct
+	tkOpen
+	tkClose
+	tkComma
+	tkArrow
+	tkString
+	tkComment
+	tkHeredoc
+)
+
+// fmtToken is a token of a manifest together with the whitespace that precedes it. Strings,
+// comments, and heredocs are single tokens that are emitted verbatim.
+type fmtToken struct {
+	kind     tokenKind
+	text     string
+	space    int
+	newlines int
+
+	// body is the text of a heredoc, up to and including its end tag
+	body string
+}
+
+type formatter struct {
+	path   string
+	tokens []*fmtToken
+}
+
+var heredocTag = regexp.MustCompile(`\A@\(\s*"?([^":/)]+)"?\s*(?::[^/)]*)?(?:/[^)]*)?\)`)
+
+// lexManifest splits the source into tokens. Only what determines the layout is distinguished,
+// everything else is a word or a punctuation character and keeps its original spacing.
+func lexManifest(path, src string) []*fmtToken {
+	var tokens []*fmtToken
+	var heredocs []*fmtToken
+	space, newlines := 0, 0
+	add := func(kind tokenKind, text string) *fmtToken {
+		t := &fmtToken{kind: kind, text: text, space: space, newlines: newlines}
+		tokens = append(tokens, t)
+		space, newlines = 0, 0
+		return t
+	}
+	fail := func(detail string) {
+		panic(px.Error(FormatFailed, issue.H{`path`: path, `detail`: detail}))
+	}
+
+	n := len(src)
+	for i := 0; i < n; {
+		c := src[i]
+		switch {
+		case c == '\n':
+			newlines++
+			i++
+			for _, h := range heredocs {
+				end := heredocEnd(src, i, h.body)
+				if end < 0 {
+					fail(`heredoc ` + h.text + ` has no end tag`)
+				}
+				h.body = src[i:end]
+				i = end
+				if i < n {
+					i++
+				}
+			}
+			heredocs = nil
+		case c == ' ' || c == '\t' || c == '\r':
+			space = 1
+			i++
+		case c == '#':
+			e := strings.IndexByte(src[i:], '\n')
+			if e < 0 {
+				e = n - i
+			}
+			add(tkComment, strings.TrimRight(src[i:i+e], " \t\r"))
+			i += e
+		case c == '/' && i+1 < n && src[i+1] == '*':
+			e := strings.Index(src[i+2:], `*/`)
+			if e < 0 {
+				fail(`unterminated comment`)
+			}
+			add(tkComment, src[i:i+e+4])
+			i += e + 4
+		case c == '\'' || c == '"':
+			e := stringEnd(src, i)
+			if e < 0 {
+				fail(`unterminated string`)
+			}
+			add(tkString, src[i:e])
+			i = e
+		case c == '/' && regexAllowed(tokens):
+			e := i + 1
+			for e < n && src[e] != '/' && src[e] != '\n' {
+				if src[e] == '\\' {
+					e++
+				}
+				e++
+			}
+			if e >= n || src[e] != '/' {
+				add(tkPunct, `/`)
+				i++
+				continue
+			}
+			add(tkString, src[i:e+1])
+			i = e + 1
+		case c == '@' && i+1 < n && src[i+1] == '(':
+			m := heredocTag.FindStringSubmatch(src[i:])
+			if m == nil {
+				fail(`invalid heredoc`)
+			}
+			h := add(tkHeredoc, m[0])
+			h.body = strings.TrimSpace(m[1])
+			heredocs = append(heredocs, h)
+			i += len(m[0])
+		case c == '=' && i+1 < n && src[i+1] == '>':
+			add(tkArrow, `=>`)
+			i += 2
+		case c == '{' || c == '[' || c == '(':
+			add(tkOpen, string(c))
+			i++
+		case c == '}' || c == ']' || c == ')':
+			add(tkClose, string(c))
+			i++
+		case c == ',':
+			add(tkComma, `,`)
+			i++
+		case isWordChar(c):
+			e := i + 1
+			for e < n && isWordChar(src[e]) {
+				e++
+			}
+			add(tkWord, src[i:e])
+			i = e
+		default:
+			add(tkPunct, string(c))
+			i++
+		}
+	}
+	if len(heredocs) > 0 {
+		fail(`heredoc ` + heredocs[0].text + ` has no end tag`)
+	}
+	if len(tokens) > 0 {
+		tokens[0].newlines = 0
+		tokens[0].space = 0
+	}
+	return tokens
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':' || c == '$'
+}
+
+// regexAllowed returns true when a slash that follows the given tokens starts a regular expression
+// rather than being a division.
+func regexAllowed(tokens []*fmtToken) bool {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		switch t := tokens[i]; t.kind {
+		case tkComment:
+			continue
+		case tkOpen, tkComma, tkArrow, tkPunct:
+			return true
+		case tkWord:
+			switch t.text {
+			case `and`, `or`, `in`, `case`, `if`, `elsif`, `unless`, `node`:
+				return true
+			}
+			return false
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// stringEnd returns the position after the quoted string that starts at i, or -1 when the string
+// is unterminated. Interpolated expressions in double quoted strings may contain strings.
+func stringEnd(src string, i int) int {
+	q := src[i]
+	n := len(src)
+	for i++; i < n; i++ {
+		switch c := src[i]; {
+		case c == '\\':
+			i++
+		case c == q:
+			return i + 1
+		case q == '"' && c == '$' && i+1 < n && src[i+1] == '{':
+			depth := 0
+			for i++; i < n; i++ {
+				switch src[i] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				case '\'', '"':
+					e := stringEnd(src, i)
+					if e < 0 {
+						return -1
+					}
+					i = e - 1
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// heredocEnd returns the position of the end of the line at or after i that ends the heredoc with
+// the given tag, or -1 when there is no such line.
+func heredocEnd(src string, i int, tag string) int {
+	for i <= len(src) {
+		e := strings.IndexByte(src[i:], '\n')
+		if e < 0 {
+			e = len(src) - i
+		}
+		line := strings.TrimSpace(src[i : i+e])
+		line = strings.TrimSpace(strings.TrimPrefix(line, `|`))
+		line = strings.TrimSpace(strings.TrimPrefix(line, `-`))
+		if line == tag {
+			return i + e
+		}
+		if i+e >= len(src) {
+			break
+		}
+		i += e + 1
+	}
+	return -1
+}
+
+// matches returns the index of the matching bracket for each bracket token.
+func (f *formatter) matches() map[int]int {
+	m := make(map[int]int)
+	var stack []int
+	for i, t := range f.tokens {
+		switch t.kind {
+		case tkOpen:
+			stack = append(stack, i)
+		case tkClose:
+			if len(stack) == 0 {
+				panic(px.Error(FormatFailed, issue.H{`path`: f.path, `detail`: `unbalanced '` + t.text + `'`}))
+			}
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			m[o] = i
+			m[i] = o
+		}
+	}
+	if len(stack) > 0 {
+		panic(px.Error(FormatFailed, issue.H{`path`: f.path, `detail`: `unbalanced '` + f.tokens[stack[0]].text + `'`}))
+	}
+	return m
+}
+
+// children returns the indexes of the tokens between the bracket at i and its match that are not
+// nested in other brackets.
+func (f *formatter) children(i int, m map[int]int) []int {
+	var cs []int
+	for k := i + 1; k < m[i]; k++ {
+		cs = append(cs, k)
+		if f.tokens[k].kind == tkOpen {
+			k = m[k]
+			cs = append(cs, k)
+		}
+	}
+	return cs
+}
+
+// formatParameterLists lays out the parameter lists of the parameters and returns properties.
+func (f *formatter) formatParameterLists() {
+	m := f.matches()
+	var result []*fmtToken
+	for i := 0; i < len(f.tokens); i++ {
+		t := f.tokens[i]
+		result = append(result, t)
+		if !(t.kind == tkWord && (t.text == `parameters` || t.text == `returns`) && i+2 < len(f.tokens) &&
+			f.tokens[i+1].kind == tkArrow && f.tokens[i+2].text == `(`) {
+			continue
+		}
+		end := m[i+2]
+		result = append(result, f.tokens[i+1])
+		result = append(result, f.parameterList(f.tokens[i+2:end+1])...)
+		i = end
+	}
+	f.tokens = result
+}
+
+// fmtParameter is a parameter in a parameter list together with the comments on the lines before
+// it and the comment at the end of its line.
+type fmtParameter struct {
+	comments []*fmtToken
+	tokens   []*fmtToken
+	trailing *fmtToken
+}
+
+// parameterList returns the tokens of the given parenthesized parameter list in canonical layout.
+// A list with comments is always written one parameter per line.
+func (f *formatter) parameterList(tokens []*fmtToken) []*fmtToken {
+	var params []*fmtParameter
+	p := &fmtParameter{}
+	var last *fmtParameter
+	var pending []*fmtToken
+	hasComments := false
+	depth := 0
+	for _, t := range tokens[1 : len(tokens)-1] {
+		switch t.kind {
+		case tkHeredoc:
+			return tokens
+		case tkComment:
+			hasComments = true
+			switch {
+			case t.newlines == 0 && len(p.tokens) > 0 && p.trailing == nil:
+				p.trailing = t
+			case t.newlines == 0 && len(p.tokens) == 0 && last != nil && last.trailing == nil:
+				last.trailing = t
+			default:
+				pending = append(pending, t)
+			}
+			continue
+		case tkOpen:
+			depth++
+		case tkClose:
+			depth--
+		case tkComma:
+			if depth == 0 {
+				params = append(params, p)
+				last = p
+				p = &fmtParameter{}
+				continue
+			}
+		}
+		if len(p.tokens) == 0 {
+			p.comments = pending
+			pending = nil
+		}
+		p.tokens = append(p.tokens, t)
+	}
+	if len(p.tokens) > 0 {
+		params = append(params, p)
+	}
+
+	inline := !hasComments
+	if inline && len(params) > 1 {
+		for _, p := range params {
+			if len(p.tokens) > 1 || !strings.HasPrefix(p.tokens[0].text, `$`) {
+				inline = false
+				break
+			}
+		}
+	}
+
+	open, close := tokens[0], tokens[len(tokens)-1]
+	result := []*fmtToken{open}
+	for i, p := range params {
+		for _, c := range p.comments {
+			c.newlines = 1
+			result = append(result, c)
+		}
+		for k, t := range p.tokens {
+			if t.newlines > 0 {
+				t.newlines = 0
+				t.space = 1
+			}
+			if k == 0 {
+				t.space = 0
+				if inline && i > 0 {
+					t.space = 1
+				}
+				if !inline {
+					t.newlines = 1
+				}
+			}
+		}
+		result = append(result, p.tokens...)
+		if !inline || i < len(params)-1 {
+			result = append(result, &fmtToken{kind: tkComma, text: `,`})
+		}
+		if p.trailing != nil {
+			result = append(result, p.trailing)
+		}
+	}
+	for _, c := range pending {
+		c.newlines = 1
+		result = append(result, c)
+	}
+	close.space = 0
+	close.newlines = 0
+	if !inline {
+		close.newlines = 1
+	}
+	return append(result, close)
+}
+
+// formatHashes adds a trailing comma to the last entry of each hash that spans several lines.
+func (f *formatter) formatHashes() {
+	m := f.matches()
+	commas := make(map[int]bool)
+	for i, t := range f.tokens {
+		if t.text != `{` || f.tokens[m[i]].newlines == 0 || !f.isHash(i, m) {
+			continue
+		}
+		for k := m[i] - 1; k > i; k-- {
+			if f.tokens[k].kind == tkComment {
+				continue
+			}
+			if f.tokens[k].kind != tkComma && f.tokens[k].kind != tkArrow && f.tokens[k].text != `;` {
+				commas[k] = true
+			}
+			break
+		}
+	}
+	if len(commas) == 0 {
+		return
+	}
+	var result []*fmtToken
+	for i, t := range f.tokens {
+		result = append(result, t)
+		if commas[i] {
+			result = append(result, &fmtToken{kind: tkComma, text: `,`})
+		}
+	}
+	f.tokens = result
+}
+
+// isHash returns true when the brace at i has entries with arrows that are not nested in other
+// brackets.
+func (f *formatter) isHash(i int, m map[int]int) bool {
+	for _, k := range f.children(i, m) {
+		if f.tokens[k].kind == tkArrow {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeSpace reduces the whitespace between tokens to a single space or line break, and a
+// blank line at most.
+func (f *formatter) normalizeSpace() {
+	for i, t := range f.tokens {
+		if t.space > 1 {
+			t.space = 1
+		}
+		if t.newlines > 2 {
+			t.newlines = 2
+		}
+		if i == 0 {
+			continue
+		}
+		prev := f.tokens[i-1]
+		if prev.kind == tkOpen && t.newlines > 1 || t.kind == tkClose && t.newlines > 1 {
+			t.newlines = 1
+		}
+		if t.newlines > 0 {
+			continue
+		}
+		switch {
+		case t.kind == tkArrow, prev.kind == tkArrow:
+			t.space = 1
+		case t.kind == tkComment:
+			t.space = 1
+		case prev.kind == tkComma && t.kind != tkClose:
+			t.space = 1
+		case t.kind == tkComma:
+			t.space = 0
+		case prev.text == `}` && t.text == `{`:
+			t.space = 1
+		}
+	}
+}
+
+// emit writes the tokens with the indentation of their nesting level and aligns the arrows of
+// hashes that span several lines.
+func (f *formatter) emit() []byte {
+	m := f.matches()
+	for i, t := range f.tokens {
+		if t.text == `{` && f.tokens[m[i]].newlines > 0 && f.isHash(i, m) {
+			f.alignArrows(i, m)
+		}
+	}
+
+	b := bytes.NewBufferString(``)
+	indents := make(map[int]int)
+	var stack []int
+	indent := 0
+	var heredocs []*fmtToken
+	for i, t := range f.tokens {
+		if i == 0 || t.newlines > 0 {
+			for _, h := range heredocs {
+				b.WriteByte('\n')
+				b.WriteString(h.body)
+			}
+			heredocs = nil
+			if i > 0 {
+				b.WriteString(strings.Repeat("\n", t.newlines))
+			}
+			switch {
+			case t.kind == tkClose:
+				indent = indents[m[i]]
+			case len(stack) > 0:
+				indent = indents[stack[len(stack)-1]] + 2
+			default:
+				indent = 0
+			}
+			b.WriteString(strings.Repeat(` `, indent))
+		} else {
+			b.WriteString(strings.Repeat(` `, t.space))
+		}
+		b.WriteString(t.text)
+
+		switch t.kind {
+		case tkOpen:
+			indents[i] = indent
+			stack = append(stack, i)
+		case tkClose:
+			stack = stack[:len(stack)-1]
+		case tkHeredoc:
+			heredocs = append(heredocs, t)
+		}
+	}
+	for _, h := range heredocs {
+		b.WriteByte('\n')
+		b.WriteString(h.body)
+	}
+	if b.Len() > 0 {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// alignArrows pads the space before the arrow of each entry of the hash at i that starts a line
+// with a single token key, so that all such arrows line up.
+func (f *formatter) alignArrows(i int, m map[int]int) {
+	var keys []int
+	width := 0
+	cs := f.children(i, m)
+	for n, k := range cs {
+		if n+1 < len(cs) && f.tokens[k].newlines > 0 && f.tokens[k].kind != tkComment && cs[n+1] == k+1 && f.tokens[k+1].kind == tkArrow {
+			keys = append(keys, k)
+			if w := len(f.tokens[k].text); w > width {
+				width = w
+			}
+		}
+	}
+	for _, k := range keys {
+		f.tokens[k+1].space = width - len(f.tokens[k].text) + 1
+	}
+}
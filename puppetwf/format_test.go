@@ -0,0 +1,81 @@
+package puppetwf_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	src, err := ioutil.ReadFile(`testdata/format/example.in`)
+	require.NoError(t, err)
+	formatted := puppetwf.Format(`example.pp`, src)
+	if *update {
+		require.NoError(t, ioutil.WriteFile(`testdata/format/example.out`, formatted, 0644))
+		return
+	}
+	expected, err := ioutil.ReadFile(`testdata/format/example.out`)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(formatted))
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	err := filepath.Walk(`testdata`, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, `.pp`) {
+			return err
+		}
+		t.Run(path, func(t *testing.T) {
+			src, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			formatted := puppetwf.Format(path, src)
+			require.Equal(t, string(formatted), string(puppetwf.Format(path, formatted)))
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestFormatParameterLists(t *testing.T) {
+	formatted := puppetwf.Format(`params.pp`, []byte(`workflow params {
+  parameters => (String $a),
+  returns => ($b,
+    $c)
+} {
+  action act {
+    parameters => (String $a,Integer $n = 1),
+    returns => ($b, String $c)
+  } {
+    return({ b => $a, c => "${n}" })
+  }
+}
+`))
+	require.Equal(t, `workflow params {
+  parameters => (String $a),
+  returns    => ($b, $c),
+} {
+  action act {
+    parameters => (
+      String $a,
+      Integer $n = 1,
+    ),
+    returns    => (
+      $b,
+      String $c,
+    ),
+  } {
+    return({ b => $a, c => "${n}" })
+  }
+}
+`, string(formatted))
+}
+
+func TestFormatParseError(t *testing.T) {
+	requirePanicContains(t, `expected attribute name`, func() {
+		puppetwf.Format(`broken.pp`, []byte("workflow broken {\n"))
+	})
+}
@@ -9,11 +9,59 @@ import (
 	"github.com/lyraproj/issue/issue"
 	"github.com/lyraproj/pcore/px"
 	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
 	"github.com/lyraproj/servicesdk/service"
 )
 
 const ServerBuilderKey = `WF::ServerBuilder`
 
+// blockKey holds the block that was passed to the action that is being evaluated. It is called by
+// yield().
+const blockKey = `Puppet::Block`
+
+// sandboxKey is set in the context of a manifest that is loaded for analysis only, such as by the
+// linter, the doc generator, and the language server. Such manifests are untrusted, so functions
+// that run commands or read external data are stubbed: exec() returns an empty string, lookup()
+// returns its default value or undef, and memoryHandler() ignores its file.
+const sandboxKey = `Puppet::Sandbox`
+
+// sandboxed returns true if the given context belongs to a manifest that is loaded for analysis only.
+func sandboxed(c px.Context) bool {
+	_, ok := c.Get(sandboxKey)
+	return ok
+}
+
+// registerHandlers registers the handlers of the top level registerHandler() calls of the given
+// program without evaluating it. It is used in place of evaluating the top level statements when
+// a manifest is loaded for analysis only. Only handlers created by memoryHandler() are registered
+// since any other handler must be evaluated to be created.
+func registerHandlers(c pdsl.EvaluationContext, sb *service.Builder, ast parser.Expression) {
+	for _, call := range topLevelCalls(ast, `registerHandler`) {
+		args := call.Arguments()
+		if len(args) != 2 {
+			continue
+		}
+		hc, ok := args[1].(*parser.CallNamedFunctionExpression)
+		if !ok || len(hc.Arguments()) == 0 {
+			continue
+		}
+		if qn, ok := hc.Functor().(*parser.QualifiedName); !ok || qn.Name() != `memoryHandler` {
+			continue
+		}
+		t, ok := c.ResolveType(args[0]).(px.ObjectType)
+		if !ok {
+			continue
+		}
+		st, ok := c.ResolveType(hc.Arguments()[0]).(px.ObjectType)
+		if !ok {
+			continue
+		}
+		handler := NewMemoryHandler(c, st, ``)
+		sb.RegisterHandler(handler.PType().Name(), handler, t)
+	}
+}
+
 func stringArgs(args []px.Value) []string {
 	l := len(args)
 	if l == 0 {
@@ -44,11 +92,60 @@ func init() {
 		},
 	)
 
+	// metadata and serviceName are read from the AST when the manifest is loaded. Evaluating the
+	// calls does nothing.
+	px.NewGoFunction(`metadata`,
+		func(d px.Dispatch) {
+			d.Param(`Hash[String,Any]`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				return px.Undef
+			})
+		},
+	)
+
+	px.NewGoFunction(`serviceName`,
+		func(d px.Dispatch) {
+			d.Param(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				return px.Undef
+			})
+		},
+	)
+
+	px.NewGoFunction(`memoryHandler`,
+		func(d px.Dispatch) {
+			d.Param(`Type[Object]`)
+			d.OptionalParam(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				file := ``
+				if len(args) > 1 && !sandboxed(c) {
+					file = args[1].String()
+				}
+				return NewMemoryHandler(c, args[0].(px.ObjectType), file)
+			})
+		},
+	)
+
+	px.NewGoFunction(`yield`,
+		func(d px.Dispatch) {
+			d.RepeatedParam(`Any`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if b, ok := c.Get(blockKey); ok {
+					return b.(px.Lambda).Call(c, nil, args...)
+				}
+				panic(px.Error(NoBlockGiven, issue.NoArgs))
+			})
+		},
+	)
+
 	px.NewGoFunction(`exec`,
 		func(d px.Dispatch) {
 			d.Param(`String`)
 			d.RepeatedParam(`Variant[String,Number,Boolean]`)
 			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if sandboxed(c) {
+					return px.EmptyString
+				}
 				cmd := exec.Command(args[0].String(), stringArgs(args[1:])...)
 				var out bytes.Buffer
 				cmd.Stdout = &out
@@ -0,0 +1,348 @@
+package puppetwf
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/annotation"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// GenerateGo writes Go source code that declares the types of the given TypeSet in the package
+// pkg. The source contains:
+//
+// A struct for each object type that has attributes. Attributes that are listed in the
+// Lyra::Resource annotation of the type are tagged with the ResourceTag, so GenerateTypeSet
+// creates the same annotation from the struct.
+//
+// A struct for each handler type, that is, an object type that has functions but no attributes,
+// together with an interface that is named after it with the suffix API. The methods of the
+// struct have the signatures of the functions and panic until they are implemented.
+//
+// A Register function that registers the types and the handlers with a service.Builder.
+func GenerateGo(c px.Context, ts px.TypeSet, pkg string, w io.Writer) {
+	g := &goGen{c: c, names: make(map[px.Type]string), namespace: ts.Name()}
+	var objects, handlers []px.ObjectType
+	ts.Types().EachValue(func(v px.Value) {
+		if ot, ok := v.(px.ObjectType); ok {
+			g.names[ot] = issue.SnakeToCamelCase(wf.LeafName(ot.Name()))
+			if isHandlerType(ot) {
+				handlers = append(handlers, ot)
+			} else {
+				objects = append(objects, ot)
+			}
+		}
+	})
+
+	for _, ot := range objects {
+		g.writeStruct(g.names[ot], ot)
+	}
+	for i := 0; i < len(g.anonymous); i++ {
+		g.writeStruct(g.names[g.anonymous[i]], g.anonymous[i])
+	}
+	for _, ht := range handlers {
+		g.writeHandler(ht)
+	}
+	g.writeRegister(objects, handlers)
+
+	imports := []string{`"github.com/lyraproj/servicesdk/service"`}
+	if g.time {
+		imports = append(imports, `"time"`)
+	}
+	sort.Strings(imports)
+	src := bytes.NewBufferString("// this file is generated\npackage " + pkg + "\n\nimport (\n" + strings.Join(imports, "\n") + "\n)\n")
+	src.Write(g.b.Bytes())
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	if _, err = w.Write(formatted); err != nil {
+		panic(err)
+	}
+}
+
+type goGen struct {
+	c         px.Context
+	b         bytes.Buffer
+	namespace string
+	names     map[px.Type]string
+	anonymous []px.ObjectType
+	time      bool
+}
+
+func (g *goGen) writeStruct(name string, ot px.ObjectType) {
+	var immutable, provided []string
+	if a, ok := ot.Annotations(g.c).Get(annotation.ResourceType); ok {
+		immutable = a.(annotation.Resource).ImmutableAttributes()
+		provided = a.(annotation.Resource).ProvidedAttributes()
+	}
+
+	fmt.Fprintf(&g.b, "\ntype %s struct {\n", name)
+	for _, a := range ot.AttributesInfo().Attributes() {
+		fn := issue.SnakeToCamelCase(a.Name())
+		at := a.Type()
+		if a.HasValue() {
+			if _, ok := at.(*types.OptionalType); !ok {
+				at = types.NewOptionalType(at)
+			}
+		}
+		fmt.Fprintf(&g.b, "%s %s", fn, g.goType(at, name+fn))
+
+		var tags []string
+		if issue.FirstToLower(fn) != a.Name() {
+			tags = append(tags, `puppet:"name=>'`+a.Name()+`'"`)
+		}
+		var kinds []string
+		if contains(immutable, a.Name()) {
+			kinds = append(kinds, `immutable`)
+		}
+		if contains(provided, a.Name()) {
+			kinds = append(kinds, `provided`)
+		}
+		if kinds != nil {
+			tags = append(tags, ResourceTag+`:"`+strings.Join(kinds, `,`)+`"`)
+		}
+		if tags != nil {
+			g.b.WriteString(" `" + strings.Join(tags, ` `) + "`")
+		}
+		g.b.WriteByte('\n')
+	}
+	g.b.WriteString("}\n")
+}
+
+func (g *goGen) writeHandler(ht px.ObjectType) {
+	name := g.names[ht]
+	fs := ht.Functions(true)
+	sigs := make([]string, len(fs))
+	for i, f := range fs {
+		sigs[i] = g.signature(f)
+	}
+
+	fmt.Fprintf(&g.b, "\n// %sAPI is the interface of %s.\ntype %sAPI interface {\n", name, name, name)
+	for _, sig := range sigs {
+		g.b.WriteString(sig + "\n")
+	}
+	fmt.Fprintf(&g.b, "}\n\n// %s handles %s. Its methods must be implemented.\ntype %s struct{}\n\nvar _ %sAPI = &%s{}\n", name, g.handled(ht), name, name, name)
+	for i, f := range fs {
+		fmt.Fprintf(&g.b, "\nfunc (h *%s) %s {\npanic(%q)\n}\n", name, sigs[i], name+`.`+issue.SnakeToCamelCase(f.Name())+` is not implemented`)
+	}
+}
+
+// handlerArity is the number of parameters of each function of a resource handler.
+var handlerArity = map[string]int{`create`: 1, `read`: 1, `update`: 2, `delete`: 1}
+
+// signature returns the Go signature of a method that implements the given function.
+func (g *goGen) signature(f px.ObjFunc) string {
+	ct, ok := f.Type().(*types.CallableType)
+	if !ok {
+		return issue.SnakeToCamelCase(f.Name()) + `() error`
+	}
+	var pts []px.Type
+	if pt, ok := ct.ParametersType().(*types.TupleType); ok {
+		pts = pt.Types()
+	}
+	rt := ct.ReturnType()
+	if _, ok := rt.(*types.AnyType); ok || rt == nil {
+		// TypeSets that predate return types declare the return type of a handler function as an
+		// extra trailing parameter, e.g. Callable[String, Optional[Vpc]] for read
+		if n, ok := handlerArity[f.Name()]; ok && len(pts) == n+1 {
+			rt = pts[n]
+			pts = pts[:n]
+		}
+	}
+
+	params := make([]string, len(pts))
+	seen := make(map[string]bool)
+	for i, t := range pts {
+		pn := g.paramName(t, i)
+		if seen[pn] {
+			pn += strconv.Itoa(i)
+		}
+		seen[pn] = true
+		params[i] = pn + ` ` + g.goType(t, ``)
+	}
+	var returns []string
+	switch rt := rt.(type) {
+	case nil, *types.AnyType:
+	case *types.TupleType:
+		for _, t := range rt.Types() {
+			returns = append(returns, g.goType(t, ``))
+		}
+	default:
+		returns = append(returns, g.goType(rt, ``))
+	}
+	returns = append(returns, `error`)
+	rs := strings.Join(returns, `, `)
+	if len(returns) > 1 {
+		rs = `(` + rs + `)`
+	}
+	return issue.SnakeToCamelCase(f.Name()) + `(` + strings.Join(params, `, `) + `) ` + rs
+}
+
+func (g *goGen) paramName(t px.Type, i int) string {
+	if ot, ok := t.(*types.OptionalType); ok {
+		t = ot.ContainedType()
+	}
+	if ot, ok := t.(px.ObjectType); ok && ot.Name() != `` {
+		return issue.FirstToLower(g.names[ot])
+	}
+	if _, ok := t.(px.StringType); ok {
+		return `externalID`
+	}
+	return `arg` + strconv.Itoa(i)
+}
+
+// handled returns the name of the struct for the resource that the given handler handles, or an
+// empty string when no function of the handler uses a resource.
+func (g *goGen) handled(ht px.ObjectType) string {
+	if rt := handledType(ht); rt != nil {
+		return g.names[rt]
+	}
+	return ``
+}
+
+// handledType returns the named object type that is used by the functions of the given handler,
+// or nil when no function of the handler uses one.
+func handledType(ht px.ObjectType) px.ObjectType {
+	for _, f := range ht.Functions(true) {
+		ct, ok := f.Type().(*types.CallableType)
+		if !ok {
+			continue
+		}
+		ts := []px.Type{ct.ReturnType()}
+		if pt, ok := ct.ParametersType().(*types.TupleType); ok {
+			ts = append(ts, pt.Types()...)
+		}
+		if rt, ok := ct.ReturnType().(*types.TupleType); ok {
+			ts = append(ts, rt.Types()...)
+		}
+		for _, t := range ts {
+			if ot, ok := t.(*types.OptionalType); ok {
+				t = ot.ContainedType()
+			}
+			if ot, ok := t.(px.ObjectType); ok && ot.Name() != `` {
+				return ot
+			}
+		}
+	}
+	return nil
+}
+
+func (g *goGen) writeRegister(objects, handlers []px.ObjectType) {
+	fmt.Fprintf(&g.b, "\n// Register registers the types of the %s TypeSet, and the handlers of its resources, with the\n// given service builder.\nfunc Register(sb *service.Builder) {\n", g.namespace)
+	vars := make(map[string]string)
+	for _, ht := range handlers {
+		if r := g.handled(ht); r != `` {
+			vars[r] = issue.FirstToLower(r) + `Type`
+		}
+	}
+	for _, ot := range append(objects, g.anonymous...) {
+		name := g.names[ot]
+		value := `&` + name + `{}`
+		if a, ok := ot.Annotations(g.c).Get(annotation.ResourceType); ok {
+			r := a.(annotation.Resource)
+			value = `sb.BuildResource(&` + name + "{}, func(rb service.ResourceTypeBuilder) {\n"
+			if ia := inAttributeOrder(ot, r.ImmutableAttributes()); len(ia) > 0 {
+				value += `rb.ImmutableAttributes(` + quoteAll(ia) + ")\n"
+			}
+			if pa := inAttributeOrder(ot, r.ProvidedAttributes()); len(pa) > 0 {
+				value += `rb.ProvidedAttributes(` + quoteAll(pa) + ")\n"
+			}
+			value += `})`
+		}
+		if v, ok := vars[name]; ok {
+			fmt.Fprintf(&g.b, "%s := sb.RegisterTypes(%q, %s)[0]\n", v, g.namespace, value)
+		} else {
+			fmt.Fprintf(&g.b, "sb.RegisterTypes(%q, %s)\n", g.namespace, value)
+		}
+	}
+	for _, ht := range handlers {
+		name := g.names[ht]
+		if r := g.handled(ht); r != `` {
+			fmt.Fprintf(&g.b, "sb.RegisterHandler(%q, &%s{}, %s)\n", ht.Name(), name, vars[r])
+		} else {
+			fmt.Fprintf(&g.b, "sb.RegisterAPI(%q, &%s{})\n", ht.Name(), name)
+		}
+	}
+	g.b.WriteString("}\n")
+}
+
+// goType returns the Go type for the given type. An anonymous object type is declared as a struct
+// with the given name.
+func (g *goGen) goType(t px.Type, name string) string {
+	switch t := t.(type) {
+	case *types.OptionalType:
+		gt := g.goType(t.ContainedType(), name)
+		if strings.HasPrefix(gt, `*`) || strings.HasPrefix(gt, `[]`) || strings.HasPrefix(gt, `map[`) || gt == `interface{}` {
+			return gt
+		}
+		return `*` + gt
+	case *types.ArrayType:
+		return `[]` + g.goType(t.ElementType(), name)
+	case *types.HashType:
+		return `map[` + g.goType(t.KeyType(), name) + `]` + g.goType(t.ValueType(), name)
+	case px.ObjectType:
+		if n, ok := g.names[t]; ok {
+			return n
+		}
+		if t.Name() != `` {
+			return `interface{}`
+		}
+		g.names[t] = name
+		g.anonymous = append(g.anonymous, t)
+		return name
+	case *types.BooleanType:
+		return `bool`
+	case *types.IntegerType:
+		return `int64`
+	case *types.FloatType:
+		return `float64`
+	case px.StringType, *types.EnumType, *types.PatternType:
+		return `string`
+	case *types.TimestampType:
+		g.time = true
+		return `time.Time`
+	case *types.TimespanType:
+		g.time = true
+		return `time.Duration`
+	default:
+		return `interface{}`
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inAttributeOrder returns the given attribute names in the order of the attributes of the given
+// type, which is the order of the fields of the generated struct.
+func inAttributeOrder(ot px.ObjectType, names []string) []string {
+	var ordered []string
+	for _, a := range ot.AttributesInfo().Attributes() {
+		if contains(names, a.Name()) {
+			ordered = append(ordered, a.Name())
+		}
+	}
+	return ordered
+}
+
+func quoteAll(names []string) string {
+	qs := make([]string, len(names))
+	for i, n := range names {
+		qs[i] = strconv.Quote(n)
+	}
+	return strings.Join(qs, `, `)
+}
@@ -0,0 +1,218 @@
+package puppetwf_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateGo(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.TypeSetFromSchema(c, `Network`, nil, puppetwf.LoadSchema(c, `testdata/schema/network.yaml`))
+		b := bytes.NewBufferString(``)
+		puppetwf.GenerateGo(c, ts, `network`, b)
+		require.Equal(t, `// this file is generated
+package network
+
+import (
+	"github.com/lyraproj/servicesdk/service"
+)
+
+type Route struct {
+	Destination *string
+}
+
+type Subnet struct {
+	VpcId     string
+	CidrBlock string
+	Placement SubnetPlacement
+	Routes    []Route
+	IpCount   *int64
+	SubnetId  *string `+"`"+`lyra:"provided"`+"`"+`
+}
+
+type Vpc struct {
+	CidrBlock       string
+	InstanceTenancy *string
+	Tags            map[string]string
+	VpcId           *string `+"`"+`lyra:"provided"`+"`"+`
+}
+
+type SubnetPlacement struct {
+	Zone    string
+	Tenancy *string
+}
+
+// SubnetHandlerAPI is the interface of SubnetHandler.
+type SubnetHandlerAPI interface {
+	Create(subnet *Subnet) (*Subnet, string, error)
+	Delete(externalID string) error
+	Read(externalID string) (*Subnet, error)
+}
+
+// SubnetHandler handles Subnet. Its methods must be implemented.
+type SubnetHandler struct{}
+
+var _ SubnetHandlerAPI = &SubnetHandler{}
+
+func (h *SubnetHandler) Create(subnet *Subnet) (*Subnet, string, error) {
+	panic("SubnetHandler.Create is not implemented")
+}
+
+func (h *SubnetHandler) Delete(externalID string) error {
+	panic("SubnetHandler.Delete is not implemented")
+}
+
+func (h *SubnetHandler) Read(externalID string) (*Subnet, error) {
+	panic("SubnetHandler.Read is not implemented")
+}
+
+// VpcHandlerAPI is the interface of VpcHandler.
+type VpcHandlerAPI interface {
+	Create(vpc *Vpc) (*Vpc, string, error)
+	Delete(externalID string) error
+	Read(externalID string) (*Vpc, error)
+}
+
+// VpcHandler handles Vpc. Its methods must be implemented.
+type VpcHandler struct{}
+
+var _ VpcHandlerAPI = &VpcHandler{}
+
+func (h *VpcHandler) Create(vpc *Vpc) (*Vpc, string, error) {
+	panic("VpcHandler.Create is not implemented")
+}
+
+func (h *VpcHandler) Delete(externalID string) error {
+	panic("VpcHandler.Delete is not implemented")
+}
+
+func (h *VpcHandler) Read(externalID string) (*Vpc, error) {
+	panic("VpcHandler.Read is not implemented")
+}
+
+// Register registers the types of the Network TypeSet, and the handlers of its resources, with the
+// given service builder.
+func Register(sb *service.Builder) {
+	sb.RegisterTypes("Network", &Route{})
+	subnetType := sb.RegisterTypes("Network", sb.BuildResource(&Subnet{}, func(rb service.ResourceTypeBuilder) {
+		rb.ProvidedAttributes("subnetId")
+	}))[0]
+	vpcType := sb.RegisterTypes("Network", sb.BuildResource(&Vpc{}, func(rb service.ResourceTypeBuilder) {
+		rb.ProvidedAttributes("vpcId")
+	}))[0]
+	sb.RegisterTypes("Network", &SubnetPlacement{})
+	sb.RegisterHandler("Network::SubnetHandler", &SubnetHandler{}, subnetType)
+	sb.RegisterHandler("Network::VpcHandler", &VpcHandler{}, vpcType)
+}
+`, b.String())
+	})
+}
+
+func TestGenerateGoHandlers(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		b := bytes.NewBufferString(``)
+		puppetwf.GenerateGo(c, puppetwf.ReadTypeSet(c, `testdata/types/Aws.pp`), `aws`, b)
+		require.Contains(t, b.String(), `
+type VPCHandlerAPI interface {
+	Create(vpc *Vpc) (*Vpc, string, error)
+	Delete(externalID string) error
+	Read(externalID string) (*Vpc, error)
+}
+`)
+	})
+}
+
+func TestGenerateGoUpdate(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		ts := types.ParseFile(`Example.pp`, `type Example = TypeSet[{
+  pcore_uri => 'http://puppet.com/2016.1/pcore',
+  pcore_version => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name => 'Example',
+  types => {
+    Thing => {
+      attributes => {
+        name => String
+      }
+    },
+    ThingHandler => {
+      functions => {
+        'create' => Callable[[Optional[Thing]], Tuple[Optional[Thing], String]],
+        'read' => Callable[[String], Optional[Thing]],
+        'update' => Callable[[String, Optional[Thing]], Optional[Thing]],
+        'delete' => Callable[[String]]
+      }
+    }
+  }
+}]`).(px.TypeSet)
+		px.AddTypes(c, ts)
+		b := bytes.NewBufferString(``)
+		puppetwf.GenerateGo(c, ts, `example`, b)
+		require.Contains(t, b.String(), `
+type ThingHandlerAPI interface {
+	Create(thing *Thing) (*Thing, string, error)
+	Read(externalID string) (*Thing, error)
+	Update(externalID string, thing *Thing) (*Thing, error)
+	Delete(externalID string) error
+}
+`)
+	})
+}
+
+const roundTripProgram = `package main
+
+import (
+	"os"
+
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+func main() {
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.GenerateTypeSet(c, "Aws", nil,
+			puppetwf.Resource{Type: &Subnet{}, Handler: &SubnetHandler{}},
+			puppetwf.Resource{Type: &Vpc{}, Handler: &VPCHandler{}})
+		puppetwf.WriteTypeSet(ts, os.Args[1])
+	})
+}
+`
+
+func TestGenerateGoRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip(`compiles generated code`)
+	}
+	// The program must be compiled within the module so that it can import puppetwf
+	tmp, err := ioutil.TempDir(`.`, `.roundtrip`)
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	pcore.Do(func(c px.Context) {
+		b := bytes.NewBufferString(``)
+		puppetwf.GenerateGo(c, puppetwf.ReadTypeSet(c, `testdata/types/Aws.pp`), `main`, b)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, `aws.go`), b.Bytes(), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, `main.go`), []byte(roundTripProgram), 0644))
+
+		out, err := exec.Command(`go`, `run`, `./`+filepath.Base(tmp), filepath.Join(tmp, `types`)).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		// The generated TypeSet declares the return types of the functions where the original
+		// declares them as trailing parameters, so the two are compared through the Go they produce
+		pcore.Do(func(c2 px.Context) {
+			b2 := bytes.NewBufferString(``)
+			puppetwf.GenerateGo(c2, puppetwf.ReadTypeSet(c2, filepath.Join(tmp, `types`, `Aws.pp`)), `main`, b2)
+			require.Equal(t, b.String(), b2.String())
+		})
+	})
+}
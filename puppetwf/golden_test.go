@@ -0,0 +1,14 @@
+package puppetwf_test
+
+import (
+	"flag"
+	"testing"
+
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+)
+
+var update = flag.Bool(`update`, false, `update the golden files in testdata`)
+
+func TestGolden(t *testing.T) {
+	wftesting.Golden(t, `testdata`, *update)
+}
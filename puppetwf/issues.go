@@ -0,0 +1,125 @@
+package puppetwf
+
+import "github.com/lyraproj/issue/issue"
+
+const (
+	ActionMissingReturn            = `PUPPETWF_ACTION_MISSING_RETURN`
+	ActionResultNotHash            = `PUPPETWF_ACTION_RESULT_NOT_HASH`
+	ActionResultTypeMismatch       = `PUPPETWF_ACTION_RESULT_TYPE_MISMATCH`
+	ActionReturnTypeMismatch       = `PUPPETWF_ACTION_RETURN_TYPE_MISMATCH`
+	ActionUnexpectedReturn         = `PUPPETWF_ACTION_UNEXPECTED_RETURN`
+	AmbiguousDefinition            = `PUPPETWF_AMBIGUOUS_DEFINITION`
+	CallCycle                      = `PUPPETWF_CALL_CYCLE`
+	CallHasDefinition              = `PUPPETWF_CALL_HAS_DEFINITION`
+	CallParameterTypeMismatch      = `PUPPETWF_CALL_PARAMETER_TYPE_MISMATCH`
+	CallReturnTypeMismatch         = `PUPPETWF_CALL_RETURN_TYPE_MISMATCH`
+	CassetteMismatch               = `PUPPETWF_CASSETTE_MISMATCH`
+	CassetteWriteFailed            = `PUPPETWF_CASSETTE_WRITE_FAILED`
+	ExecUnquotedInterpolation      = `PUPPETWF_EXEC_UNQUOTED_INTERPOLATION`
+	ExitWithoutShutdown            = `PUPPETWF_EXIT_WITHOUT_SHUTDOWN`
+	FormatFailed                   = `PUPPETWF_FORMAT_FAILED`
+	InvalidAlias                   = `PUPPETWF_INVALID_ALIAS`
+	InvalidCassette                = `PUPPETWF_INVALID_CASSETTE`
+	InvalidLspMessage              = `PUPPETWF_INVALID_LSP_MESSAGE`
+	InvalidLookupConfig            = `PUPPETWF_INVALID_LOOKUP_CONFIG`
+	InvalidLookupData              = `PUPPETWF_INVALID_LOOKUP_DATA`
+	InvalidMemoryHandlerFile       = `PUPPETWF_INVALID_MEMORY_HANDLER_FILE`
+	InvalidMetadata                = `PUPPETWF_INVALID_METADATA`
+	InvalidSchema                  = `PUPPETWF_INVALID_SCHEMA`
+	InvalidServiceName             = `PUPPETWF_INVALID_SERVICE_NAME`
+	LocalsCycle                    = `PUPPETWF_LOCALS_CYCLE`
+	LocalsNotHash                  = `PUPPETWF_LOCALS_NOT_HASH`
+	LookupCycle                    = `PUPPETWF_LOOKUP_CYCLE`
+	LookupExecFailed               = `PUPPETWF_LOOKUP_EXEC_FAILED`
+	LookupNotFound                 = `PUPPETWF_LOOKUP_NOT_FOUND`
+	MemoryHandlerWriteFailed       = `PUPPETWF_MEMORY_HANDLER_WRITE_FAILED`
+	MissingCallParameter           = `PUPPETWF_MISSING_CALL_PARAMETER`
+	MissingExternalId              = `PUPPETWF_MISSING_EXTERNAL_ID`
+	MissingUpdateFunction          = `PUPPETWF_MISSING_UPDATE_FUNCTION`
+	NoBlockGiven                   = `PUPPETWF_NO_BLOCK_GIVEN`
+	NoSuchCalledStep               = `PUPPETWF_NO_SUCH_CALLED_STEP`
+	NotATypeSet                    = `PUPPETWF_NOT_A_TYPESET`
+	ReplayedError                  = `PUPPETWF_REPLAYED_ERROR`
+	RequiredTypeSetNotFound        = `PUPPETWF_REQUIRED_TYPESET_NOT_FOUND`
+	RequiredTypeSetVersionMismatch = `PUPPETWF_REQUIRED_TYPESET_VERSION_MISMATCH`
+	SensitiveValueInError          = `PUPPETWF_SENSITIVE_VALUE_IN_ERROR`
+	ServiceNameCollision           = `PUPPETWF_SERVICE_NAME_COLLISION`
+	ServiceNameDeclaredTwice       = `PUPPETWF_SERVICE_NAME_DECLARED_TWICE`
+	ShadowedVariable               = `PUPPETWF_SHADOWED_VARIABLE`
+	StepNameCollision              = `PUPPETWF_STEP_NAME_COLLISION`
+	StepRuntimeError               = `PUPPETWF_STEP_RUNTIME_ERROR`
+	TraceFileWriteFailed           = `PUPPETWF_TRACE_FILE_WRITE_FAILED`
+	UndeclaredParameter            = `PUPPETWF_UNDECLARED_PARAMETER`
+	UnderivableServiceName         = `PUPPETWF_UNDERIVABLE_SERVICE_NAME`
+	UnknownAlias                   = `PUPPETWF_UNKNOWN_ALIAS`
+	UnknownAttributeAlias          = `PUPPETWF_UNKNOWN_ATTRIBUTE_ALIAS`
+	UnknownCallParameter           = `PUPPETWF_UNKNOWN_CALL_PARAMETER`
+	UnknownCallReturn              = `PUPPETWF_UNKNOWN_CALL_RETURN`
+	UnknownDocFormat               = `PUPPETWF_UNKNOWN_DOC_FORMAT`
+	UnknownLocalReference          = `PUPPETWF_UNKNOWN_LOCAL_REFERENCE`
+	UnproducedReturn               = `PUPPETWF_UNPRODUCED_RETURN`
+	UnresolvedSchemaRef            = `PUPPETWF_UNRESOLVED_SCHEMA_REF`
+	UnsupportedURI                 = `PUPPETWF_UNSUPPORTED_URI`
+	UnusedParameter                = `PUPPETWF_UNUSED_PARAMETER`
+)
+
+func init() {
+	issue.Hard(ActionMissingReturn, `action %{step} did not return a value for '%{name}'`)
+	issue.Hard(ActionResultNotHash, `action %{step} must return a Hash, got %{actual}`)
+	issue.Hard(ActionResultTypeMismatch, `action %{step} must return %{expected}, got %{actual}`)
+	issue.Hard(ActionReturnTypeMismatch, `action %{step} returned %{actual} for '%{name}' which expects %{expected}`)
+	issue.Hard(ActionUnexpectedReturn, `action %{step} returned '%{name}' which is not a declared return`)
+	issue.Hard(AmbiguousDefinition, `%{namespace} %{name} is defined by more than one module: %{modules}`)
+	issue.Hard(CallCycle, `call of '%{call}' forms a cycle`)
+	issue.Hard(CallHasDefinition, `a workflow that calls '%{call}' cannot have a definition block`)
+	issue.Hard(CallParameterTypeMismatch, `parameter '%{name}' of type %{actual} cannot be passed to '%{call}' which expects %{expected}`)
+	issue.Hard(CallReturnTypeMismatch, `return '%{name}' of type %{expected} cannot be assigned from '%{call}' which returns %{actual}`)
+	issue.Hard(CassetteMismatch, `call to %{handler} does not match the recording in %{path}: %{diff}`)
+	issue.Hard(CassetteWriteFailed, `unable to write cassette %{path}: %{detail}`)
+	issue.Soft(ExecUnquotedInterpolation, `exec in step %{step} interpolates a value unquoted into the command line %{command}, pass the value as a separate argument`)
+	issue.Hard(ExitWithoutShutdown, `the language client sent exit without shutdown`)
+	issue.Hard(FormatFailed, `unable to format %{path}: %{detail}`)
+	issue.Hard(InvalidAlias, `%{function}() must be called with exactly one String argument`)
+	issue.Hard(InvalidCassette, `cassette %{path} must contain an Array`)
+	issue.Hard(InvalidLspMessage, `invalid language server message: %{detail}`)
+	issue.Hard(InvalidLookupConfig, `invalid lookup configuration in %{path}: %{detail}`)
+	issue.Hard(InvalidLookupData, `lookup data file %{path} must contain a Hash`)
+	issue.Hard(InvalidMemoryHandlerFile, `memory handler file %{path} must contain a Hash`)
+	issue.Hard(InvalidMetadata, `invalid metadata: %{detail}`)
+	issue.Hard(InvalidSchema, `invalid schema at %{pointer}: %{detail}`)
+	issue.Hard(InvalidServiceName, `serviceName() must be called with one literal String that is a valid type name such as 'My::Service'`)
+	issue.Hard(LocalsCycle, `local '%{name}' of %{step} depends on itself`)
+	issue.Hard(LocalsNotHash, `expected locals of %{step} to be a literal Hash`)
+	issue.Hard(LookupCycle, `lookup of '%{key}' depends on itself`)
+	issue.Hard(LookupExecFailed, `lookup of '%{key}' using %{level} failed: %{detail}`)
+	issue.Hard(LookupNotFound, `lookup() did not find a value for '%{key}'`)
+	issue.Hard(MemoryHandlerWriteFailed, `unable to write memory handler file %{path}: %{detail}`)
+	issue.Hard(MissingCallParameter, `call of '%{call}' is missing required parameter '%{name}'`)
+	issue.Soft(MissingExternalId, `resource %{step} has no externalId, so an existing resource cannot be found and is created again`)
+	issue.Soft(MissingUpdateFunction, `state handler %{step} has no update function, so each change deletes the resource and creates it again`)
+	issue.Hard(NoBlockGiven, `yield() can only be called by an action that has been given a block`)
+	issue.Hard(NoSuchCalledStep, `unable to find a step named '%{call}'`)
+	issue.Hard(NotATypeSet, `%{path} does not declare a TypeSet`)
+	issue.Hard(ReplayedError, `%{method} of %{handler} failed when recorded: %{message}`)
+	issue.Hard(RequiredTypeSetNotFound, `the manifest requires the TypeSet %{name} which cannot be found`)
+	issue.Hard(RequiredTypeSetVersionMismatch, `the manifest requires the TypeSet %{name} with a version in the range %{range}, got %{version}`)
+	issue.Hard(SensitiveValueInError, `%{step} failed: %{message}`)
+	issue.Hard(ServiceNameCollision, `service name %{name} of %{path} is already used by %{other}. Use serviceName() to give one of them another name`)
+	issue.Hard(ServiceNameDeclaredTwice, `the service name can only be declared once, using either serviceName() or the name of metadata()`)
+	issue.Soft(ShadowedVariable, `%{kind} '%{name}' in workflow %{step} shadows the variable with the same name in workflow %{outer}`)
+	issue.Hard(StepNameCollision, `step %{name} is already declared by %{other}`)
+	issue.Hard(StepRuntimeError, `%{method} of step %{path} in %{manifest} failed`)
+	issue.Hard(TraceFileWriteFailed, `unable to write trace file %{path}: %{detail}`)
+	issue.Soft(UndeclaredParameter, `step %{step} references $%{name} which is not one of its parameters`)
+	issue.Hard(UnderivableServiceName, `the service name of %{path} can't be derived from its path without losing characters. Use serviceName() to declare it`)
+	issue.Hard(UnknownAlias, `%{field} '%{name}' of %{step} is an alias for '%{alias}' which is not produced by any step`)
+	issue.Hard(UnknownAttributeAlias, `return '%{name}' of %{step} is an alias for '%{alias}' which is not an attribute of %{type}`)
+	issue.Hard(UnknownCallParameter, `'%{call}' has no parameter named '%{name}'`)
+	issue.Hard(UnknownCallReturn, `'%{call}' does not return '%{name}'`)
+	issue.Hard(UnknownDocFormat, `unknown documentation format '%{format}', expected markdown or html`)
+	issue.Hard(UnknownLocalReference, `local '%{name}' of %{step} references '%{reference}' which is neither a parameter nor a local`)
+	issue.Soft(UnproducedReturn, `return '%{name}' of workflow %{step} is not produced by any of its steps`)
+	issue.Hard(UnresolvedSchemaRef, `$ref '%{ref}' does not refer to a named schema in the document`)
+	issue.Hard(UnsupportedURI, `unsupported document URI %{uri}, only file URIs are supported`)
+	issue.Soft(UnusedParameter, `parameter '%{name}' of workflow %{step} is not used by any of its steps`)
+}
@@ -0,0 +1,686 @@
+package puppetwf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+)
+
+// ServeLanguageServer serves the Language Server Protocol for workflow manifests on the given
+// streams until the client sends exit, or until in is closed. Each time a manifest is opened or
+// changed, it is loaded the same way as LoadManifest loads it, and the server publishes the errors
+// raised while its steps are built, types that cannot be resolved, and the warnings that Lint
+// reports. The server also provides:
+//
+// Completion of the attribute names in the body of a resource, taken from its resource type.
+//
+// Hover for variables, showing the type of the parameter, return, or iteration variable, and for
+// steps, attributes, and types.
+//
+// Definitions of steps, including steps that are called by name, and of types, including types
+// that are declared in modules.
+//
+// A quick fix that adds the variables that a resource, action, or state handler uses without
+// declaring them to its parameters.
+//
+// The answers are based on the last successful load of the manifest, so they remain available
+// while the manifest has errors.
+func ServeLanguageServer(in io.Reader, out io.Writer, options ...Option) error {
+	s := &languageServer{out: out, options: options, documents: make(map[string]*lspDocument)}
+	r := bufio.NewReader(in)
+	for {
+		msg, err := readLspMessage(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == `exit` {
+			if !s.shutdown {
+				return px.Error(ExitWithoutShutdown, issue.NoArgs)
+			}
+			return nil
+		}
+		if err = s.dispatch(msg); err != nil {
+			return err
+		}
+	}
+}
+
+type languageServer struct {
+	out       io.Writer
+	options   []Option
+	documents map[string]*lspDocument
+	shutdown  bool
+}
+
+// lspDocument is a manifest that the client has opened.
+type lspDocument struct {
+	uri      string
+	path     string
+	text     string
+	analysis *manifestAnalysis
+}
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+const (
+	lspParseError     = -32700
+	lspInvalidRequest = -32600
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+// readLspMessage reads a message with a Content-Length header.
+func readLspMessage(r *bufio.Reader) (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == `` && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, px.Error(InvalidLspMessage, issue.H{`detail`: err.Error()})
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == `` {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(line[:i], `Content-Length`) {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, px.Error(InvalidLspMessage, issue.H{`detail`: err.Error()})
+			}
+		}
+	}
+	if length < 0 {
+		return nil, px.Error(InvalidLspMessage, issue.H{`detail`: `missing Content-Length header`})
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, px.Error(InvalidLspMessage, issue.H{`detail`: err.Error()})
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		// The request cannot be answered without its id, so the message is reported as a notification
+		return &lspMessage{Method: `$/invalid`, Error: &lspError{lspParseError, err.Error()}}, nil
+	}
+	return msg, nil
+}
+
+func (s *languageServer) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body)); err == nil {
+		_, err = s.out.Write(body)
+	}
+	return err
+}
+
+func (s *languageServer) notify(method string, params interface{}) error {
+	return s.write(map[string]interface{}{`jsonrpc`: `2.0`, `method`: method, `params`: params})
+}
+
+// dispatch handles the given message and writes the response when the message is a request. The
+// result of a request is written as null when the handler returns nil.
+func (s *languageServer) dispatch(msg *lspMessage) error {
+	result, rpcErr := s.handle(msg)
+	if msg.ID == nil {
+		return nil
+	}
+	response := map[string]interface{}{`jsonrpc`: `2.0`, `id`: msg.ID}
+	if rpcErr != nil {
+		response[`error`] = rpcErr
+	} else {
+		response[`result`] = result
+	}
+	return s.write(response)
+}
+
+func (s *languageServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	if msg.Error != nil {
+		return nil, msg.Error
+	}
+	if s.shutdown && msg.ID != nil {
+		return nil, &lspError{lspInvalidRequest, `the server is shut down`}
+	}
+	switch msg.Method {
+	case `initialize`:
+		return map[string]interface{}{
+			`capabilities`: map[string]interface{}{
+				`textDocumentSync`:   1,
+				`completionProvider`: map[string]interface{}{},
+				`hoverProvider`:      true,
+				`definitionProvider`: true,
+				`codeActionProvider`: map[string]interface{}{`codeActionKinds`: []string{`quickfix`}},
+			},
+			`serverInfo`: map[string]string{`name`: lspSource},
+		}, nil
+	case `shutdown`:
+		s.shutdown = true
+		return nil, nil
+	case `textDocument/didOpen`, `textDocument/didChange`, `textDocument/didSave`, `textDocument/didClose`:
+		return nil, s.textDocumentNotification(msg)
+	case `textDocument/completion`:
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		return s.completion(p), nil
+	case `textDocument/hover`:
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		return s.hover(p), nil
+	case `textDocument/definition`:
+		var p lspPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		return s.definition(p), nil
+	case `textDocument/codeAction`:
+		var p lspCodeActionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		return s.codeActions(p), nil
+	}
+	if msg.ID != nil {
+		return nil, &lspError{lspMethodNotFound, fmt.Sprintf(`method %s is not supported`, msg.Method)}
+	}
+	return nil, nil
+}
+
+func (s *languageServer) textDocumentNotification(msg *lspMessage) *lspError {
+	var p struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		Text           *string         `json:"text"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return &lspError{lspInvalidParams, err.Error()}
+	}
+	uri := p.TextDocument.URI
+	d, ok := s.documents[uri]
+	switch msg.Method {
+	case `textDocument/didOpen`:
+		path, err := uriPath(uri)
+		if err != nil {
+			return &lspError{lspInvalidParams, err.Error()}
+		}
+		d = &lspDocument{uri: uri, path: path}
+		s.documents[uri] = d
+		d.text = p.TextDocument.Text
+	case `textDocument/didChange`:
+		if !ok {
+			return nil
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			d.text = p.ContentChanges[n-1].Text
+		}
+	case `textDocument/didSave`:
+		// Saving may change what other manifests and modules see, so the document is analyzed again
+		if !ok {
+			return nil
+		}
+		if p.Text != nil {
+			d.text = *p.Text
+		}
+	default:
+		delete(s.documents, uri)
+		if err := s.publish(uri, nil); err != nil {
+			return &lspError{lspRequestFailed, err.Error()}
+		}
+		return nil
+	}
+
+	ma, diagnostics := analyzeManifest(d.path, d.text, s.options)
+	if ma != nil {
+		d.analysis = ma
+	}
+	if err := s.publish(uri, diagnostics); err != nil {
+		return &lspError{lspRequestFailed, err.Error()}
+	}
+	return nil
+}
+
+func (s *languageServer) publish(uri string, diagnostics []lspDiagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []lspDiagnostic{}
+	}
+	return s.notify(`textDocument/publishDiagnostics`, map[string]interface{}{`uri`: uri, `diagnostics`: diagnostics})
+}
+
+// document returns the open document and its last analysis, and the offset of the given position
+// in its text. The document is nil if it isn't open or has never been loaded successfully.
+func (s *languageServer) document(uri string, pos lspPosition) (*lspDocument, int) {
+	d, ok := s.documents[uri]
+	if !ok || d.analysis == nil {
+		return nil, 0
+	}
+	return d, offsetAt(d.text, pos)
+}
+
+type lspCompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText"`
+}
+
+// attributeKey matches the text before the cursor when the cursor is where the key of a hash entry
+// is written.
+var attributeKey = regexp.MustCompile(`(?:\A|[{,\n])\s*[A-Za-z_]\w*\z|(?:\A|[{,\n])\s*\z`)
+
+// completion returns the attributes of the resource type that haven't been given a value yet when
+// the position is at the key of an entry in the body of a resource.
+func (s *languageServer) completion(p lspPositionParams) interface{} {
+	items := []lspCompletionItem{}
+	d, offset := s.document(p.TextDocument.URI, p.Position)
+	if d == nil {
+		return items
+	}
+	f := innermostFrame(scanFrames(d.text), offset)
+	if f == nil || !f.isBody() || f.style != `resource` || !attributeKey.MatchString(d.text[f.open+1:offset]) {
+		return items
+	}
+	ads, ok := d.analysis.attributes[d.analysis.step(f)]
+	if !ok {
+		return items
+	}
+	given := make(map[string]bool)
+	for _, m := range hashKey.FindAllStringSubmatch(d.text[f.open+1:f.close], -1) {
+		given[m[1]] = true
+	}
+	start, _ := wordAt(d.text, offset)
+	given[d.text[start:offset]] = false
+	for _, ad := range ads {
+		if given[ad.name] {
+			continue
+		}
+		detail := ad.typ
+		if flags := ad.flags(); len(flags) > 0 {
+			detail += ` (` + strings.Join(flags, `, `) + `)`
+		}
+		items = append(items, lspCompletionItem{Label: ad.name, Kind: 10, Detail: detail, InsertText: ad.name + ` => `})
+	}
+	return items
+}
+
+var hashKey = regexp.MustCompile(`(?m)^\s*'?(\w+)'?\s*=>`)
+
+// hover returns the hover text for the variable, attribute, step, or type at the given position.
+func (s *languageServer) hover(p lspPositionParams) interface{} {
+	d, offset := s.document(p.TextDocument.URI, p.Position)
+	if d == nil {
+		return nil
+	}
+	start, end := wordAt(d.text, offset)
+	if start == end {
+		return nil
+	}
+	ma := d.analysis
+	word := d.text[start:end]
+	if start >= 2 && d.text[start-2:start] == `${` {
+		// A variable that is interpolated in a string
+		word = `$` + word
+	}
+	frames := scanFrames(d.text)
+	text := ``
+	switch {
+	case word == `$`:
+	case strings.HasPrefix(word, `$`):
+		if a := ma.step(stepFrameAt(frames, offset)); a != nil {
+			text = ma.variableHover(a, word[1:])
+		}
+	case word[0] >= 'A' && word[0] <= 'Z':
+		if ti, ok := ma.types[word]; ok {
+			text = ti.hover
+		}
+	default:
+		if f := innermostFrame(frames, offset); f != nil && f.isBody() && f.style == `resource` && strings.HasPrefix(strings.TrimSpace(d.text[end:]), `=>`) {
+			for _, ad := range ma.attributes[ma.step(f)] {
+				if ad.name == word {
+					text = fmt.Sprintf("```puppet\n%s => %s\n```", ad.name, ad.typ)
+				}
+			}
+		} else if a := ma.stepNamed(frames, offset, word); a != nil {
+			text = ma.stepHover(a)
+		}
+	}
+	if text == `` {
+		return nil
+	}
+	return map[string]interface{}{
+		`contents`: map[string]string{`kind`: `markdown`, `value`: text},
+		`range`:    lspRange{positionAt(d.text, start), positionAt(d.text, end)}}
+}
+
+// definition returns the location of the declaration of the step or type at the given position.
+func (s *languageServer) definition(p lspPositionParams) interface{} {
+	d, offset := s.document(p.TextDocument.URI, p.Position)
+	if d == nil {
+		return nil
+	}
+	start, end := wordAt(d.text, offset)
+	if start == end || d.text[start] == '$' {
+		return nil
+	}
+	ma := d.analysis
+	word := d.text[start:end]
+	if ti, ok := ma.types[word]; ok {
+		if ti.location != nil {
+			return ti.location
+		}
+		return nil
+	}
+	if l, ok := ma.calls[word]; ok && l != nil && isQuoted(d.text, start, end) {
+		return l
+	}
+	if a := ma.stepNamed(scanFrames(d.text), offset, word); a != nil {
+		if l := ma.stepNameLocation(a); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// stepNamed returns the step with the given name as seen from the given offset, which is the step
+// itself, a step nested in it, or a step nested in one of the steps that it is nested in. A name in
+// quotes is the name of a called step.
+func (ma *manifestAnalysis) stepNamed(frames []*stepFrame, offset int, name string) *puppetStep {
+	for f := stepFrameAt(frames, offset); f != nil; f = f.outer() {
+		if a := ma.step(f); a != nil {
+			if a.name == name {
+				return a
+			}
+			for _, c := range a.children {
+				if c.name == name {
+					return c
+				}
+			}
+		}
+	}
+	for _, a := range ma.steps {
+		if a.name == name || leafName(a.expression.(interface{ Name() string }).Name()) == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func isQuoted(text string, start, end int) bool {
+	return start > 0 && end < len(text) && (text[start-1] == '\'' || text[start-1] == '"') && text[end] == text[start-1]
+}
+
+type lspCodeActionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Range        lspRange        `json:"range"`
+	Context      struct {
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	} `json:"context"`
+}
+
+// codeActions returns a quick fix that declares the undeclared variables of the innermost step at
+// the start of the given range as parameters of the step.
+func (s *languageServer) codeActions(p lspCodeActionParams) interface{} {
+	actions := []interface{}{}
+	d, offset := s.document(p.TextDocument.URI, p.Range.Start)
+	if d == nil {
+		return actions
+	}
+	f := stepFrameAt(scanFrames(d.text), offset)
+	if f == nil {
+		return actions
+	}
+	a := d.analysis.step(f)
+	if a == nil {
+		return actions
+	}
+	names := a.undeclaredVariables()
+	if len(names) == 0 {
+		return actions
+	}
+	if f.isBody() {
+		f = f.properties
+	}
+
+	diagnostics := []lspDiagnostic{}
+	for _, dg := range p.Context.Diagnostics {
+		if dg.Code == UndeclaredParameter {
+			diagnostics = append(diagnostics, dg)
+		}
+	}
+	title := `Add missing parameter $` + names[0]
+	if len(names) > 1 {
+		title = `Add missing parameters $` + strings.Join(names, `, $`)
+	}
+	return append(actions, map[string]interface{}{
+		`title`:       title,
+		`kind`:        `quickfix`,
+		`diagnostics`: diagnostics,
+		`edit`:        map[string]interface{}{`changes`: map[string][]lspTextEdit{d.uri: parametersEdits(d.text, f, names)}}})
+}
+
+var parametersProperty = regexp.MustCompile(`\bparameters\s*=>\s*\(`)
+
+// parametersEdits returns the edits that add the given names to the parameters in the given
+// properties of a step. The parameters property is added when the step has none. The edits follow
+// the layout that fmt produces, so a list with one parameter per line gets a line per name.
+func parametersEdits(text string, props *stepFrame, names []string) []lspTextEdit {
+	edit := func(start, end int, newText string) lspTextEdit {
+		return lspTextEdit{lspRange{positionAt(text, start), positionAt(text, end)}, newText}
+	}
+	list := `$` + strings.Join(names, `, $`)
+	indent := lineIndent(text, props.open)
+
+	lp := -1
+	for _, m := range parametersProperty.FindAllStringIndex(text[props.open+1:props.close], -1) {
+		if f := innermostFrame(scanFrames(text[:props.open+1+m[0]]), props.open+1+m[0]); f != nil && f.open == props.open {
+			lp = props.open + m[1]
+			break
+		}
+	}
+	if lp < 0 {
+		if strings.TrimSpace(text[props.open+1:props.close]) == `` {
+			return []lspTextEdit{edit(props.open+1, props.close, "\n"+indent+`  parameters => (`+list+")\n"+indent)}
+		}
+		return []lspTextEdit{edit(props.open+1, props.open+1, "\n"+indent+`  parameters => (`+list+`),`)}
+	}
+
+	rp := closingParen(text, lp)
+	inner := text[lp+1 : rp]
+	last := lp + 1 + len(strings.TrimRight(inner, " \t\r\n"))
+	switch {
+	case strings.TrimSpace(inner) == ``:
+		return []lspTextEdit{edit(lp+1, rp, list)}
+	case strings.Contains(inner, "\n"):
+		// One parameter per line, each followed by a comma. The comma that the last parameter may lack
+		// is written before a comment that follows it.
+		var edits []lspTextEdit
+		lineStart := strings.LastIndexByte(text[:last], '\n') + 1
+		code := text[lineStart:last]
+		if i := strings.IndexByte(code, '#'); i >= 0 {
+			code = code[:i]
+		}
+		code = strings.TrimRight(code, " \t")
+		if !strings.HasSuffix(code, `,`) && !strings.HasSuffix(code, `(`) {
+			edits = append(edits, edit(lineStart+len(code), lineStart+len(code), `,`))
+		}
+		paramIndent := lineIndent(text, last-1)
+		if strings.HasSuffix(code, `(`) {
+			paramIndent = indent + `    `
+		}
+		var b strings.Builder
+		for _, n := range names {
+			b.WriteString("\n" + paramIndent + `$` + n + `,`)
+		}
+		return append(edits, edit(last, last, b.String()))
+	case strings.HasSuffix(text[:last], `,`):
+		return []lspTextEdit{edit(last, last, ` `+list)}
+	default:
+		return []lspTextEdit{edit(last, last, `, `+list)}
+	}
+}
+
+// closingParen returns the offset of the parenthesis that closes the one at the given offset, or
+// the end of the text when it isn't closed.
+func closingParen(text string, open int) int {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		case '\'', '"':
+			e := stringEnd(text, i)
+			if e < 0 {
+				return len(text)
+			}
+			i = e - 1
+		}
+	}
+	return len(text)
+}
+
+// lineIndent returns the whitespace that the line at the given offset starts with.
+func lineIndent(text string, offset int) string {
+	start := strings.LastIndexByte(text[:offset], '\n') + 1
+	end := start
+	for end < len(text) && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+	return text[start:end]
+}
+
+// positionAt returns the position of the given offset. Characters are counted in UTF-16 code units
+// as the protocol requires.
+func positionAt(text string, offset int) lspPosition {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	line := strings.Count(text[:offset], "\n")
+	character := 0
+	for _, r := range text[strings.LastIndexByte(text[:offset], '\n')+1 : offset] {
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return lspPosition{line, character}
+}
+
+// offsetAt returns the offset of the given position, or the end of the line or the text when the
+// position is beyond it.
+func offsetAt(text string, pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for character := 0; character < pos.Character && offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return offset
+}
+
+// fileLocation returns the location of the given number of bytes at the given offset in the text
+// of the given file.
+func fileLocation(path, text string, offset, length int) *lspLocation {
+	return &lspLocation{pathURI(path), lspRange{positionAt(text, offset), positionAt(text, offset+length)}}
+}
+
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: `file`, Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ``, err
+	}
+	if u.Scheme != `file` {
+		return ``, px.Error(UnsupportedURI, issue.H{`uri`: uri})
+	}
+	return filepath.FromSlash(u.Path), nil
+}
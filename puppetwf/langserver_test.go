@@ -0,0 +1,334 @@
+package puppetwf_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/stretchr/testify/require"
+)
+
+const lspExample = `testdata/langserver/langserver_example.pp`
+
+// lspSession collects the messages that a client sends to the language server.
+type lspSession struct {
+	t       *testing.T
+	in      bytes.Buffer
+	lastID  int
+	options []puppetwf.Option
+}
+
+func (s *lspSession) send(msg map[string]interface{}) {
+	msg[`jsonrpc`] = `2.0`
+	body, err := json.Marshal(msg)
+	require.NoError(s.t, err)
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspSession) request(method string, params interface{}) int {
+	s.lastID++
+	s.send(map[string]interface{}{`id`: s.lastID, `method`: method, `params`: params})
+	return s.lastID
+}
+
+func (s *lspSession) notify(method string, params interface{}) {
+	s.send(map[string]interface{}{`method`: method, `params`: params})
+}
+
+func (s *lspSession) open(path, text string) string {
+	uri := fileURI(s.t, path)
+	s.notify(`textDocument/didOpen`, map[string]interface{}{
+		`textDocument`: map[string]interface{}{`uri`: uri, `languageId`: `puppet`, `version`: 1, `text`: text}})
+	return uri
+}
+
+func (s *lspSession) at(method, uri string, line, character int) int {
+	return s.request(method, map[string]interface{}{
+		`textDocument`: map[string]string{`uri`: uri},
+		`position`:     map[string]int{`line`: line, `character`: character}})
+}
+
+// run sends shutdown and exit, serves the session, and returns the messages that the server wrote.
+func (s *lspSession) run() []map[string]interface{} {
+	s.request(`shutdown`, nil)
+	s.notify(`exit`, nil)
+	out := &bytes.Buffer{}
+	require.NoError(s.t, puppetwf.ServeLanguageServer(&s.in, out, s.options...))
+
+	var msgs []map[string]interface{}
+	r := textproto.NewReader(bufio.NewReader(out))
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		require.NoError(s.t, err)
+		length, err := strconv.Atoi(header.Get(`Content-Length`))
+		require.NoError(s.t, err)
+		body := make([]byte, length)
+		_, err = io.ReadFull(r.R, body)
+		require.NoError(s.t, err)
+		var msg map[string]interface{}
+		require.NoError(s.t, json.Unmarshal(body, &msg))
+		msgs = append(msgs, msg)
+	}
+}
+
+func fileURI(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	require.NoError(t, err)
+	return `file://` + filepath.ToSlash(abs)
+}
+
+func result(t *testing.T, msgs []map[string]interface{}, id int) interface{} {
+	for _, msg := range msgs {
+		if msg[`id`] == float64(id) {
+			require.Nil(t, msg[`error`])
+			return msg[`result`]
+		}
+	}
+	require.Fail(t, `no response`, `request %d`, id)
+	return nil
+}
+
+func diagnostics(msgs []map[string]interface{}) [][]interface{} {
+	var published [][]interface{}
+	for _, msg := range msgs {
+		if msg[`method`] == `textDocument/publishDiagnostics` {
+			published = append(published, msg[`params`].(map[string]interface{})[`diagnostics`].([]interface{}))
+		}
+	}
+	return published
+}
+
+func readExample(t *testing.T) string {
+	content, err := ioutil.ReadFile(lspExample)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestLanguageServerDiagnostics(t *testing.T) {
+	s := &lspSession{t: t}
+	s.request(`initialize`, map[string]interface{}{})
+	text := readExample(t)
+	uri := s.open(lspExample, text)
+	s.notify(`textDocument/didChange`, map[string]interface{}{
+		`textDocument`:   map[string]interface{}{`uri`: uri, `version`: 2},
+		`contentChanges`: []interface{}{map[string]string{`text`: strings.Replace(text, `String $zone,`, `String $zone`, 1)}}})
+	s.notify(`textDocument/didChange`, map[string]interface{}{
+		`textDocument`:   map[string]interface{}{`uri`: uri, `version`: 3},
+		`contentChanges`: []interface{}{map[string]string{`text`: strings.Replace(text, `Integer $size`, `Integr $size`, 1)}}})
+	msgs := s.run()
+
+	capabilities := result(t, msgs, 1).(map[string]interface{})[`capabilities`].(map[string]interface{})
+	require.Equal(t, true, capabilities[`hoverProvider`])
+
+	published := diagnostics(msgs)
+	require.Len(t, published, 3)
+
+	var found []string
+	for _, d := range published[0] {
+		dm := d.(map[string]interface{})
+		start := dm[`range`].(map[string]interface{})[`start`].(map[string]interface{})
+		found = append(found, fmt.Sprintf(`%v:%v %s`, start[`line`], start[`character`], dm[`code`]))
+	}
+	require.Equal(t, []string{`8:11 PUPPETWF_MISSING_EXTERNAL_ID`, `19:9 PUPPETWF_UNDECLARED_PARAMETER`}, found)
+
+	require.Len(t, published[1], 1)
+	syntax := published[1][0].(map[string]interface{})
+	require.Equal(t, float64(1), syntax[`severity`])
+	require.Equal(t, `expected one of ',' or ')', got 'type name'`, syntax[`message`])
+	require.Equal(t, map[string]interface{}{`line`: float64(4), `character`: float64(4)}, syntax[`range`].(map[string]interface{})[`start`])
+
+	require.Len(t, published[2], 3)
+	unresolved := published[2][0].(map[string]interface{})
+	require.Equal(t, `PCORE_UNRESOLVED_TYPE`, unresolved[`code`])
+	require.Equal(t, map[string]interface{}{
+		`start`: map[string]interface{}{`line`: float64(4), `character`: float64(4)},
+		`end`:   map[string]interface{}{`line`: float64(4), `character`: float64(10)}}, unresolved[`range`])
+}
+
+func TestLanguageServerDiagnosesPanics(t *testing.T) {
+	s := &lspSession{t: t, options: []puppetwf.Option{func(c px.Context) { panic(`not an error`) }}}
+	s.request(`initialize`, map[string]interface{}{})
+	s.open(lspExample, readExample(t))
+	published := diagnostics(s.run())
+	require.Len(t, published, 1)
+	require.Len(t, published[0], 1)
+	require.Equal(t, `not an error`, published[0][0].(map[string]interface{})[`message`])
+}
+
+func TestLanguageServerCompletion(t *testing.T) {
+	s := &lspSession{t: t}
+	text := readExample(t)
+	uri := s.open(lspExample, text)
+	inBody := s.at(`textDocument/completion`, uri, 15, 4)
+	inProperties := s.at(`textDocument/completion`, uri, 10, 4)
+
+	// Completion uses the last analysis while the manifest doesn't parse
+	s.notify(`textDocument/didChange`, map[string]interface{}{
+		`textDocument`:   map[string]interface{}{`uri`: uri, `version`: 2},
+		`contentChanges`: []interface{}{map[string]string{`text`: strings.Replace(text, "zone => $zone,\n\n", "zone => $zone,\n    di\n", 1)}}})
+	whileTyping := s.at(`textDocument/completion`, uri, 15, 6)
+	msgs := s.run()
+
+	labels := func(id int) []string {
+		var ls []string
+		for _, item := range result(t, msgs, id).([]interface{}) {
+			ls = append(ls, item.(map[string]interface{})[`label`].(string))
+		}
+		return ls
+	}
+	require.Equal(t, []string{`diskId`}, labels(inBody))
+	require.Empty(t, labels(inProperties))
+	require.Equal(t, []string{`diskId`}, labels(whileTyping))
+
+	item := result(t, msgs, inBody).([]interface{})[0].(map[string]interface{})
+	require.Equal(t, `Optional[String] (provided)`, item[`detail`])
+	require.Equal(t, `diskId => `, item[`insertText`])
+}
+
+func TestLanguageServerHover(t *testing.T) {
+	s := &lspSession{t: t}
+	uri := s.open(lspExample, readExample(t))
+	parameter := s.at(`textDocument/hover`, uri, 13, 14)
+	outer := s.at(`textDocument/hover`, uri, 22, 27)
+	attribute := s.at(`textDocument/hover`, uri, 13, 5)
+	step := s.at(`textDocument/hover`, uri, 31, 20)
+	typ := s.at(`textDocument/hover`, uri, 11, 22)
+	nothing := s.at(`textDocument/hover`, uri, 0, 3)
+	msgs := s.run()
+
+	value := func(id int) string {
+		return result(t, msgs, id).(map[string]interface{})[`contents`].(map[string]interface{})[`value`].(string)
+	}
+	require.Equal(t, "```puppet\nInteger $size\n```\nparameter of resource disk", value(parameter))
+	require.Equal(t, "```puppet\nString $zone\n```\nparameter of workflow langserver_example", value(outer))
+	require.Equal(t, "```puppet\nsize => Integer\n```", value(attribute))
+	require.Equal(t, "```puppet\nworkflow langserver_example\n"+
+		"  parameters => (String $zone, Integer $size)\n"+
+		"  returns => (String $diskId)\n```\n\nCreates a disk in a zone.", value(step))
+	require.Contains(t, value(typ), "```puppet\nDisks::Disk\n```\n")
+	require.Contains(t, value(typ), "\n- `diskId`: `Optional[String]` (provided)")
+	require.Nil(t, result(t, msgs, nothing))
+}
+
+func TestLanguageServerDefinition(t *testing.T) {
+	s := &lspSession{t: t}
+	uri := s.open(lspExample, readExample(t))
+	called := s.at(`textDocument/definition`, uri, 31, 20)
+	typ := s.at(`textDocument/definition`, uri, 11, 22)
+	nested := s.at(`textDocument/definition`, uri, 8, 12)
+	msgs := s.run()
+
+	location := func(id int) string {
+		l := result(t, msgs, id).(map[string]interface{})
+		start := l[`range`].(map[string]interface{})[`start`].(map[string]interface{})
+		return fmt.Sprintf(`%s:%v:%v`, l[`uri`], start[`line`], start[`character`])
+	}
+	require.Equal(t, uri+`:1:9`, location(called))
+	require.Equal(t, fileURI(t, `testdata/langserver/types/Disks.pp`)+`:7:4`, location(typ))
+	require.Equal(t, uri+`:8:11`, location(nested))
+}
+
+func TestLanguageServerAddParameters(t *testing.T) {
+	text := readExample(t)
+	properties := "action report {\n    parameters => ($diskId),\n  }"
+	for _, tc := range []struct {
+		before, after, title string
+	}{
+		{properties, "action report {\n    parameters => ($diskId, $zone),\n  }", `Add missing parameter $zone`},
+		{"action report {\n    parameters => (),\n  }", "action report {\n    parameters => ($diskId, $zone),\n  }", `Add missing parameters $diskId, $zone`},
+		{"action report {\n    parameters => (\n      $diskId, # the id\n    ),\n  }", "action report {\n    parameters => (\n      $diskId, # the id\n      $zone,\n    ),\n  }", `Add missing parameter $zone`},
+		{"action report {\n    parameters => (\n      $diskId # the id\n    ),\n  }", "action report {\n    parameters => (\n      $diskId, # the id\n      $zone,\n    ),\n  }", `Add missing parameter $zone`},
+		{"action report {\n    returns => (),\n  }", "action report {\n    parameters => ($diskId, $zone),\n    returns => (),\n  }", `Add missing parameters $diskId, $zone`},
+		{"action report {}", "action report {\n    parameters => ($diskId, $zone)\n  }", `Add missing parameters $diskId, $zone`},
+	} {
+		before := strings.Replace(text, properties, tc.before, 1)
+		title, after := applyCodeAction(t, before)
+		require.Equal(t, tc.title, title)
+		require.Equal(t, strings.Replace(text, properties, tc.after, 1), after)
+	}
+}
+
+// applyCodeAction requests the code actions at the body of the action report in the given text and
+// returns the title of the single action and the text with its edits applied.
+func applyCodeAction(t *testing.T, text string) (string, string) {
+	s := &lspSession{t: t}
+	uri := s.open(lspExample, text)
+	line := strings.Count(text[:strings.Index(text, `notice(`)], "\n")
+	id := s.request(`textDocument/codeAction`, map[string]interface{}{
+		`textDocument`: map[string]string{`uri`: uri},
+		`range`:        map[string]interface{}{`start`: map[string]int{`line`: line, `character`: 4}, `end`: map[string]int{`line`: line, `character`: 4}},
+		`context`:      map[string]interface{}{`diagnostics`: []interface{}{}}})
+	msgs := s.run()
+
+	actions := result(t, msgs, id).([]interface{})
+	require.Len(t, actions, 1)
+	action := actions[0].(map[string]interface{})
+	edits := action[`edit`].(map[string]interface{})[`changes`].(map[string]interface{})[uri].([]interface{})
+
+	lines := strings.SplitAfter(text, "\n")
+	offset := func(p interface{}) int {
+		pm := p.(map[string]interface{})
+		o := 0
+		for _, l := range lines[:int(pm[`line`].(float64))] {
+			o += len(l)
+		}
+		return o + int(pm[`character`].(float64))
+	}
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i].(map[string]interface{})
+		r := e[`range`].(map[string]interface{})
+		text = text[:offset(r[`start`])] + e[`newText`].(string) + text[offset(r[`end`]):]
+	}
+	return action[`title`].(string), text
+}
+
+func TestLanguageServerDoesNotRunManifest(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `lsp`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	touched := func(name string) string { return filepath.Join(dir, name) }
+
+	s := &lspSession{t: t}
+	s.request(`initialize`, map[string]interface{}{})
+	s.open(filepath.Join(dir, `untrusted.pp`), fmt.Sprintf(`$x = exec('touch', '%s')
+
+workflow untrusted {
+  parameters => (String $name = exec('touch', '%s')),
+} {
+  action run {
+    parameters => ($name),
+    externalId => exec('touch', '%s'),
+  } {
+    exec('touch', $name)
+  }
+}
+`, touched(`top`), touched(`default`), touched(`property`)))
+	published := diagnostics(s.run())
+	require.Len(t, published, 1)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestLanguageServerExitWithoutShutdown(t *testing.T) {
+	s := &lspSession{t: t}
+	s.notify(`exit`, nil)
+	err := puppetwf.ServeLanguageServer(&s.in, ioutil.Discard)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `exit without shutdown`)
+}
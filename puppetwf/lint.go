@@ -0,0 +1,435 @@
+package puppetwf
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// Lint loads the manifests found in the given paths and returns warnings for steps that are valid
+// but most likely not what the author intended. A warning has one of these codes:
+//
+// UnusedParameter: a parameter of a workflow that none of its steps, locals, or returns use.
+//
+// UnproducedReturn: a return of a workflow that none of its steps return.
+//
+// MissingExternalId: a resource without an externalId, so the resource cannot be found when it
+// exists already.
+//
+// ExecUnquotedInterpolation: a call to exec with a command line where an interpolated value is not
+// quoted, which breaks when the value contains spaces.
+//
+// MissingUpdateFunction: a state handler without an update function, so each change deletes and
+// creates the resource.
+//
+// ShadowedVariable: a local, iteration variable, or return of a step in a nested workflow that has
+// the same name as a variable of an enclosing workflow.
+//
+// UndeclaredParameter: a variable that a resource, action, or state handler references but
+// doesn't declare as a parameter, so it has no value when the step runs.
+//
+// A warning is suppressed by a lint:ignore comment that lists its code, either at the end of the
+// line that the warning is reported for or alone on the line above it. A lint:ignore comment
+// without codes suppresses all warnings:
+//
+//	parameters => (
+//	  String $region, # lint:ignore PUPPETWF_UNUSED_PARAMETER
+//	),
+func Lint(paths []string, options ...Option) []issue.Reported {
+	var warnings []issue.Reported
+	WithService(`Puppet`, func(c pdsl.EvaluationContext, s serviceapi.Service) {
+		v, _ := c.Get(ManifestLoaderID)
+		ml := v.(*manifestLoader)
+		for _, f := range sourceFiles(paths) {
+			if f.typeSet {
+				continue
+			}
+			content, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				panic(px.Error(px.UnableToReadFile, issue.H{`path`: f.path, `detail`: err.Error()}))
+			}
+			_, ms, _ := ml.loadManifestSource(f.moduleDir, f.path, content, true)
+			warnings = append(warnings, lintSteps(ms.steps, content)...)
+		}
+	}, options...)
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		li, lj := warnings[i].Location(), warnings[j].Location()
+		if li.File() != lj.File() {
+			return li.File() < lj.File()
+		}
+		if li.Line() != lj.Line() {
+			return li.Line() < lj.Line()
+		}
+		return li.Pos() < lj.Pos()
+	})
+	return warnings
+}
+
+// lintSteps returns the warnings for the given steps that are not suppressed by a lint:ignore
+// comment in the content of the manifest that declares them.
+func lintSteps(steps []*puppetStep, content []byte) []issue.Reported {
+	l := &linter{}
+	for _, a := range steps {
+		l.step(a)
+	}
+	lines := strings.Split(string(content), "\n")
+	var warnings []issue.Reported
+	for _, w := range l.warnings {
+		if !suppressed(lines, w) {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+var lintIgnore = regexp.MustCompile(`#\s*lint:ignore\b([^#]*)`)
+
+// suppressed returns true when a lint:ignore comment suppresses the given warning.
+func suppressed(lines []string, w issue.Reported) bool {
+	line := w.Location().Line()
+	for _, n := range []int{line, line - 1} {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		text := lines[n-1]
+		if n != line && !strings.HasPrefix(strings.TrimSpace(text), `#`) {
+			continue
+		}
+		m := lintIgnore.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		codes := strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		if len(codes) == 0 || contains(codes, string(w.Code())) {
+			return true
+		}
+	}
+	return false
+}
+
+type linter struct {
+	warnings []issue.Reported
+}
+
+func (l *linter) warn(location issue.Location, code issue.Code, args issue.H) {
+	l.warnings = append(l.warnings, issue.NewReported(code, issue.SeverityWarning, args, location))
+}
+
+func (l *linter) step(a *puppetStep) {
+	switch a.Style() {
+	case `workflow`:
+		l.unusedParameters(a)
+		l.unproducedReturns(a)
+		if a.parent != nil {
+			l.shadowedVariables(a)
+		}
+	case `resource`:
+		if _, ok := a.properties.Get4(`externalId`); !ok {
+			l.warn(a.expression, MissingExternalId, issue.H{`step`: a.Name()})
+		}
+	case `stateHandler`:
+		l.missingUpdate(a)
+	}
+	for _, name := range a.undeclaredVariables() {
+		l.warn(a.expression, UndeclaredParameter, issue.H{`step`: a.Name(), `name`: name})
+	}
+	l.execCalls(a)
+	for _, c := range a.children {
+		l.step(c)
+	}
+}
+
+func (l *linter) unusedParameters(a *puppetStep) {
+	used := make(map[string]bool)
+	for _, c := range a.children {
+		for _, p := range c.parameters {
+			used[sourceName(p)] = true
+		}
+		for _, r := range variableReferences(c.property(`iteration`)) {
+			used[r] = true
+		}
+		for _, n := range conditionNames(c.getWhen()) {
+			used[n] = true
+		}
+	}
+	for _, lc := range a.locals {
+		for _, r := range lc.references {
+			used[r] = true
+		}
+	}
+	for _, r := range a.returns {
+		used[sourceName(r)] = true
+	}
+	for _, pe := range a.declared(`parameters`) {
+		if !used[pe.Name()] {
+			l.warn(pe, UnusedParameter, issue.H{`step`: a.Name(), `name`: pe.Name()})
+		}
+	}
+}
+
+func (l *linter) unproducedReturns(a *puppetStep) {
+	produced := make(map[string]bool)
+	for _, c := range a.children {
+		for _, r := range c.returns {
+			produced[r.Name()] = true
+		}
+	}
+	for _, p := range a.parameters {
+		produced[p.Name()] = true
+	}
+	for _, lc := range a.locals {
+		produced[lc.name] = true
+	}
+	for _, re := range a.declared(`returns`) {
+		if r := findParameter(a.returns, re.Name()); r != nil && !produced[sourceName(r)] {
+			l.warn(re, UnproducedReturn, issue.H{`step`: a.Name(), `name`: re.Name()})
+		}
+	}
+}
+
+// shadowedVariables warns about the names declared in the nested workflow a that are also
+// variables of an enclosing workflow. The parameters of a are not declarations since they receive
+// the values of those variables.
+func (l *linter) shadowedVariables(a *puppetStep) {
+	outer := make(map[string]string)
+	add := func(name string, w *puppetStep) {
+		if _, ok := outer[name]; !ok {
+			outer[name] = w.Name()
+		}
+	}
+	for child, w := a, a.parent; w != nil; child, w = w, w.parent {
+		for _, p := range w.parameters {
+			add(p.Name(), w)
+		}
+		for _, lc := range w.locals {
+			add(lc.name, w)
+		}
+		for _, c := range w.children {
+			if c != child {
+				for _, r := range c.returns {
+					add(r.Name(), w)
+				}
+			}
+		}
+	}
+
+	check := func(kind, name string, location issue.Location) {
+		if w, ok := outer[name]; ok {
+			l.warn(location, ShadowedVariable, issue.H{`kind`: kind, `name`: name, `step`: a.Name(), `outer`: w})
+		}
+	}
+	for _, lc := range a.locals {
+		check(`local`, lc.name, lc.expression)
+	}
+	for _, v := range a.variables {
+		check(`iteration variable`, v.Name(), a.expression)
+	}
+	for _, c := range a.children {
+		for _, re := range c.declared(`returns`) {
+			check(`return`, re.Name(), re)
+		}
+	}
+}
+
+func (l *linter) missingUpdate(a *puppetStep) {
+	if block, ok := a.definition().(*parser.BlockExpression); ok {
+		for _, e := range block.Statements() {
+			if fd, ok := e.(*parser.FunctionDefinition); ok && fd.Name() == `update` {
+				return
+			}
+		}
+	}
+	l.warn(a.expression, MissingUpdateFunction, issue.H{`step`: a.Name()})
+}
+
+// execCalls warns about calls to exec in the step that pass a command line with an unquoted
+// interpolated value. The steps nested in the step are not searched.
+func (l *linter) execCalls(a *puppetStep) {
+	nested := make(map[parser.Expression]bool, len(a.children))
+	for _, c := range a.children {
+		nested[c.expression] = true
+	}
+	var visit func(e parser.Expression)
+	visit = func(e parser.Expression) {
+		if nested[e] {
+			return
+		}
+		for _, arg := range execArguments(e) {
+			if cs, ok := arg.(*parser.ConcatenatedString); ok && unquotedInterpolation(cs) {
+				l.warn(arg, ExecUnquotedInterpolation, issue.H{`step`: a.Name(), `command`: arg.String()})
+			}
+		}
+		e.Contents(nil, func(_ []parser.Expression, c parser.Expression) { visit(c) })
+	}
+	visit(a.expression)
+}
+
+// execArguments returns the arguments of the given expression when it is a call to exec, or the
+// Deferred call to exec that the parser creates in the state of a resource.
+func execArguments(e parser.Expression) []parser.Expression {
+	switch call := e.(type) {
+	case *parser.CallNamedFunctionExpression:
+		if qn, ok := call.Functor().(*parser.QualifiedName); ok && qn.Name() == `exec` {
+			return call.Arguments()
+		}
+	case *parser.CallMethodExpression:
+		na, ok := call.Functor().(*parser.NamedAccessExpression)
+		if !ok {
+			return nil
+		}
+		if qr, ok := na.Lhs().(*parser.QualifiedReference); !ok || qr.Name() != `Deferred` {
+			return nil
+		}
+		if args := call.Arguments(); len(args) == 2 {
+			if s, ok := args[0].(*parser.LiteralString); ok && s.StringValue() == `exec` {
+				if ll, ok := args[1].(*parser.LiteralList); ok {
+					return ll.Elements()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// unquotedInterpolation returns true when the string is a command line, that is, it contains
+// whitespace, and it interpolates a value that is not enclosed in quotes.
+func unquotedInterpolation(cs *parser.ConcatenatedString) bool {
+	segments := cs.Segments()
+	literal := func(i int) string {
+		if i >= 0 && i < len(segments) {
+			if ls, ok := segments[i].(*parser.LiteralString); ok {
+				return ls.StringValue()
+			}
+		}
+		return ``
+	}
+
+	commandLine := false
+	for i := range segments {
+		if strings.ContainsAny(literal(i), " \t") {
+			commandLine = true
+			break
+		}
+	}
+	if !commandLine {
+		return false
+	}
+	for i, s := range segments {
+		if _, ok := s.(*parser.TextExpression); !ok {
+			continue
+		}
+		before, after := literal(i-1), literal(i+1)
+		if !(strings.HasSuffix(before, `'`) && strings.HasPrefix(after, `'`) || strings.HasSuffix(before, `"`) && strings.HasPrefix(after, `"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// undeclaredVariables returns the names of the variables that the definition of a resource,
+// action, or state handler references without declaring them. Variables that are assigned, or
+// declared as parameters of functions and lambdas, within the definition are declared. Qualified
+// variables and match variables are never reported.
+func (a *puppetStep) undeclaredVariables() []string {
+	def := a.definition()
+	if def == nil || a.Style() == `workflow` || a.Style() == `call` {
+		return nil
+	}
+	declared := make(map[string]bool)
+	if fd, ok := a.expression.(*parser.FunctionDefinition); ok {
+		for _, p := range fd.Parameters() {
+			declared[p.(*parser.Parameter).Name()] = true
+		}
+	}
+	def.AllContents(nil, func(_ []parser.Expression, e parser.Expression) {
+		switch e := e.(type) {
+		case *parser.AssignmentExpression:
+			if ve, ok := e.Lhs().(*parser.VariableExpression); ok {
+				if name, ok := ve.Name(); ok {
+					declared[name] = true
+				}
+			}
+		case *parser.Parameter:
+			declared[e.Name()] = true
+		}
+	})
+
+	var names []string
+	for _, r := range variableReferences(def) {
+		if strings.Contains(r, `::`) || strings.Trim(r, `0123456789`) == `` {
+			// Qualified names are global and numeric names are match results
+			continue
+		}
+		if !(declared[r] || findParameter(a.parameters, r) != nil || findParameter(a.variables, r) != nil || a.findLocal(r) != nil) {
+			names = append(names, r)
+		}
+	}
+	return names
+}
+
+// declared returns the parameter expressions of the given property, parameters or returns, as
+// written in the manifest.
+func (a *puppetStep) declared(key string) []*parser.Parameter {
+	ll, ok := a.property(key).(*parser.LiteralList)
+	if !ok {
+		return nil
+	}
+	var ps []*parser.Parameter
+	for _, e := range ll.Elements() {
+		if p, ok := e.(*parser.Parameter); ok {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// property returns the expression of the given property of the step, or nil when the step doesn't
+// have it.
+func (a *puppetStep) property(key string) parser.Expression {
+	se, ok := a.expression.(*parser.StepExpression)
+	if !ok {
+		return nil
+	}
+	lh, ok := se.Properties().(*parser.LiteralHash)
+	if !ok {
+		return nil
+	}
+	for _, e := range lh.Entries() {
+		ke := e.(*parser.KeyedEntry)
+		if qn, ok := ke.Key().(*parser.QualifiedName); ok && qn.Name() == key {
+			return ke.Value()
+		}
+	}
+	return nil
+}
+
+// sourceName returns the name of the variable that the given parameter is bound to in the scope
+// of the workflow, which is the alias when it has one.
+func sourceName(p serviceapi.Parameter) string {
+	if p.Alias() != `` {
+		return p.Alias()
+	}
+	return p.Name()
+}
+
+var conditionWord = regexp.MustCompile(`[a-z_][A-Za-z0-9_]*`)
+
+// conditionNames returns the names of the variables in a when condition.
+func conditionNames(when string) []string {
+	var names []string
+	for _, w := range conditionWord.FindAllString(when, -1) {
+		if w != `and` && w != `or` && w != `not` {
+			names = append(names, w)
+		}
+	}
+	return names
+}
@@ -0,0 +1,57 @@
+package puppetwf_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	var found []string
+	for _, w := range puppetwf.Lint([]string{`testdata/lint/lint_example.pp`}) {
+		found = append(found, fmt.Sprintf(`%d %s`, w.Location().Line(), w.Code()))
+	}
+	require.Equal(t, []string{
+		`13 ` + puppetwf.UnusedParameter,
+		`18 ` + puppetwf.UnproducedReturn,
+		`21 ` + puppetwf.MissingExternalId,
+		`37 ` + puppetwf.UndeclaredParameter,
+		`49 ` + puppetwf.ShadowedVariable,
+		`51 ` + puppetwf.ExecUnquotedInterpolation,
+		`54 ` + puppetwf.ExecUnquotedInterpolation,
+		`58 ` + puppetwf.MissingUpdateFunction,
+	}, found)
+}
+
+func TestLintMessage(t *testing.T) {
+	ws := puppetwf.Lint([]string{`testdata/lint/lint_example.pp`})
+	require.Equal(t, `parameter 'region' of workflow lint_example is not used by any of its steps (file: testdata/lint/lint_example.pp, line: 13, column: 5)`, ws[0].Error())
+	require.Equal(t, `step report references $color which is not one of its parameters (file: testdata/lint/lint_example.pp, line: 37, column: 9)`, ws[3].Error())
+	require.Equal(t, `return 'name' in workflow nested shadows the variable with the same name in workflow lint_example (file: testdata/lint/lint_example.pp, line: 49, column: 33)`, ws[4].Error())
+	require.Equal(t, `exec in step copy interpolates a value unquoted into the command line "-l ${thingId}", pass the value as a separate argument (file: testdata/lint/lint_example.pp, line: 51, column: 29)`, ws[5].Error())
+	for _, w := range ws {
+		require.NotEqual(t, 53, w.Location().Line(), `a lint:ignore without codes must suppress all warnings`)
+	}
+}
+
+func TestLintCleanManifest(t *testing.T) {
+	require.Empty(t, puppetwf.Lint([]string{`testdata/action_returns.pp`}))
+}
+
+func TestLintDoesNotRunManifest(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `lint`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, `untrusted.pp`)
+	touched := filepath.Join(dir, `touched`)
+	require.NoError(t, ioutil.WriteFile(manifest, []byte(fmt.Sprintf("$x = exec('touch', '%s')\n\nworkflow untrusted {\n} {\n}\n", touched)), 0644))
+
+	require.Empty(t, puppetwf.Lint([]string{manifest}))
+	_, err = os.Stat(touched)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,238 @@
+package puppetwf
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// local is a value declared in the `locals` property of a workflow. It is computed from the
+// parameters of the workflow and other locals, and it is visible to all steps in the workflow.
+type local struct {
+	name       string
+	owner      *puppetStep
+	expression parser.Expression
+	references []string
+
+	lock   sync.Mutex
+	values map[string]px.Value
+}
+
+// newLocals creates the locals declared in the given hash expression. The returned locals are
+// sorted so that each local appears after all locals that it references.
+func newLocals(c pdsl.EvaluationContext, owner *puppetStep, expr parser.Expression) []*local {
+	hash, ok := expr.(*parser.LiteralHash)
+	if !ok {
+		panic(px.Error2(expr, LocalsNotHash, issue.H{`step`: owner.Name()}))
+	}
+
+	declared := make(map[string]*local, len(hash.Entries()))
+	order := make([]*local, 0, len(hash.Entries()))
+	for _, e := range hash.Entries() {
+		ke := e.(*parser.KeyedEntry)
+		l := &local{name: pdsl.Evaluate(c, ke.Key()).String(), owner: owner, expression: ke.Value(), references: variableReferences(ke.Value())}
+		declared[l.name] = l
+		order = append(order, l)
+	}
+
+	sorted := make([]*local, 0, len(order))
+	state := make(map[*local]int, len(order))
+	var visit func(l *local)
+	visit = func(l *local) {
+		switch state[l] {
+		case 1:
+			panic(px.Error2(l.expression, LocalsCycle, issue.H{`step`: owner.Name(), `name`: l.name}))
+		case 2:
+			return
+		}
+		state[l] = 1
+		for _, r := range l.references {
+			if dl, ok := declared[r]; ok {
+				visit(dl)
+			}
+		}
+		state[l] = 2
+		sorted = append(sorted, l)
+	}
+	for _, l := range order {
+		visit(l)
+	}
+	return sorted
+}
+
+// value returns the value of the local. All steps of a run of the workflow are invoked with the
+// same workflow parameters, so the value is computed once for each distinct set of values of the
+// variables that the local references and then shared by those steps. The set is identified by a
+// hash of the unwrapped values so that runs that differ only in a Sensitive value don't share the
+// value and the Sensitive values aren't retained.
+func (l *local) value(c pdsl.EvaluationContext, scope pdsl.Scope) px.Value {
+	h := sha256.New()
+	for _, r := range l.references {
+		if v, ok := scope.Get2(r); ok {
+			px.ToString3(unwrapSensitive(c, v), h)
+		}
+		h.Write([]byte{0})
+	}
+	key := string(h.Sum(nil))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if v, ok := l.values[key]; ok {
+		return v
+	}
+	if l.values == nil {
+		l.values = make(map[string]px.Value)
+	}
+	v := pdsl.Evaluate(c, l.expression)
+	l.values[key] = v
+	return v
+}
+
+// evaluateLocals assigns the values of the given locals to the current local scope. The locals
+// must be sorted in dependency order.
+func evaluateLocals(c pdsl.EvaluationContext, scope pdsl.Scope, locals []*local) {
+	for _, l := range locals {
+		scope.Set(l.name, l.value(c, scope))
+	}
+}
+
+// findLocal returns the local with the given name that is declared by this step or the closest
+// of its ancestors.
+func (a *puppetStep) findLocal(name string) *local {
+	for p := a; p != nil; p = p.parent {
+		for _, l := range p.locals {
+			if l.name == name {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// validateLocals asserts that each variable referenced by a local of this step is a parameter
+// of the step or a local that is visible to the step.
+func (a *puppetStep) validateLocals() {
+	for _, l := range a.locals {
+		for _, r := range l.references {
+			if findParameter(a.parameters, r) == nil && a.findLocal(r) == nil {
+				panic(px.Error2(l.expression, UnknownLocalReference, issue.H{`step`: a.Name(), `name`: l.name, `reference`: r}))
+			}
+		}
+	}
+}
+
+// requiredLocals returns the locals of the ancestors of this step that must be computed before
+// the step can run, sorted in dependency order. A local that is shadowed by a parameter of the
+// step is not included.
+func (a *puppetStep) requiredLocals() []*local {
+	if a.parent == nil {
+		return nil
+	}
+
+	var required []*local
+	added := make(map[*local]bool)
+	var add func(l *local)
+	add = func(l *local) {
+		if added[l] {
+			return
+		}
+		added[l] = true
+		for _, r := range l.references {
+			if dl := l.owner.findLocal(r); dl != nil && dl != l {
+				add(dl)
+			}
+		}
+		required = append(required, l)
+	}
+
+	for _, r := range variableReferences(a.definition()) {
+		if findParameter(a.parameters, r) == nil {
+			if l := a.parent.findLocal(r); l != nil {
+				add(l)
+			}
+		}
+	}
+	for _, l := range a.locals {
+		for _, r := range l.references {
+			if dl := a.parent.findLocal(r); dl != nil && findParameter(a.parameters, r) == nil {
+				add(dl)
+			}
+		}
+	}
+	return required
+}
+
+// addLocalParameters adds the workflow parameters that the given locals depend on to the
+// parameters of this step unless the step already has a parameter with the same name.
+func (a *puppetStep) addLocalParameters(locals []*local) {
+	for _, l := range locals {
+		for _, r := range l.references {
+			if findParameter(a.parameters, r) != nil || l.owner.findLocal(r) != nil {
+				continue
+			}
+			if op := findParameter(l.owner.parameters, r); op != nil {
+				a.parameters = append(a.parameters, serviceapi.NewParameter(r, ``, op.Type(), nil))
+			}
+		}
+	}
+}
+
+// definition returns the expression that constitutes the body of this step.
+func (a *puppetStep) definition() parser.Expression {
+	switch e := a.expression.(type) {
+	case *parser.FunctionDefinition:
+		return e.Body()
+	case *parser.StepExpression:
+		return e.Definition()
+	}
+	return nil
+}
+
+// variableReferences returns the names of all variables referenced by the given expression,
+// including references that the parser has converted into Deferred values.
+func variableReferences(expr parser.Expression) []string {
+	if expr == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	visit := func(_ []parser.Expression, e parser.Expression) {
+		name := ``
+		switch e := e.(type) {
+		case *parser.VariableExpression:
+			name, _ = e.Name()
+		case *parser.CallMethodExpression:
+			name = deferredVariable(e)
+		}
+		if name != `` && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	visit(nil, expr)
+	expr.AllContents(nil, visit)
+	return names
+}
+
+// deferredVariable returns the name of the variable if the given call is Deferred.new('$name')
+func deferredVariable(call *parser.CallMethodExpression) string {
+	na, ok := call.Functor().(*parser.NamedAccessExpression)
+	if !ok {
+		return ``
+	}
+	if qr, ok := na.Lhs().(*parser.QualifiedReference); !ok || qr.Name() != `Deferred` {
+		return ``
+	}
+	if args := call.Arguments(); len(args) == 1 {
+		if s, ok := args[0].(*parser.LiteralString); ok && strings.HasPrefix(s.StringValue(), `$`) {
+			return s.StringValue()[1:]
+		}
+	}
+	return ``
+}
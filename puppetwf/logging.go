@@ -0,0 +1,87 @@
+package puppetwf
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/evaluator"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+)
+
+// loggingContext is an evaluation context that sends the output of the Puppet logging functions
+// debug, info, notice, warning, and err to the logger of the step that is currently running.
+type loggingContext struct {
+	pdsl.EvaluationContext
+	evaluator pdsl.Evaluator
+}
+
+// withStepLogging returns an evaluation context that delegates to the given context but routes
+// all logging to the step logger. The evaluator of the returned context is bound to the returned
+// context so that functions called during evaluation will see its logger.
+func withStepLogging(c px.Context) pdsl.EvaluationContext {
+	if lc, ok := c.(*loggingContext); ok {
+		return lc
+	}
+	lc := &loggingContext{EvaluationContext: c.(pdsl.EvaluationContext)}
+	lc.evaluator = evaluator.NewEvaluator(lc)
+	return lc
+}
+
+func (c *loggingContext) Fork() px.Context {
+	return withStepLogging(c.EvaluationContext.Fork())
+}
+
+func (c *loggingContext) GetEvaluator() pdsl.Evaluator {
+	return c.evaluator
+}
+
+func (c *loggingContext) Logger() px.Logger {
+	return &stepLog{c}
+}
+
+// stepLog is a px.Logger that emits structured hclog entries using the step logger. Each entry
+// includes the file and line of the manifest expression that produced it.
+type stepLog struct {
+	ctx px.Context
+}
+
+func (l *stepLog) Log(level px.LogLevel, args ...px.Value) {
+	w := bytes.NewBufferString(``)
+	for _, arg := range args {
+		px.ToString3(arg, w)
+	}
+	l.log(level, w.String())
+}
+
+func (l *stepLog) Logf(level px.LogLevel, format string, args ...interface{}) {
+	l.log(level, fmt.Sprintf(format, args...))
+}
+
+func (l *stepLog) LogIssue(i issue.Reported) {
+	switch i.Severity() {
+	case issue.SeverityError:
+		l.log(px.ERR, i.Error())
+	case issue.SeverityWarning, issue.SeverityDeprecation:
+		l.log(px.WARNING, i.Error())
+	}
+}
+
+func (l *stepLog) log(level px.LogLevel, msg string) {
+	var kv []interface{}
+	if loc := l.ctx.StackTop(); loc != nil && loc.File() != `` {
+		kv = []interface{}{`file`, loc.File(), `line`, loc.Line()}
+	}
+	log := StepLogger(l.ctx)
+	switch level {
+	case px.DEBUG:
+		log.Debug(msg, kv...)
+	case px.INFO, px.NOTICE:
+		log.Info(msg, kv...)
+	case px.WARNING:
+		log.Warn(msg, kv...)
+	default:
+		log.Error(msg, kv...)
+	}
+}
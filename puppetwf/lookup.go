@@ -0,0 +1,337 @@
+package puppetwf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/yaml"
+)
+
+const LookupKey = `Puppet::Lookup`
+
+// LookupLevel is one level of the lookup hierarchy. Exactly one of Paths, Env, and Exec should be
+// set. All three are subject to interpolation.
+//
+// Paths are data files, relative to the data directory, in YAML or JSON format. Files that don't
+// exist are ignored. A dotted key such as 'aws.tags' looks up 'aws' in the file and then digs
+// into the found value using 'tags'.
+//
+// Env is a prefix for environment variables. The key 'aws.region' with prefix 'LYRA_' is found in
+// the variable LYRA_AWS_REGION.
+//
+// Exec is a command that is executed with the key as its only argument. An exit status of zero
+// means that the value was found. Its output is then parsed as YAML or JSON. An exit status of one
+// means that the value wasn't found. Any other status is an error.
+//
+// Values found in a Sensitive level, such as one that reads from a secret store, are wrapped in a
+// Sensitive.
+type LookupLevel struct {
+	Name      string
+	Paths     []string
+	Env       string
+	Exec      string
+	Sensitive bool
+}
+
+// LookupConfig is a hiera like configuration that controls where lookup() reads its values from.
+// The levels of the hierarchy are searched in order and the first level that has a value for the
+// key wins.
+//
+// Strings in the configuration and string values found in data files may contain interpolation
+// expressions. The expression %{name} is replaced with the value of the variable name or, when no
+// such variable exists, with the value of the environment variable name. The expression
+// %{lookup('key')} is replaced with the value found for key. A string that interpolates a Sensitive
+// value becomes Sensitive.
+type LookupConfig struct {
+	DataDir   string
+	Variables map[string]string
+	Hierarchy []LookupLevel
+
+	lock  sync.Mutex
+	files map[string]px.OrderedMap
+}
+
+// LoadLookupConfig reads a lookup configuration from the given YAML or JSON file. A relative data
+// directory is relative to the directory of the file. Example:
+//
+//	datadir: data
+//	variables:
+//	  environment: production
+//	hierarchy:
+//	  - name: Environment variables
+//	    env: LYRA_
+//	  - name: Per environment
+//	    path: "%{environment}.yaml"
+//	  - name: Common
+//	    paths: [common.yaml, common.json]
+//	  - name: Secrets
+//	    exec: ./secrets.sh
+//	    sensitive: true
+func LoadLookupConfig(c px.Context, path string) *LookupConfig {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	cv, ok := yaml.Unmarshal(c, content).(px.OrderedMap)
+	if !ok {
+		panic(px.Error(InvalidLookupConfig, issue.H{`path`: path, `detail`: `expected a Hash`}))
+	}
+
+	cfg := &LookupConfig{DataDir: cv.Get5(`datadir`, types.WrapString(`data`)).String(), Variables: map[string]string{}}
+	if !filepath.IsAbs(cfg.DataDir) {
+		cfg.DataDir = filepath.Join(filepath.Dir(path), cfg.DataDir)
+	}
+	if vs, ok := cv.Get4(`variables`); ok {
+		vs.(px.OrderedMap).EachPair(func(k, v px.Value) { cfg.Variables[k.String()] = v.String() })
+	}
+	if hv, ok := cv.Get4(`hierarchy`); ok {
+		hl, ok := hv.(px.List)
+		if !ok {
+			panic(px.Error(InvalidLookupConfig, issue.H{`path`: path, `detail`: `hierarchy must be an Array`}))
+		}
+		hl.Each(func(lv px.Value) {
+			lh, ok := lv.(px.OrderedMap)
+			if !ok {
+				panic(px.Error(InvalidLookupConfig, issue.H{`path`: path, `detail`: `hierarchy entries must be Hashes`}))
+			}
+			level := LookupLevel{Name: lh.Get5(`name`, px.EmptyString).String()}
+			if p, ok := lh.Get4(`path`); ok {
+				level.Paths = []string{p.String()}
+			} else if ps, ok := lh.Get4(`paths`); ok {
+				ps.(px.List).Each(func(p px.Value) { level.Paths = append(level.Paths, p.String()) })
+			}
+			if e, ok := lh.Get4(`env`); ok {
+				level.Env = e.String()
+			}
+			if e, ok := lh.Get4(`exec`); ok {
+				level.Exec = e.String()
+				if !filepath.IsAbs(level.Exec) && strings.ContainsRune(level.Exec, filepath.Separator) {
+					level.Exec = filepath.Join(filepath.Dir(path), level.Exec)
+				}
+			}
+			if s, ok := lh.Get4(`sensitive`); ok {
+				level.Sensitive = s.Equals(types.BooleanTrue, nil)
+			}
+			cfg.Hierarchy = append(cfg.Hierarchy, level)
+		})
+	}
+	return cfg
+}
+
+// Lookup returns the value for the given key and true, or nil and false when no level of the
+// hierarchy has a value for the key.
+func (l *LookupConfig) Lookup(c px.Context, key string) (px.Value, bool) {
+	return l.lookup(c, key, map[string]bool{})
+}
+
+func (l *LookupConfig) lookup(c px.Context, key string, visiting map[string]bool) (px.Value, bool) {
+	if visiting[key] {
+		panic(px.Error(LookupCycle, issue.H{`key`: key}))
+	}
+	visiting[key] = true
+	defer delete(visiting, key)
+
+	for _, level := range l.Hierarchy {
+		if v, ok := l.lookupLevel(c, &level, key, visiting); ok {
+			v = l.interpolateValue(c, v, visiting)
+			if level.Sensitive {
+				v = sensitiveValue(types.DefaultSensitiveType(), v)
+			}
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (l *LookupConfig) lookupLevel(c px.Context, level *LookupLevel, key string, visiting map[string]bool) (px.Value, bool) {
+	switch {
+	case level.Env != ``:
+		name := l.interpolate(c, level.Env, visiting) + strings.ToUpper(strings.Replace(key, `.`, `_`, -1))
+		if s, ok := os.LookupEnv(name); ok {
+			return types.WrapString(s), true
+		}
+	case level.Exec != ``:
+		return l.lookupExec(c, level, key, visiting)
+	default:
+		segments := strings.Split(key, `.`)
+		for _, p := range level.Paths {
+			data := l.dataFile(c, filepath.Join(l.DataDir, l.interpolate(c, p, visiting)))
+			if v, ok := dig(data, segments); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (l *LookupConfig) lookupExec(c px.Context, level *LookupLevel, key string, visiting map[string]bool) (px.Value, bool) {
+	cmd := exec.Command(l.interpolate(c, level.Exec, visiting), key)
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+			return nil, false
+		}
+		panic(px.Error(LookupExecFailed, issue.H{`level`: level.Name, `key`: key, `detail`: strings.TrimSpace(err.Error() + ` ` + stderr.String())}))
+	}
+	return yaml.Unmarshal(c, out.Bytes()), true
+}
+
+// dataFile returns the contents of the given data file, or nil if the file doesn't exist. The
+// contents of each file is read once.
+func (l *LookupConfig) dataFile(c px.Context, path string) px.OrderedMap {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if data, ok := l.files[path]; ok {
+		return data
+	}
+	if l.files == nil {
+		l.files = make(map[string]px.OrderedMap)
+	}
+
+	var data px.OrderedMap
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		switch v := yaml.Unmarshal(c, content).(type) {
+		case px.OrderedMap:
+			data = v
+		case *types.UndefValue:
+			data = px.EmptyMap
+		default:
+			panic(px.Error(InvalidLookupData, issue.H{`path`: path}))
+		}
+	} else if !os.IsNotExist(err) {
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	l.files[path] = data
+	return data
+}
+
+func dig(data px.OrderedMap, segments []string) (px.Value, bool) {
+	if data == nil {
+		return nil, false
+	}
+	var v px.Value = data
+	for _, s := range segments {
+		h, ok := v.(px.OrderedMap)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = h.Get4(s); !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+var interpolationPattern = regexp.MustCompile(`%\{\s*(?:lookup\(\s*(?:'([^']*)'|"([^"]*)")\s*\)|([^}\s]*))\s*}`)
+
+// interpolate replaces all interpolation expressions in the given string.
+func (l *LookupConfig) interpolate(c px.Context, s string, visiting map[string]bool) string {
+	is, _ := l.interpolateSensitive(c, s, visiting)
+	return is
+}
+
+// interpolateSensitive replaces all interpolation expressions in the given string and returns the
+// result together with a flag that is true when a Sensitive value was interpolated.
+func (l *LookupConfig) interpolateSensitive(c px.Context, s string, visiting map[string]bool) (string, bool) {
+	if !strings.Contains(s, `%{`) {
+		return s, false
+	}
+	sensitive := false
+	return interpolationPattern.ReplaceAllStringFunc(s, func(expr string) string {
+		m := interpolationPattern.FindStringSubmatch(expr)
+		if key := m[1] + m[2]; key != `` {
+			v, ok := l.lookup(c, key, visiting)
+			if !ok {
+				panic(px.Error(LookupNotFound, issue.H{`key`: key}))
+			}
+			if sv, ok := v.(*types.Sensitive); ok {
+				sensitive = true
+				v = sv.Unwrap()
+			}
+			return v.String()
+		}
+		if v, ok := l.Variables[m[3]]; ok {
+			return v
+		}
+		return os.Getenv(m[3])
+	}), sensitive
+}
+
+// interpolateValue interpolates all strings found in the given value.
+func (l *LookupConfig) interpolateValue(c px.Context, v px.Value, visiting map[string]bool) px.Value {
+	switch v := v.(type) {
+	case px.StringValue:
+		s, sensitive := l.interpolateSensitive(c, v.String(), visiting)
+		if sensitive {
+			return types.WrapSensitive(types.WrapString(s))
+		}
+		return types.WrapString(s)
+	case px.OrderedMap:
+		return v.MapValues(func(ev px.Value) px.Value { return l.interpolateValue(c, ev, visiting) })
+	case px.List:
+		return v.Map(func(ev px.Value) px.Value { return l.interpolateValue(c, ev, visiting) })
+	}
+	return v
+}
+
+// WithLookup makes lookup() use the given configuration.
+func WithLookup(cfg *LookupConfig) Option {
+	return func(c px.Context) {
+		c.Set(LookupKey, cfg)
+	}
+}
+
+// WithLookupConfig makes lookup() use the configuration read from the given file.
+func WithLookupConfig(path string) Option {
+	return func(c px.Context) {
+		c.Set(LookupKey, LoadLookupConfig(c, path))
+	}
+}
+
+func init() {
+	lookup := func(c px.Context, key string) (px.Value, bool) {
+		if v, ok := c.Get(LookupKey); ok && !sandboxed(c) {
+			return v.(*LookupConfig).Lookup(c, key)
+		}
+		return nil, false
+	}
+
+	px.NewGoFunction(`lookup`,
+		func(d px.Dispatch) {
+			d.Param(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if v, ok := lookup(c, args[0].String()); ok {
+					return v
+				}
+				if sandboxed(c) {
+					return px.Undef
+				}
+				panic(px.Error(LookupNotFound, issue.H{`key`: args[0].String()}))
+			})
+		},
+
+		func(d px.Dispatch) {
+			d.Param(`String`)
+			d.Param(`Any`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if v, ok := lookup(c, args[0].String()); ok {
+					return v
+				}
+				return args[1]
+			})
+		},
+	)
+}
@@ -0,0 +1,53 @@
+package puppetwf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-evaluator/puppet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupHierarchy(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		cfg := LoadLookupConfig(c, `testdata/lookup/lookup.yaml`)
+		lookup := func(key string) string {
+			v, ok := cfg.Lookup(c, key)
+			require.True(t, ok, key)
+			return v.String()
+		}
+
+		require.Equal(t, `eu-west-1`, lookup(`aws.region`))
+		require.Equal(t, `{'created_by' => 'lyra', 'zone' => 'eu-west-1a'}`, lookup(`aws.tags`))
+		require.Equal(t, `Sensitive [value redacted]`, lookup(`db.password`))
+		pw, _ := cfg.Lookup(c, `db.password`)
+		require.Equal(t, `s3cr3t`, pw.(*types.Sensitive).Unwrap().String())
+
+		require.NoError(t, os.Setenv(`PUPPETWF_TEST_AWS_REGION`, `us-east-1`))
+		require.NoError(t, os.Setenv(`USER_NAME`, `bob`))
+		defer func() {
+			_ = os.Unsetenv(`PUPPETWF_TEST_AWS_REGION`)
+			_ = os.Unsetenv(`USER_NAME`)
+		}()
+		require.Equal(t, `us-east-1a`, lookup(`aws.tags.zone`))
+		require.Equal(t, `bob`, lookup(`owner`))
+
+		_, ok := cfg.Lookup(c, `no.such.key`)
+		require.False(t, ok)
+	})
+}
+
+func TestLookupCycle(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		cfg := &LookupConfig{Variables: map[string]string{}, Hierarchy: []LookupLevel{{Name: `Env`, Env: `PUPPETWF_TEST_`}}}
+		require.NoError(t, os.Setenv(`PUPPETWF_TEST_A`, `%{lookup('a')}`))
+		defer func() { _ = os.Unsetenv(`PUPPETWF_TEST_A`) }()
+		require.Panics(t, func() { cfg.Lookup(c, `a`) })
+
+		WithLookup(cfg)(c)
+		require.Equal(t, `fallback`, px.Call(c, `lookup`, []px.Value{px.Wrap(c, `b`), px.Wrap(c, `fallback`)}, nil).String())
+	})
+}
@@ -0,0 +1,268 @@
+package puppetwf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/serialization"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/annotation"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// memoryHandler is a state handler that keeps all states in memory, and optionally in a JSON file,
+// so that workflows can run without access to real resources. It can handle any object type.
+//
+// A manifest registers a memory handler using:
+//
+//	registerHandler(Aws::Vpc, memoryHandler(Aws::Vpc))
+//
+// or, to keep the states between runs:
+//
+//	registerHandler(Aws::Vpc, memoryHandler(Aws::Vpc, 'vpc_states.json'))
+type memoryHandler struct {
+	handlerType px.ObjectType
+	stateType   px.ObjectType
+	provided    []string
+	file        string
+	lock        sync.Mutex
+	count       int
+	states      map[string]px.OrderedMap
+}
+
+// NewMemoryHandler returns a handler for the given object type that keeps the states in memory.
+// When file is not empty, the states are read from that file and written to it after each
+// change. The type of the handler is named after the state type with the suffix MemoryHandler.
+func NewMemoryHandler(c px.Context, stateType px.ObjectType, file string) px.PuppetObject {
+	ht := types.MakeObjectType(stateType.Name()+`MemoryHandler`, wf.CrudType, px.EmptyMap, false)
+	ht.Resolve(c)
+
+	var provided []string
+	if a, ok := stateType.Annotations(c).Get(annotation.ResourceType); ok {
+		provided = a.(annotation.Resource).ProvidedAttributes()
+	}
+	h := &memoryHandler{handlerType: ht, stateType: stateType, provided: provided, file: file, states: make(map[string]px.OrderedMap)}
+	if file != `` {
+		h.load(c)
+	}
+	return h
+}
+
+func (h *memoryHandler) String() string {
+	return px.ToString(h)
+}
+
+func (h *memoryHandler) Equals(other interface{}, guard px.Guard) bool {
+	return h == other
+}
+
+func (h *memoryHandler) ToString(bld io.Writer, format px.FormatContext, g px.RDetect) {
+	types.ObjectToString(h, format, bld, g)
+}
+
+func (h *memoryHandler) PType() px.Type {
+	return h.handlerType
+}
+
+func (h *memoryHandler) Get(key string) (px.Value, bool) {
+	if key == `name` {
+		return types.WrapString(h.handlerType.Name()), true
+	}
+	return nil, false
+}
+
+func (h *memoryHandler) InitHash() px.OrderedMap {
+	return px.SingletonMap(`name`, types.WrapString(h.handlerType.Name()))
+}
+
+func (h *memoryHandler) Call(c px.Context, method px.ObjFunc, args []px.Value, block px.Lambda) (result px.Value, ok bool) {
+	switch method.Name() {
+	case `create`, `read`, `update`, `delete`:
+		defer notifyHandlerObserver(c, h.handlerType.Name(), method.Name(), args, &result)
+		return intercept(c, h.handlerType.Name(), method.Name(), args, func() px.Value {
+			return h.call(c, method.Name(), args)
+		}), true
+	}
+	return nil, false
+}
+
+func (h *memoryHandler) call(c px.Context, method string, args []px.Value) px.Value {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	switch method {
+	case `create`:
+		// The count is only incremented once the new state is known to be valid
+		n := h.count + 1
+		id := fmt.Sprintf(`%s-%d`, strings.ToLower(simpleName(h.stateType.Name())), n)
+		state := h.withProvided(args[0].(px.PuppetObject).InitHash(), id, n)
+		created := newResolvedState(c, h.stateType, state)
+		h.count = n
+		h.states[id] = state
+		h.save(c)
+		return types.WrapValues([]px.Value{created, types.WrapString(id)})
+	case `read`:
+		state := h.state(args[0].String())
+		return newResolvedState(c, h.stateType, state)
+	case `update`:
+		id := args[0].String()
+		old := h.state(id)
+		state := args[1].(px.PuppetObject).InitHash()
+		for _, name := range h.provided {
+			if v, ok := old.Get4(name); ok {
+				state = state.Merge(px.SingletonMap(name, v))
+			}
+		}
+		updated := newResolvedState(c, h.stateType, state)
+		h.states[id] = state
+		h.save(c)
+		return updated
+	case `delete`:
+		id := args[0].String()
+		h.state(id)
+		delete(h.states, id)
+		h.save(c)
+		return types.BooleanTrue
+	}
+	return nil
+}
+
+// state returns the state with the given id or panics with wf.NotFound.
+func (h *memoryHandler) state(id string) px.OrderedMap {
+	if state, ok := h.states[id]; ok {
+		return state
+	}
+	panic(wf.NotFound)
+}
+
+// withProvided returns the given state where each provided attribute that has no value is given a
+// deterministic fake value. The attribute named id, and the attribute named after the type with the
+// suffix Id, such as vpcId for Aws::Vpc, are given the external id of the state. Other values are
+// derived from the given sequence number.
+func (h *memoryHandler) withProvided(state px.OrderedMap, id string, n int) px.OrderedMap {
+	idAttr := strings.ToLower(simpleName(h.stateType.Name())) + `id`
+	for _, name := range h.provided {
+		if v, ok := state.Get4(name); ok && !v.Equals(px.Undef, nil) {
+			continue
+		}
+		m, ok := h.stateType.Member(name)
+		if !ok {
+			continue
+		}
+		a, ok := m.(px.Attribute)
+		if !ok {
+			continue
+		}
+		var v px.Value
+		if ln := strings.ToLower(name); ln == `id` || ln == idAttr {
+			v = types.WrapString(id)
+		} else {
+			v = fakeValue(a.Type(), fmt.Sprintf(`%s-%d`, name, n), n)
+		}
+		state = state.Merge(px.SingletonMap(name, v))
+	}
+	return state
+}
+
+// fakeValue returns a value of the given type. Strings are given the value s and numbers the value n.
+func fakeValue(t px.Type, s string, n int) px.Value {
+	if ot, ok := t.(*types.OptionalType); ok {
+		t = ot.ContainedType()
+	}
+	if et, ok := t.(*types.EnumType); ok && len(et.Parameters()) > 0 {
+		return et.Parameters()[0]
+	}
+	for _, v := range []px.Value{types.WrapString(s), types.WrapInteger(int64(n)), types.WrapFloat(float64(n)), types.BooleanFalse, px.EmptyArray, px.EmptyMap} {
+		if px.IsInstance(t, v) {
+			return v
+		}
+	}
+	return px.Undef
+}
+
+func simpleName(name string) string {
+	if i := strings.LastIndex(name, `::`); i >= 0 {
+		return name[i+2:]
+	}
+	return name
+}
+
+// load reads the count and the states from the file of the handler unless the file doesn't exist.
+func (h *memoryHandler) load(c px.Context) {
+	f, err := os.Open(h.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: h.file, `detail`: err.Error()}))
+	}
+	defer f.Close()
+
+	ds := serialization.NewDeserializer(c, px.EmptyMap)
+	serialization.JsonToData(h.file, f, ds)
+	data, ok := ds.Value().(px.OrderedMap)
+	if !ok {
+		panic(px.Error(InvalidMemoryHandlerFile, issue.H{`path`: h.file}))
+	}
+	if n, ok := data.Get5(`count`, px.Undef).(px.Integer); ok {
+		h.count = int(n.Int())
+	}
+	if sm, ok := data.Get5(`states`, px.EmptyMap).(px.OrderedMap); ok {
+		sm.EachPair(func(k, v px.Value) {
+			if state, ok := v.(px.OrderedMap); ok {
+				h.states[k.String()] = state
+			}
+		})
+	}
+}
+
+// save writes the count and the states to the file of the handler, if it has one. Sensitive values
+// are redacted. The file is replaced atomically so that it is never left half written.
+func (h *memoryHandler) save(c px.Context) {
+	if h.file == `` {
+		return
+	}
+	states := make(map[string]px.Value, len(h.states))
+	for id, state := range h.states {
+		states[id] = redactedValue(state)
+	}
+	data := types.WrapStringToValueMap(map[string]px.Value{
+		`count`:  types.WrapInteger(int64(h.count)),
+		`states`: types.WrapStringToValueMap(states)})
+
+	b := bytes.NewBufferString(``)
+	serialization.NewSerializer(c, px.EmptyMap).Convert(data, serialization.NewJsonStreamer(b))
+	if err := writeFileAtomic(h.file, b.Bytes()); err != nil {
+		panic(px.Error(MemoryHandlerWriteFailed, issue.H{`path`: h.file, `detail`: err.Error()}))
+	}
+}
+
+// writeFileAtomic writes the given content to a temporary file in the directory of the given path
+// and then renames the temporary file to the given path.
+func writeFileAtomic(path string, content []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+`.*`)
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(0644); err == nil {
+		_, err = f.Write(content)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
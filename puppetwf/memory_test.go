@@ -0,0 +1,125 @@
+package puppetwf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-evaluator/puppet"
+	"github.com/lyraproj/servicesdk/wf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryHandlerFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `memory`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, `states.json`)
+
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		c.AddDefinitions(c.ParseAndValidate(`types.pp`, `
+type Mem::Disk = Object[{
+  annotations => { Lyra::Resource => { providedAttributes => ['id'] } },
+  attributes => { size => Integer, id => Optional[String] }
+}]`, false))
+		st := c.ResolveDefinitions()[0].(px.ObjectType)
+		member := func(name string) px.ObjFunc {
+			m, _ := wf.CrudType.(px.ObjectType).Member(name)
+			return m.(px.ObjFunc)
+		}
+
+		h := NewMemoryHandler(c, st, file).(px.CallableObject)
+		created, _ := h.Call(c, member(`create`), []px.Value{px.New(c, st, px.SingletonMap(`size`, types.WrapInteger(10)))}, nil)
+		require.Equal(t, `[Mem::Disk('size' => 10, 'id' => 'disk-1'), 'disk-1']`, created.String())
+
+		// A new handler reads the states written by the first one
+		h = NewMemoryHandler(c, st, file).(px.CallableObject)
+		read, _ := h.Call(c, member(`read`), []px.Value{types.WrapString(`disk-1`)}, nil)
+		require.Equal(t, `Mem::Disk('size' => 10, 'id' => 'disk-1')`, read.String())
+
+		updated, _ := h.Call(c, member(`update`), []px.Value{types.WrapString(`disk-1`), px.New(c, st, px.SingletonMap(`size`, types.WrapInteger(20)))}, nil)
+		require.Equal(t, `Mem::Disk('size' => 20, 'id' => 'disk-1')`, updated.String())
+
+		created, _ = h.Call(c, member(`create`), []px.Value{px.New(c, st, px.SingletonMap(`size`, types.WrapInteger(5)))}, nil)
+		require.Equal(t, `disk-2`, created.(px.List).At(1).String())
+
+		h.Call(c, member(`delete`), []px.Value{types.WrapString(`disk-1`)}, nil)
+		require.Panics(t, func() { h.Call(c, member(`read`), []px.Value{types.WrapString(`disk-1`)}, nil) })
+	})
+}
+
+func TestMemoryHandlerFailedCreateAndSensitive(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `memory`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, `states.json`)
+
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		c.AddDefinitions(c.ParseAndValidate(`types.pp`, `
+type Mem::Account = Object[{
+  annotations => { Lyra::Resource => { providedAttributes => ['id'] } },
+  attributes => { password => String, id => Optional[String] }
+}]
+type Mem::Other = Object[{}]`, false))
+		var st, ot px.ObjectType
+		for _, d := range c.ResolveDefinitions() {
+			if d.(px.ObjectType).Name() == `Mem::Account` {
+				st = d.(px.ObjectType)
+			} else {
+				ot = d.(px.ObjectType)
+			}
+		}
+		create, _ := wf.CrudType.(px.ObjectType).Member(`create`)
+
+		h := NewMemoryHandler(c, st, file).(px.CallableObject)
+		require.Panics(t, func() { h.Call(c, create.(px.ObjFunc), []px.Value{px.New(c, ot, px.EmptyMap)}, nil) })
+
+		state := newResolvedState(c, st, px.SingletonMap(`password`, types.WrapSensitive(types.WrapString(`s3cr3t`))))
+		created, _ := h.Call(c, create.(px.ObjFunc), []px.Value{state}, nil)
+		require.Equal(t, `account-1`, created.(px.List).At(1).String())
+		pw, _ := created.(px.List).At(0).(px.PuppetObject).Get(`password`)
+		require.IsType(t, &types.Sensitive{}, pw)
+
+		content, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		require.NotContains(t, string(content), `s3cr3t`)
+		require.Contains(t, string(content), `[value redacted]`)
+
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+	})
+}
+
+func TestMemoryHandlerRecording(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `cassette`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cassette := filepath.Join(dir, `cassette.json`)
+
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		c.AddDefinitions(c.ParseAndValidate(`types.pp`, `
+type Mem::Secret = Object[{
+  annotations => { Lyra::Resource => { providedAttributes => ['id'] } },
+  attributes => { value => String, id => Optional[String] }
+}]`, false))
+		st := c.ResolveDefinitions()[0].(px.ObjectType)
+		create, _ := wf.CrudType.(px.ObjectType).Member(`create`)
+
+		WithRecording(cassette)(c)
+		h := NewMemoryHandler(c, st, ``).(px.CallableObject)
+		state := newResolvedState(c, st, px.SingletonMap(`value`, types.WrapSensitive(types.WrapString(`s3cr3t`))))
+		h.Call(c, create.(px.ObjFunc), []px.Value{state}, nil)
+		closeResources(c)
+	})
+
+	content, err := ioutil.ReadFile(cassette)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"handler":"Mem::SecretMemoryHandler","method":"create"`)
+	require.Contains(t, string(content), `[value redacted]`)
+	require.NotContains(t, string(content), `s3cr3t`)
+}
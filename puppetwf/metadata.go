@@ -0,0 +1,202 @@
+package puppetwf
+
+import (
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/semver/semver"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+const metadataType = `Struct[{
+  Optional[name] => Pattern[/\A[A-Z]\w*(?:::[A-Z]\w*)*\z/],
+  Optional[version] => String,
+  Optional[description] => String,
+  Optional[requires] => Hash[Pattern[/\A[A-Z]\w*(?:::[A-Z]\w*)*\z/], String]
+}]`
+
+// manifestMetadata is what a manifest declares about itself using a call at the top level:
+//
+//	metadata({
+//	  name => 'My::Service',
+//	  version => '1.2.0',
+//	  description => 'Creates a VPC with one subnet',
+//	  requires => { 'Aws' => '>=0.1.0 <1.0.0' }
+//	})
+//
+// All entries are optional. The name is the name of the service, and requires maps the names of
+// the TypeSets that the manifest uses to the range of versions that it accepts. The version, the
+// description, and the requirements are included in the metadata of the service as a definition
+// in the service namespace. They are also available from ManifestMetadata.
+type manifestMetadata struct {
+	name        string
+	version     semver.Version
+	description string
+	requires    []requirement
+	location    issue.Location
+}
+
+type requirement struct {
+	typeSet  string
+	versions semver.VersionRange
+}
+
+// parseMetadata returns the metadata declared among the top level statements of the given program
+// by a metadata() call, or the service name declared by a serviceName() call. It returns nil when
+// the program declares neither.
+func parseMetadata(c pdsl.EvaluationContext, ast parser.Expression) *manifestMetadata {
+	var md *manifestMetadata
+	named := false
+	for _, call := range topLevelCalls(ast, `metadata`, `serviceName`) {
+		name := call.Functor().(*parser.QualifiedName).Name()
+		args := call.Arguments()
+		if md == nil {
+			md = &manifestMetadata{location: call}
+		}
+		if name == `serviceName` {
+			if len(args) != 1 {
+				panic(px.Error2(call, InvalidServiceName, issue.NoArgs))
+			}
+			s, ok := args[0].(*parser.LiteralString)
+			if !ok || !validServiceName.MatchString(s.StringValue()) {
+				panic(px.Error2(call, InvalidServiceName, issue.NoArgs))
+			}
+			if named {
+				panic(px.Error2(call, ServiceNameDeclaredTwice, issue.NoArgs))
+			}
+			md.name = s.StringValue()
+			named = true
+			continue
+		}
+
+		var h px.OrderedMap
+		if len(args) == 1 {
+			if _, ok := args[0].(*parser.LiteralHash); ok {
+				h, _ = pdsl.Evaluate(c, args[0]).(px.OrderedMap)
+			}
+		}
+		if h == nil {
+			panic(px.Error2(call, InvalidMetadata, issue.H{`detail`: `metadata() must be called with one literal Hash`}))
+		}
+		if t := c.ParseType(metadataType); !px.IsInstance(t, h) {
+			panic(px.Error2(call, InvalidMetadata, issue.H{`detail`: strings.TrimSpace(px.DescribeMismatch(`metadata`, t, px.DetailedValueType(h)))}))
+		}
+		md.location = call
+		if v, ok := h.Get4(`name`); ok {
+			if named {
+				panic(px.Error2(call, ServiceNameDeclaredTwice, issue.NoArgs))
+			}
+			md.name = v.String()
+			named = true
+		}
+		if v, ok := h.Get4(`version`); ok {
+			version, err := semver.ParseVersion(v.String())
+			if err != nil {
+				panic(px.Error2(call, InvalidMetadata, issue.H{`detail`: err.Error()}))
+			}
+			md.version = version
+		}
+		if v, ok := h.Get4(`description`); ok {
+			md.description = v.String()
+		}
+		if v, ok := h.Get4(`requires`); ok {
+			v.(px.OrderedMap).EachPair(func(k, v px.Value) {
+				versions, err := semver.ParseVersionRange(v.String())
+				if err != nil {
+					panic(px.Error2(call, InvalidMetadata, issue.H{`detail`: err.Error()}))
+				}
+				md.requires = append(md.requires, requirement{k.String(), versions})
+			})
+		}
+	}
+	return md
+}
+
+// topLevelCalls returns the calls to functions with the given names among the top level
+// statements of the given program.
+func topLevelCalls(ast parser.Expression, names ...string) []*parser.CallNamedFunctionExpression {
+	if p, ok := ast.(*parser.Program); ok {
+		ast = p.Body()
+	}
+	var stmts []parser.Expression
+	if b, ok := ast.(*parser.BlockExpression); ok {
+		stmts = b.Statements()
+	} else {
+		stmts = []parser.Expression{ast}
+	}
+	var calls []*parser.CallNamedFunctionExpression
+	for _, stmt := range stmts {
+		call, ok := stmt.(*parser.CallNamedFunctionExpression)
+		if !ok {
+			continue
+		}
+		if qn, ok := call.Functor().(*parser.QualifiedName); ok {
+			for _, name := range names {
+				if qn.Name() == name {
+					calls = append(calls, call)
+				}
+			}
+		}
+	}
+	return calls
+}
+
+// validate asserts that each TypeSet that the metadata requires can be loaded and that its
+// version is within the required range.
+func (md *manifestMetadata) validate(c px.Context) {
+	for _, r := range md.requires {
+		t, ok := px.Load(c, px.NewTypedName(px.NsType, r.typeSet))
+		if !ok {
+			panic(px.Error2(md.location, RequiredTypeSetNotFound, issue.H{`name`: r.typeSet}))
+		}
+		ts, ok := t.(px.TypeSet)
+		if !ok {
+			panic(px.Error2(md.location, RequiredTypeSetNotFound, issue.H{`name`: r.typeSet}))
+		}
+		if !r.versions.Includes(ts.Version()) {
+			panic(px.Error2(md.location, RequiredTypeSetVersionMismatch, issue.H{
+				`name`: r.typeSet, `version`: ts.Version().String(), `range`: r.versions.String()}))
+		}
+	}
+}
+
+// value returns the entries of the metadata as a Hash.
+func (md *manifestMetadata) value() px.OrderedMap {
+	return types.WrapHash(md.entries())
+}
+
+// entries returns the version, the description, and the required TypeSets of the metadata.
+// Entries that the manifest doesn't declare are omitted.
+func (md *manifestMetadata) entries() []*types.HashEntry {
+	var entries []*types.HashEntry
+	if md.version != nil {
+		entries = append(entries, types.WrapHashEntry2(`version`, types.WrapSemVer(md.version)))
+	}
+	if md.description != `` {
+		entries = append(entries, types.WrapHashEntry2(`description`, types.WrapString(md.description)))
+	}
+	if len(md.requires) > 0 {
+		rs := make([]*types.HashEntry, len(md.requires))
+		for i, r := range md.requires {
+			rs[i] = types.WrapHashEntry2(r.typeSet, types.WrapSemVerRange(r.versions))
+		}
+		entries = append(entries, types.WrapHashEntry2(`requires`, types.WrapHash(rs)))
+	}
+	return entries
+}
+
+// definition returns the definition of the service with the given identifier. Its properties are
+// the style service and the entries of the metadata.
+func (md *manifestMetadata) definition(serviceId px.TypedName) serviceapi.Definition {
+	props := append([]*types.HashEntry{types.WrapHashEntry2(`style`, types.WrapString(`service`))}, md.entries()...)
+	return serviceapi.NewDefinition(px.NewTypedName(px.NsService, serviceId.Name()), serviceId, types.WrapHash(props))
+}
+
+// declared returns true if the metadata declares a version, a description, or requirements.
+func (md *manifestMetadata) declared() bool {
+	return md != nil && (md.version != nil || md.description != `` || len(md.requires) > 0)
+}
@@ -0,0 +1,313 @@
+package puppetwf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/loader"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+)
+
+// ModulePathKey is the context key for the directories where manifests find Puppet modules.
+const ModulePathKey = `Puppet::ModulePath`
+
+// VendorDir is the name of the directory, next to a manifest, that contains vendored modules.
+const VendorDir = `vendor`
+
+// WorkflowPath is the path type of the workflows directory of a module. Each file in the directory
+// declares the workflow that it is named after.
+const WorkflowPath = px.PathType(`workflow`)
+
+var modulePathTypes = []px.PathType{px.PuppetDataTypePath, px.PuppetFunctionPath, WorkflowPath}
+
+func init() {
+	loader.SmartPathFactories[WorkflowPath] = func(ml px.ModuleLoader, moduleNameRelative bool) loader.SmartPath {
+		return loader.NewSmartPath(`workflows`, `.pp`, ml, []px.Namespace{px.NsStep}, moduleNameRelative, false, instantiateWorkflow)
+	}
+}
+
+func instantiateWorkflow(c px.Context, l loader.ContentProvidingLoader, tn px.TypedName, sources []string) {
+	ec := c.(pdsl.EvaluationContext)
+	ast := ec.ParseAndValidate(sources[0], string(l.GetContent(c, sources[0])), false)
+	ec.AddDefinitions(ast)
+	ec.ResolveDefinitions()
+}
+
+// WithModulePath makes the types, functions, and workflows of the modules in the given directories
+// available to all manifests. Each subdirectory that has a valid module name is a module, and the
+// names that it declares start with the module name. A type Disks::Disk in the module disks is
+// declared in types/disk.pp, a TypeSet Disks in types/init_typeset.pp, and a function
+// disks::size in functions/size.pp. When two directories contain a module with the same name, the
+// first one is used.
+//
+// A directory that has a types, functions, or workflows directory is itself a module. Such a
+// module can declare any name, so a type Aws::Vpc is declared in types/aws/vpc.pp, or by a TypeSet
+// Aws in types/aws.pp. The directory of a manifest is always such a module.
+func WithModulePath(dirs ...string) Option {
+	return func(c px.Context) {
+		c.Set(ModulePathKey, dirs)
+	}
+}
+
+// ModulePath splits a list of directories separated by the OS specific path list separator, as
+// given in the environment variable LYRA_MODULE_PATH.
+func ModulePath(list string) []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(list) {
+		if dir != `` {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// modulesLoader finds what its parent cannot find in a set of modules. It reports an error when
+// more than one module has a definition for the requested name.
+type modulesLoader struct {
+	parent  px.Loader
+	modules []px.ModuleLoader
+}
+
+// moduleScope is the loader in effect while a module loads a definition. It finds names the same
+// way as the modulesLoader that it belongs to, but definitions end up in the module. The names that
+// are being loaded are not loaded again, since a TypeSet looks up its own types while it is
+// resolved.
+type moduleScope struct {
+	*modulesLoader
+	module  px.ModuleLoader
+	loading map[string]bool
+}
+
+// modulesFor returns a loader for the manifests in the given directory. The loader finds
+// definitions in the directory itself, then in the modules of its vendor directory, and then in
+// the modules of the module path. A vendored module hides a module with the same name in the
+// module path. The given loader is returned when there are no modules.
+func (m *manifestLoader) modulesFor(parent px.Loader, moduleDir string) px.Loader {
+	var modules []px.ModuleLoader
+	var dirs []string
+	if moduleDir != `` {
+		if path := absPath(moduleDir); isModule(path) {
+			modules = append(modules, m.moduleLoader(path, ``))
+		}
+		dirs = append(dirs, filepath.Join(moduleDir, VendorDir))
+	}
+	if v, ok := m.ctx.Get(ModulePathKey); ok {
+		dirs = append(dirs, v.([]string)...)
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		for _, md := range moduleDirs(dir) {
+			key := md.name
+			if key == `` {
+				key = md.path
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			modules = append(modules, m.moduleLoader(md.path, md.name))
+		}
+	}
+	if len(modules) == 0 {
+		return parent
+	}
+	return &modulesLoader{parent: parent, modules: modules}
+}
+
+// moduleLoader returns the loader for the module in the given directory. Loaders are shared by all
+// manifests so that a type is the same type in all manifests that use it.
+func (m *manifestLoader) moduleLoader(path, name string) px.ModuleLoader {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if ml, ok := m.modules[path]; ok {
+		return ml
+	}
+	if m.modules == nil {
+		m.modules = make(map[string]px.ModuleLoader)
+	}
+	ml := px.NewFileBasedLoader(m.ctx.Loader(), path, name, modulePathTypes...)
+	m.modules[path] = ml
+	return ml
+}
+
+type moduleDir struct {
+	name string
+	path string
+}
+
+// moduleDirs returns the modules in the given directory. The name of a module is empty when the
+// directory is itself a module.
+func moduleDirs(dir string) []moduleDir {
+	dir = absPath(dir)
+	if isModule(dir) {
+		return []moduleDir{{``, dir}}
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var mds []moduleDir
+	for _, fi := range fis {
+		if fi.IsDir() && px.IsValidModuleName(fi.Name()) {
+			path := filepath.Join(dir, fi.Name())
+			if isModule(path) {
+				mds = append(mds, moduleDir{fi.Name(), path})
+			}
+		}
+	}
+	return mds
+}
+
+func isModule(dir string) bool {
+	for _, sub := range []string{`types`, `functions`, `workflows`} {
+		if fi, err := os.Stat(filepath.Join(dir, sub)); err == nil && fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *modulesLoader) LoadEntry(c px.Context, name px.TypedName) px.LoaderEntry {
+	return l.loadEntry(c, name, nil)
+}
+
+func (l *modulesLoader) loadEntry(c px.Context, name px.TypedName, loading map[string]bool) px.LoaderEntry {
+	pe := l.parent.LoadEntry(c, name)
+	if pe != nil && pe.Value() != nil {
+		return pe
+	}
+
+	var found []px.ModuleLoader
+	for _, m := range l.modules {
+		if hasEntry(m, name) {
+			found = append(found, m)
+		}
+	}
+	if len(found) > 1 {
+		paths := make([]string, len(found))
+		for i, m := range found {
+			paths[i] = m.Path()
+		}
+		panic(px.Error(AmbiguousDefinition, issue.H{`name`: name.Name(), `namespace`: name.Namespace(), `modules`: strings.Join(paths, `, `)}))
+	}
+	if len(found) == 0 && (name.Namespace() == px.NsConstructor || name.Namespace() == px.NsAllocator) {
+		// Entries such as constructors have no files. They are registered in the loader of the
+		// module when the type that they belong to is loaded.
+		found = l.modules
+	}
+	for _, m := range found {
+		scope := &moduleScope{l, m, make(map[string]bool, len(loading)+len(name.Parts()))}
+		for k := range loading {
+			scope.loading[k] = true
+		}
+		eachPrefix(name, func(n px.TypedName) bool {
+			scope.loading[n.MapKey()] = true
+			return false
+		})
+		var e px.LoaderEntry
+		c.DoWithLoader(scope, func() { e = m.LoadEntry(c, name) })
+		if e != nil && e.Value() != nil {
+			return e
+		}
+	}
+	return pe
+}
+
+// hasEntry returns true if the given module has a file for the given name. A type can also be
+// declared by a TypeSet that is named after a part of its name, so the parts are tried too. The
+// TypeSet, or function, that is named after the module itself is found in its init_typeset.pp, or
+// init.pp, file.
+func hasEntry(l px.ModuleLoader, name px.TypedName) bool {
+	if eachPrefix(name, l.HasEntry) {
+		return true
+	}
+	if mn := l.ModuleName(); mn != `` && strings.EqualFold(name.Parts()[0], mn) {
+		init := `init`
+		if name.Namespace() == px.NsType {
+			init = `init_typeset`
+		}
+		return l.HasEntry(px.NewTypedName2(name.Namespace(), init, l.NameAuthority()))
+	}
+	return false
+}
+
+// eachPrefix calls f with the given name and, when it is the name of a type, with the names of the
+// TypeSets that can declare it. It returns true as soon as f returns true.
+func eachPrefix(name px.TypedName, f func(n px.TypedName) bool) bool {
+	for n := name; n != nil; n = n.Parent() {
+		if f(n) {
+			return true
+		}
+		if name.Namespace() != px.NsType {
+			break
+		}
+	}
+	return false
+}
+
+func (l *modulesLoader) NameAuthority() px.URI {
+	return l.parent.NameAuthority()
+}
+
+func (l *modulesLoader) Discover(c px.Context, predicate func(tn px.TypedName) bool) []px.TypedName {
+	found := l.parent.Discover(c, predicate)
+	seen := make(map[string]bool, len(found))
+	for _, tn := range found {
+		seen[tn.MapKey()] = true
+	}
+	for _, m := range l.modules {
+		for _, tn := range m.Discover(c, predicate) {
+			if !seen[tn.MapKey()] {
+				seen[tn.MapKey()] = true
+				found = append(found, tn)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].MapKey() < found[j].MapKey() })
+	return found
+}
+
+func (l *modulesLoader) HasEntry(name px.TypedName) bool {
+	if l.parent.HasEntry(name) {
+		return true
+	}
+	for _, m := range l.modules {
+		if m.HasEntry(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *modulesLoader) Parent() px.Loader {
+	return l.parent
+}
+
+// SetEntry sets the entry in the nearest defining loader among the parents so that definitions
+// made by manifests end up where they would without modules.
+func (l *modulesLoader) SetEntry(name px.TypedName, entry px.LoaderEntry) px.LoaderEntry {
+	p := l.parent
+	for {
+		if dl, ok := p.(px.DefiningLoader); ok {
+			return dl.SetEntry(name, entry)
+		}
+		p = p.(px.ParentedLoader).Parent()
+	}
+}
+
+func (s *moduleScope) SetEntry(name px.TypedName, entry px.LoaderEntry) px.LoaderEntry {
+	return s.module.(px.DefiningLoader).SetEntry(name, entry)
+}
+
+func (s *moduleScope) LoadEntry(c px.Context, name px.TypedName) px.LoaderEntry {
+	if eachPrefix(name, func(n px.TypedName) bool { return s.loading[n.MapKey()] }) {
+		return s.parent.LoadEntry(c, name)
+	}
+	return s.loadEntry(c, name, s.loading)
+}
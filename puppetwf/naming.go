@@ -0,0 +1,131 @@
+package puppetwf
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+)
+
+var validServiceName = regexp.MustCompile(`\A[A-Z]\w*(?:::[A-Z]\w*)*\z`)
+
+// serviceName returns the name of the service that represents the manifest with the given path
+// and metadata. A manifest can declare its name explicitly with a call at the top level:
+//
+//	serviceName('My::Service')
+//
+// or with the name entry of its metadata() call. Otherwise, the name is derived from the path of
+// the manifest relative to the module directory without the .pp extension, so that
+// workflows/aws/vpc.pp becomes Workflows::Aws::Vpc. The derivation only changes the case of the
+// first letter of each segment. A path that can't be derived without losing characters, such as
+// a.b/c.pp or 0.1/c.pp, must declare its name explicitly. Two paths that differ only in case will
+// still produce the same name and the loader will then report a collision.
+func serviceName(md *manifestMetadata, moduleDir, fileName string) string {
+	if md != nil && md.name != `` {
+		return md.name
+	}
+	path := fileName
+	if rel, err := filepath.Rel(absPath(moduleDir), absPath(fileName)); err == nil && !strings.HasPrefix(rel, `..`) {
+		path = rel
+	}
+	segments := strings.Split(strings.Trim(filepath.ToSlash(strings.TrimSuffix(path, `.pp`)), `/`), `/`)
+	for i, s := range segments {
+		if !validSegment.MatchString(s) {
+			panic(px.Error(UnderivableServiceName, issue.H{`path`: fileName}))
+		}
+		segments[i] = strings.ToUpper(s[:1]) + s[1:]
+	}
+	return strings.Join(segments, `::`)
+}
+
+var validSegment = regexp.MustCompile(`\A[A-Za-z]\w*\z`)
+
+// checkServiceName panics if the given name, compared without regard to case, is already used by
+// a manifest other than the one with the given path.
+func (m *manifestLoader) checkServiceName(name, path string) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	m.assertUnused(name, absPath(path))
+}
+
+// registerServiceName records that the service with the given name represents the manifest with
+// the given path and metadata. It must be called once the manifest has been loaded successfully so that a
+// manifest that fails to load doesn't claim the name. It panics if the name, compared without
+// regard to case, is already used by another manifest.
+func (m *manifestLoader) registerServiceName(name, path string, md *manifestMetadata) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.paths == nil {
+		m.paths = make(map[string]string)
+		m.metadata = make(map[string]*manifestMetadata)
+	}
+	path = absPath(path)
+	m.assertUnused(name, path)
+	key := strings.ToLower(name)
+	m.paths[key] = path
+	if md != nil {
+		m.metadata[key] = md
+	} else {
+		delete(m.metadata, key)
+	}
+}
+
+// assertUnused must be called with the lock held.
+func (m *manifestLoader) assertUnused(name, path string) {
+	if other, ok := m.paths[strings.ToLower(name)]; ok && other != path {
+		panic(px.Error(ServiceNameCollision, issue.H{`name`: name, `path`: path, `other`: other}))
+	}
+}
+
+// ManifestPath returns the absolute path of the manifest that is represented by the service with
+// the given name, or an empty string if no such manifest has been loaded.
+func (m *manifestLoader) ManifestPath(name string) string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.paths[strings.ToLower(name)]
+}
+
+// ManifestPath returns the absolute path of the manifest that is represented by the service with
+// the given name, provided that the manifest was loaded by the service of the given context.
+func ManifestPath(c px.Context, name string) (string, bool) {
+	if v, ok := c.Get(ManifestLoaderID); ok {
+		if path := v.(*manifestLoader).ManifestPath(name); path != `` {
+			return path, true
+		}
+	}
+	return ``, false
+}
+
+// ManifestMetadata returns the version, the description, and the required TypeSets that the
+// manifest represented by the service with the given name declares with its metadata() call. The
+// returned Hash is empty when the manifest declares none of them or when no such manifest has been
+// loaded.
+func (m *manifestLoader) ManifestMetadata(name string) px.OrderedMap {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if md, ok := m.metadata[strings.ToLower(name)]; ok {
+		return md.value()
+	}
+	return px.EmptyMap
+}
+
+// ManifestMetadata is like ManifestPath but returns the metadata of the manifest as returned by the
+// ManifestMetadata method of the loader.
+func ManifestMetadata(c px.Context, name string) (px.OrderedMap, bool) {
+	if v, ok := c.Get(ManifestLoaderID); ok {
+		ml := v.(*manifestLoader)
+		if ml.ManifestPath(name) != `` {
+			return ml.ManifestMetadata(name), true
+		}
+	}
+	return nil, false
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
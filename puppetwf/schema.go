@@ -0,0 +1,381 @@
+package puppetwf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/pcore/yaml"
+	"github.com/lyraproj/semver/semver"
+	"github.com/lyraproj/servicesdk/annotation"
+)
+
+// LoadSchema reads a JSON Schema or OpenAPI 3 document from a JSON or YAML file.
+func LoadSchema(c px.Context, path string) px.OrderedMap {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	if doc, ok := yaml.Unmarshal(c, content).(px.OrderedMap); ok {
+		return doc
+	}
+	panic(px.Error(InvalidSchema, issue.H{`pointer`: path, `detail`: `the document must be an object`}))
+}
+
+// TypeSetFromSchema returns a TypeSet with the given name and version that contains an object type
+// for each named schema in the given JSON Schema or OpenAPI 3 document. The named schemas of a JSON
+// Schema are found in its definitions, or $defs, and the root schema is named after its title. The
+// named schemas of an OpenAPI document are found in its components.
+//
+// A property that is not required, or that is readOnly, has an Optional type and the default value
+// undef. A readOnly property is also listed as a provided attribute in the Lyra::Resource
+// annotation of its type. A nested object schema becomes an anonymous Object type and a $ref
+// becomes a reference to the type of the named schema.
+//
+// A named schema that is not referenced by other schemas is a resource. Each resource type has a
+// handler type, named after it with the suffix Handler, that declares the functions that a handler
+// of the resource implements.
+func TypeSetFromSchema(c px.Context, name string, version semver.Version, doc px.OrderedMap) px.TypeSet {
+	sc := &schemaConverter{doc: doc, names: make(map[string]string), referenced: make(map[string]bool)}
+	sc.collect()
+	if len(sc.refs) == 0 {
+		panic(px.Error(InvalidSchema, issue.H{`pointer`: `#`, `detail`: `the document has no named schemas`}))
+	}
+
+	ts := make([]*types.HashEntry, 0, len(sc.refs)*2)
+	for _, ref := range sc.refs {
+		ts = append(ts, types.WrapHashEntry2(sc.names[ref], sc.objectHash(sc.schemas[ref], ref, true)))
+	}
+	for _, ref := range sc.refs {
+		if !sc.referenced[ref] {
+			tn := sc.names[ref]
+			ts = append(ts, types.WrapHashEntry2(tn+`Handler`, handlerHash(tn)))
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Key().String() < ts[j].Key().String() })
+
+	if version == nil {
+		version = semver.MustParseVersion(`0.1.0`)
+	}
+	t := types.NewTypeSet(px.RuntimeNameAuthority, name, types.WrapHash([]*types.HashEntry{
+		types.WrapHashEntry2(px.KeyPcoreUri, types.WrapString(string(px.PcoreUri))),
+		types.WrapHashEntry2(px.KeyPcoreVersion, types.WrapSemVer(px.PcoreVersion)),
+		types.WrapHashEntry2(types.KeyVersion, types.WrapSemVer(version)),
+		types.WrapHashEntry2(types.KeyTypes, types.WrapHash(ts))}))
+	t.(px.ResolvableType).Resolve(c)
+	return t
+}
+
+type schemaConverter struct {
+	doc        px.OrderedMap
+	refs       []string
+	schemas    map[string]px.OrderedMap
+	names      map[string]string
+	referenced map[string]bool
+}
+
+// schemaTypeName returns the name of the type for the schema with the given name. Characters that
+// can't be part of a type name are dropped and the character that follows them is capitalized,
+// so network-interface becomes NetworkInterface. An X is prepended to a name that would otherwise
+// start with a digit or an underscore.
+func schemaTypeName(name string) string {
+	b := bytes.NewBufferString(``)
+	upper := true
+	for _, c := range name {
+		if c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			if b.Len() == 0 && (c == '_' || c >= '0' && c <= '9') {
+				b.WriteRune('X')
+			}
+			if upper {
+				c = unicode.ToUpper(c)
+			}
+			b.WriteRune(c)
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+// collect finds the named schemas of the document and the names of their types.
+func (sc *schemaConverter) collect() {
+	sc.schemas = make(map[string]px.OrderedMap)
+	add := func(ref, name string, v px.Value) {
+		s, ok := v.(px.OrderedMap)
+		if !ok {
+			panic(px.Error(InvalidSchema, issue.H{`pointer`: ref, `detail`: `a schema must be an object`}))
+		}
+		sc.refs = append(sc.refs, ref)
+		sc.schemas[ref] = s
+		sc.names[ref] = schemaTypeName(name)
+	}
+	addAll := func(prefix string, v px.Value) {
+		if defs, ok := v.(px.OrderedMap); ok {
+			defs.EachPair(func(k, v px.Value) { add(prefix+k.String(), k.String(), v) })
+		}
+	}
+
+	if _, ok := sc.doc.Get4(`openapi`); ok {
+		if cs, ok := sc.doc.Get4(`components`); ok {
+			if cm, ok := cs.(px.OrderedMap); ok {
+				addAll(`#/components/schemas/`, cm.Get5(`schemas`, px.Undef))
+			}
+		}
+	} else {
+		if title, ok := sc.doc.Get4(`title`); ok && sc.isObject(sc.doc) {
+			add(`#`, title.String(), sc.doc)
+		}
+		addAll(`#/definitions/`, sc.doc.Get5(`definitions`, px.Undef))
+		addAll(`#/$defs/`, sc.doc.Get5(`$defs`, px.Undef))
+	}
+}
+
+func (sc *schemaConverter) isObject(s px.OrderedMap) bool {
+	if t, ok := s.Get4(`type`); ok {
+		return t.String() == `object`
+	}
+	_, ok := s.Get4(`properties`)
+	return ok
+}
+
+// objectHash returns the initialization hash of the object type for the given object schema.
+func (sc *schemaConverter) objectHash(s px.OrderedMap, pointer string, named bool) px.OrderedMap {
+	required := make(map[string]bool)
+	var provided []px.Value
+	attrs := make([]*types.HashEntry, 0)
+	sc.properties(s, pointer, required).EachPair(func(k, v px.Value) {
+		ps, ok := v.(px.OrderedMap)
+		if !ok {
+			panic(px.Error(InvalidSchema, issue.H{`pointer`: pointer + `/properties/` + k.String(), `detail`: `a schema must be an object`}))
+		}
+		an := memberName(k.String())
+		at := sc.typeOf(ps, pointer+`/properties/`+k.String())
+		readOnly := ps.Get5(`readOnly`, types.BooleanFalse).Equals(types.BooleanTrue, nil)
+		if readOnly {
+			provided = append(provided, types.WrapString(an))
+		}
+		if required[k.String()] && !readOnly {
+			attrs = append(attrs, types.WrapHashEntry2(an, at))
+		} else {
+			attrs = append(attrs, types.WrapHashEntry2(an, types.WrapHash([]*types.HashEntry{
+				types.WrapHashEntry2(`type`, optional(at)),
+				types.WrapHashEntry2(`value`, px.Undef)})))
+		}
+	})
+
+	es := make([]*types.HashEntry, 0, 2)
+	if named && len(provided) > 0 {
+		es = append(es, types.WrapHashEntry2(`annotations`, types.WrapHash([]*types.HashEntry{
+			types.WrapHashEntry(annotation.ResourceType, px.SingletonMap(`providedAttributes`, types.WrapValues(provided)))})))
+	}
+	es = append(es, types.WrapHashEntry2(`attributes`, types.WrapHash(attrs)))
+	return types.WrapHash(es)
+}
+
+// properties returns the properties of the given object schema, including those of the schemas
+// that it combines using allOf, and adds the names of the required properties to required.
+func (sc *schemaConverter) properties(s px.OrderedMap, pointer string, required map[string]bool) px.OrderedMap {
+	props := px.EmptyMap
+	if all, ok := s.Get4(`allOf`); ok {
+		if al, ok := all.(px.List); ok {
+			al.EachWithIndex(func(v px.Value, i int) {
+				if as, ok := v.(px.OrderedMap); ok {
+					props = props.Merge(sc.properties(sc.deref(as), pointer+`/allOf/`+strconv.Itoa(i), required))
+				}
+			})
+		}
+	}
+	if rv, ok := s.Get4(`required`); ok {
+		if rl, ok := rv.(px.List); ok {
+			rl.Each(func(v px.Value) { required[v.String()] = true })
+		}
+	}
+	if pv, ok := s.Get4(`properties`); ok {
+		if pm, ok := pv.(px.OrderedMap); ok {
+			props = props.Merge(pm)
+		}
+	}
+	return props
+}
+
+// deref returns the named schema that the given schema refers to, or the given schema when it is
+// not a reference.
+func (sc *schemaConverter) deref(s px.OrderedMap) px.OrderedMap {
+	if ref, ok := s.Get4(`$ref`); ok {
+		if rs, ok := sc.schemas[ref.String()]; ok {
+			return rs
+		}
+		panic(px.Error(UnresolvedSchemaRef, issue.H{`ref`: ref.String()}))
+	}
+	return s
+}
+
+// typeOf returns the type of the given schema. Since it may refer to other types of the TypeSet,
+// the type is resolved when the TypeSet is.
+func (sc *schemaConverter) typeOf(s px.OrderedMap, pointer string) px.Value {
+	if ref, ok := s.Get4(`$ref`); ok {
+		tn, ok := sc.names[ref.String()]
+		if !ok {
+			panic(px.Error(UnresolvedSchemaRef, issue.H{`ref`: ref.String()}))
+		}
+		sc.referenced[ref.String()] = true
+		return types.NewDeferredType(tn)
+	}
+
+	for _, key := range []string{`oneOf`, `anyOf`} {
+		if v, ok := s.Get4(key); ok {
+			if vl, ok := v.(px.List); ok {
+				vs := make([]px.Value, 0, vl.Len())
+				vl.EachWithIndex(func(e px.Value, i int) {
+					if es, ok := e.(px.OrderedMap); ok {
+						vs = append(vs, sc.typeOf(es, pointer+`/`+key+`/`+strconv.Itoa(i)))
+					}
+				})
+				return nullable(s, types.NewDeferredType(`Variant`, vs...))
+			}
+		}
+	}
+
+	if all, ok := s.Get4(`allOf`); ok {
+		if al, ok := all.(px.List); ok && al.Len() == 1 {
+			if as, ok := al.At(0).(px.OrderedMap); ok {
+				return nullable(s, sc.typeOf(as, pointer+`/allOf/0`))
+			}
+		}
+		return nullable(s, types.NewDeferredType(`Object`, sc.objectHash(s, pointer, false)))
+	}
+
+	if ev, ok := s.Get4(`enum`); ok {
+		if el, ok := ev.(px.List); ok && el.All(func(e px.Value) bool { _, ok := e.(px.StringValue); return ok }) {
+			return nullable(s, types.NewDeferredType(`Enum`, el.AppendTo(make([]px.Value, 0, el.Len()))...))
+		}
+	}
+
+	tn := ``
+	null := false
+	switch t := s.Get5(`type`, px.Undef).(type) {
+	case px.StringValue:
+		tn = t.String()
+	case px.List:
+		t.Each(func(e px.Value) {
+			if e.String() == `null` {
+				null = true
+			} else if tn == `` {
+				tn = e.String()
+			} else {
+				panic(px.Error(InvalidSchema, issue.H{`pointer`: pointer, `detail`: `only one type besides null is supported`}))
+			}
+		})
+	}
+
+	var t px.Value
+	switch tn {
+	case `string`:
+		t = types.NewDeferredType(`String`)
+	case `integer`:
+		min, hasMin := s.Get4(`minimum`)
+		max, hasMax := s.Get4(`maximum`)
+		if hasMin || hasMax {
+			if !hasMin {
+				min = types.WrapDefault()
+			}
+			if !hasMax {
+				max = types.WrapDefault()
+			}
+			t = types.NewDeferredType(`Integer`, min, max)
+		} else {
+			t = types.NewDeferredType(`Integer`)
+		}
+	case `number`:
+		t = types.NewDeferredType(`Float`)
+	case `boolean`:
+		t = types.NewDeferredType(`Boolean`)
+	case `null`:
+		t = types.NewDeferredType(`Undef`)
+	case `array`:
+		if iv, ok := s.Get4(`items`); ok {
+			if is, ok := iv.(px.OrderedMap); ok {
+				t = types.NewDeferredType(`Array`, sc.typeOf(is, pointer+`/items`))
+				break
+			}
+		}
+		t = types.NewDeferredType(`Array`)
+	case `object`, ``:
+		if _, ok := s.Get4(`properties`); ok {
+			t = types.NewDeferredType(`Object`, sc.objectHash(s, pointer, false))
+		} else if ap, ok := s.Get4(`additionalProperties`); ok && tn == `object` {
+			if as, ok := ap.(px.OrderedMap); ok {
+				t = types.NewDeferredType(`Hash`, types.NewDeferredType(`String`), sc.typeOf(as, pointer+`/additionalProperties`))
+			} else {
+				t = types.NewDeferredType(`Hash`, types.NewDeferredType(`String`), types.NewDeferredType(`Any`))
+			}
+		} else if tn == `object` {
+			t = types.NewDeferredType(`Hash`, types.NewDeferredType(`String`), types.NewDeferredType(`Any`))
+		} else {
+			t = types.NewDeferredType(`Any`)
+		}
+	default:
+		panic(px.Error(InvalidSchema, issue.H{`pointer`: pointer, `detail`: `unknown type '` + tn + `'`}))
+	}
+	if null {
+		return optional(t)
+	}
+	return nullable(s, t)
+}
+
+// nullable returns an Optional type when the given OpenAPI schema is nullable.
+func nullable(s px.OrderedMap, t px.Value) px.Value {
+	if s.Get5(`nullable`, types.BooleanFalse).Equals(types.BooleanTrue, nil) {
+		return optional(t)
+	}
+	return t
+}
+
+func optional(t px.Value) px.Value {
+	if dt, ok := t.(*types.DeferredType); ok && dt.Name() == `Optional` {
+		return t
+	}
+	return types.NewDeferredType(`Optional`, t)
+}
+
+// handlerHash returns the initialization hash of the handler type of the given resource type.
+func handlerHash(tn string) px.OrderedMap {
+	ot := func() px.Value { return types.NewDeferredType(`Optional`, types.NewDeferredType(tn)) }
+	str := func() px.Value { return types.NewDeferredType(`String`) }
+	return px.SingletonMap(`functions`, types.WrapHash([]*types.HashEntry{
+		types.WrapHashEntry2(`create`, types.NewDeferredType(`Callable`, types.WrapValues([]px.Value{ot()}), types.NewDeferredType(`Tuple`, ot(), str()))),
+		types.WrapHashEntry2(`delete`, types.NewDeferredType(`Callable`, str())),
+		types.WrapHashEntry2(`read`, types.NewDeferredType(`Callable`, types.WrapValues([]px.Value{str()}), ot()))}))
+}
+
+// memberName returns the given property name as a valid attribute name. Characters that cannot
+// be used in a name are dropped and the character that follows them is made upper case.
+func memberName(name string) string {
+	b := strings.Builder{}
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			if b.Len() == 0 {
+				if c >= '0' && c <= '9' {
+					b.WriteRune('_')
+				}
+				c = unicode.ToLower(c)
+			} else if upper {
+				c = unicode.ToUpper(c)
+			}
+			b.WriteRune(c)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	return b.String()
+}
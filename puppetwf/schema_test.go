@@ -0,0 +1,150 @@
+package puppetwf_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/yaml"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+	"github.com/lyraproj/semver/semver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeSetFromSchema(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.TypeSetFromSchema(c, `Network`, nil, puppetwf.LoadSchema(c, `testdata/schema/network.yaml`))
+		b := bytes.NewBufferString(``)
+		ts.ToString(b, px.PrettyExpanded, nil)
+		require.Equal(t, `TypeSet[{
+  pcore_uri => 'http://puppet.com/2016.1/pcore',
+  pcore_version => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name => 'Network',
+  version => '0.1.0',
+  types => {
+    Route => {
+      attributes => {
+        'destination' => Optional[String]
+      }
+    },
+    Subnet => {
+      annotations => {
+        Lyra::Resource => {
+          'providedAttributes' => ['subnetId']
+        }
+      },
+      attributes => {
+        'vpcId' => String,
+        'cidrBlock' => String,
+        'placement' => Object[{
+          attributes => {
+            'zone' => String,
+            'tenancy' => Optional[String]
+          }
+        }],
+        'routes' => Optional[Array[Route]],
+        'ipCount' => Optional[Integer[1]],
+        'subnetId' => Optional[String]
+      }
+    },
+    SubnetHandler => {
+      functions => {
+        'create' => Callable[
+          [Optional[Subnet]],
+          Tuple[Optional[Subnet], String]],
+        'delete' => Callable[String],
+        'read' => Callable[
+          [String],
+          Optional[Subnet]]
+      }
+    },
+    Vpc => {
+      annotations => {
+        Lyra::Resource => {
+          'providedAttributes' => ['vpcId']
+        }
+      },
+      attributes => {
+        'cidrBlock' => String,
+        'instanceTenancy' => Optional[Enum['default', 'dedicated']],
+        'tags' => Optional[Hash[String, String]],
+        'vpcId' => Optional[String]
+      }
+    },
+    VpcHandler => {
+      functions => {
+        'create' => Callable[
+          [Optional[Vpc]],
+          Tuple[Optional[Vpc], String]],
+        'delete' => Callable[String],
+        'read' => Callable[
+          [String],
+          Optional[Vpc]]
+      }
+    }
+  }
+}]`, b.String())
+	})
+}
+
+func TestTypeSetFromSchemaErrors(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		convert := func(doc string) func() {
+			return func() { puppetwf.TypeSetFromSchema(c, `Test`, nil, yaml.Unmarshal(c, []byte(doc)).(px.OrderedMap)) }
+		}
+		requirePanicContains(t, `the document has no named schemas`, convert(`{type: object}`))
+		requirePanicContains(t, `$ref '#/definitions/Missing' does not refer to a named schema`,
+			convert(`{definitions: {Disk: {properties: {other: {$ref: '#/definitions/Missing'}}}}}`))
+		requirePanicContains(t, `invalid schema at #/definitions/Disk/properties/size: unknown type 'size'`,
+			convert(`{definitions: {Disk: {properties: {size: {type: size}}}}}`))
+	})
+}
+
+func TestSchemaTypeNames(t *testing.T) {
+	pcore.Do(func(c px.Context) {
+		doc := `{definitions: {network-interface: {properties: {name: {type: string}}}, 0.1: {properties: {name: {type: string}}}}}`
+		ts := puppetwf.TypeSetFromSchema(c, `Test`, nil, yaml.Unmarshal(c, []byte(doc)).(px.OrderedMap))
+		var names []string
+		ts.Types().EachKey(func(k px.Value) { names = append(names, k.String()) })
+		require.Equal(t, []string{`NetworkInterface`, `NetworkInterfaceHandler`, `X01`, `X01Handler`}, names)
+	})
+}
+
+func TestSchemaResource(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `schema`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.TypeSetFromSchema(c, `Network`, semver.MustParseVersion(`1.0.0`), puppetwf.LoadSchema(c, `testdata/schema/network.yaml`))
+		puppetwf.WriteTypeSet(ts, filepath.Join(dir, `types`))
+	})
+	manifest := filepath.Join(dir, `network.pp`)
+	require.NoError(t, ioutil.WriteFile(manifest, []byte(`registerHandler(Network::Vpc, memoryHandler(Network::Vpc))
+
+workflow network {
+  returns => (String $vpcId)
+} {
+  resource vpc {
+    returns => ($vpcId),
+    type => Network::Vpc
+  } {
+    cidrBlock => '192.168.0.0/16'
+  }
+}
+`), 0644))
+
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(manifest)
+		state, id := m.Apply(`network::vpc`, nil)
+		require.Equal(t, `Network::Vpc`, m.State(`network::vpc`, nil).PType().Name())
+		require.Equal(t, `192.168.0.0/16`, state.Get5(`cidrBlock`, px.Undef).String())
+		require.Equal(t, id, state.Get5(`vpcId`, px.Undef).String())
+	})
+}
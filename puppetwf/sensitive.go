@@ -0,0 +1,234 @@
+package puppetwf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// isSensitiveType returns true if the given type is a Sensitive or an Optional[Sensitive]
+func isSensitiveType(t px.Type) bool {
+	if ot, ok := t.(*types.OptionalType); ok {
+		t = ot.ContainedType()
+	}
+	_, ok := t.(*types.SensitiveType)
+	return ok
+}
+
+// sensitiveValue wraps the given value in a Sensitive when the given type is a Sensitive type
+// and the value isn't already wrapped. Undef and Deferred values are never wrapped.
+func sensitiveValue(t px.Type, v px.Value) px.Value {
+	if v == nil || !isSensitiveType(t) {
+		return v
+	}
+	switch v.(type) {
+	case *types.Sensitive, *types.UndefValue, types.Deferred:
+		return v
+	}
+	return types.WrapSensitive(v)
+}
+
+// sensitiveArguments returns a copy of the given argument hash where the value of each parameter
+// that is declared as Sensitive has been wrapped in a Sensitive.
+func sensitiveArguments(ps []px.Parameter, args px.OrderedMap) px.OrderedMap {
+	return args.MapEntries(func(e px.MapEntry) px.MapEntry {
+		for _, p := range ps {
+			if p.Name() == e.Key().String() {
+				return types.WrapHashEntry(e.Key(), sensitiveValue(p.Type(), e.Value()))
+			}
+		}
+		return e
+	})
+}
+
+// unwrapSensitive returns a copy of the given value where all Sensitive values have been replaced
+// with the value that they wrap. An attribute of an object is only unwrapped when its type accepts
+// the unwrapped value.
+func unwrapSensitive(c px.Context, v px.Value) px.Value {
+	if !hasSensitive(v) {
+		return v
+	}
+	switch v := v.(type) {
+	case *types.Sensitive:
+		return unwrapSensitive(c, v.Unwrap())
+	case px.OrderedMap:
+		return v.MapValues(func(ev px.Value) px.Value { return unwrapSensitive(c, ev) })
+	case px.List:
+		return v.Map(func(ev px.Value) px.Value { return unwrapSensitive(c, ev) })
+	case px.PuppetObject:
+		if ot, ok := v.PType().(px.ObjectType); ok {
+			return px.New(c, ot, unwrapAttributes(c, ot, v.InitHash()))
+		}
+	}
+	return v
+}
+
+// unwrapAttributes returns a copy of the given attribute hash where each Sensitive value has been
+// unwrapped provided that the type of the attribute accepts the unwrapped value.
+func unwrapAttributes(c px.Context, t px.ObjectType, attrs px.OrderedMap) px.OrderedMap {
+	return attrs.MapEntries(func(e px.MapEntry) px.MapEntry {
+		if m, ok := t.Member(e.Key().String()); ok {
+			if a, ok := m.(px.Attribute); ok {
+				if uv := unwrapSensitive(c, e.Value()); px.IsInstance(a.Type(), uv) {
+					return types.WrapHashEntry(e.Key(), uv)
+				}
+			}
+		}
+		return e
+	})
+}
+
+// hasSensitive returns true if the given value is, or contains, a Sensitive value.
+func hasSensitive(v px.Value) (found bool) {
+	switch v := v.(type) {
+	case *types.Sensitive:
+		return true
+	case px.StringValue:
+		// A String is also a List but it can't contain a Sensitive
+	case px.OrderedMap:
+		v.EachValue(func(ev px.Value) { found = found || hasSensitive(ev) })
+	case px.List:
+		v.Each(func(ev px.Value) { found = found || hasSensitive(ev) })
+	case px.PuppetObject:
+		return hasSensitive(v.InitHash())
+	}
+	return
+}
+
+// secrets returns the string form of all scalar values that are wrapped in a Sensitive somewhere
+// in the given values.
+func secrets(vs ...px.Value) []string {
+	var ss []string
+	var collect func(v px.Value, wrapped bool)
+	collect = func(v px.Value, wrapped bool) {
+		switch v := v.(type) {
+		case *types.Sensitive:
+			collect(v.Unwrap(), true)
+		case px.StringValue, px.Integer, px.Float:
+			if s := v.String(); wrapped && s != `` {
+				ss = append(ss, s)
+			}
+		case px.OrderedMap:
+			v.EachValue(func(ev px.Value) { collect(ev, wrapped) })
+		case px.List:
+			v.Each(func(ev px.Value) { collect(ev, wrapped) })
+		case px.PuppetObject:
+			collect(v.InitHash(), wrapped)
+		}
+	}
+	for _, v := range vs {
+		collect(v, false)
+	}
+	return ss
+}
+
+// redactErrors must be deferred. It recovers from a panic and, if the text of the recovered error
+// contains any of the given secrets, panics again with an error where those secrets are redacted.
+// An issue.Reported retains its code, location, and cause. All other errors are propagated
+// unchanged.
+func redactErrors(step string, secrets []string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if len(secrets) > 0 {
+		if ri, ok := r.(issue.Reported); ok {
+			panic(redactReported(ri, secrets))
+		}
+		msg := fmt.Sprint(r)
+		if rm := redact(msg, secrets); rm != msg {
+			panic(px.Error(SensitiveValueInError, issue.H{`step`: step, `message`: rm}))
+		}
+	}
+	panic(r)
+}
+
+// redactReported returns a copy of the given issue where the secrets have been redacted from the
+// arguments and from the chain of causes, or the issue itself when it doesn't reveal any secret.
+func redactReported(ri issue.Reported, secrets []string) issue.Reported {
+	changed := false
+	keys := ri.Keys()
+	args := make(issue.H, len(keys))
+	for _, k := range keys {
+		arg := ri.Argument(k)
+		if s := fmt.Sprint(arg); s != `` {
+			if rs := redact(s, secrets); rs != s {
+				arg = rs
+				changed = true
+			}
+		}
+		args[k] = arg
+	}
+
+	cause := ri.Cause()
+	switch c := cause.(type) {
+	case nil:
+	case issue.Reported:
+		if rc := redactReported(c, secrets); rc != c {
+			cause = rc
+			changed = true
+		}
+	default:
+		msg := c.Error()
+		if rm := redact(msg, secrets); rm != msg {
+			cause = errors.New(rm)
+			changed = true
+		}
+	}
+	if !changed {
+		return ri
+	}
+	return issue.ErrorWithStack(ri.Code(), args, ri.Location(), cause, ri.Stack())
+}
+
+// redact replaces each occurrence of a secret in the given string with a text that doesn't reveal
+// it. An occurrence that is part of a longer word or number, such as the 1 in 12, is not a match.
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		b := strings.Builder{}
+		for {
+			i := indexOfWhole(s, secret)
+			if i < 0 {
+				break
+			}
+			b.WriteString(s[:i])
+			b.WriteString(`[value redacted]`)
+			s = s[i+len(secret):]
+		}
+		b.WriteString(s)
+		s = b.String()
+	}
+	return s
+}
+
+// indexOfWhole returns the index of the first occurrence of the given secret in the given string
+// that isn't immediately preceded or followed by a word character that would continue it, or -1
+// if no such occurrence exists.
+func indexOfWhole(s, secret string) int {
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], secret)
+		if i < 0 {
+			return -1
+		}
+		i += offset
+		end := i + len(secret)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		first, _ := utf8.DecodeRuneInString(secret)
+		last, _ := utf8.DecodeLastRuneInString(secret)
+		if !(isWordRune(first) && isWordRune(before)) && !(isWordRune(last) && isWordRune(after)) {
+			return i
+		}
+		offset = i + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
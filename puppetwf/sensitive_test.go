@@ -0,0 +1,55 @@
+package puppetwf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-evaluator/puppet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactMatchesWholeValues(t *testing.T) {
+	require.Equal(t, `line 12: got [value redacted]`, redact(`line 12: got 1`, []string{`1`}))
+	require.Equal(t, `password '[value redacted]' rejected`, redact(`password 'hunter2' rejected`, []string{`hunter2`}))
+	require.Equal(t, `hunter22 and xhunter2`, redact(`hunter22 and xhunter2`, []string{`hunter2`}))
+	require.Equal(t, `a[value redacted]b`, redact(`a#s3#b`, []string{`#s3#`}))
+}
+
+func TestRedactErrorsRetainsIssueCode(t *testing.T) {
+	puppet.Do(func(c pdsl.EvaluationContext) {
+		r := recoverFrom(func() {
+			defer redactErrors(`example`, []string{`hunter2`})
+			panic(px.Error(px.Failure, issue.H{`message`: `rejected hunter2`}))
+		})
+		ri, ok := r.(issue.Reported)
+		require.True(t, ok)
+		require.Equal(t, issue.Code(px.Failure), ri.Code())
+		require.Contains(t, ri.Error(), `rejected [value redacted]`)
+		require.NotContains(t, ri.Error(), `hunter2`)
+
+		cause := errors.New(`rejected hunter2`)
+		r = recoverFrom(func() {
+			defer redactErrors(`example`, []string{`hunter2`})
+			panic(issue.NewNested(StepRuntimeError, issue.H{`workflow`: `w`, `step`: `s`, `path`: `s`, `method`: `create`, `inputs`: px.EmptyMap}, nil, cause))
+		})
+		ri = r.(issue.Reported)
+		require.Equal(t, issue.Code(StepRuntimeError), ri.Code())
+		require.Equal(t, `rejected [value redacted]`, ri.Cause().Error())
+
+		r = recoverFrom(func() {
+			defer redactErrors(`example`, []string{`hunter2`})
+			panic(fmt.Errorf(`rejected hunter2`))
+		})
+		require.Equal(t, issue.Code(SensitiveValueInError), r.(issue.Reported).Code())
+	})
+}
+
+func recoverFrom(f func()) (r interface{}) {
+	defer func() { r = recover() }()
+	f()
+	return
+}
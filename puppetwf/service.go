@@ -1,9 +1,9 @@
 package puppetwf
 
 import (
-	"bytes"
+	"io"
 	"io/ioutil"
-	"unicode"
+	"sync"
 
 	"github.com/lyraproj/pcore/pcore"
 
@@ -14,6 +14,7 @@ import (
 	"github.com/lyraproj/puppet-evaluator/evaluator"
 	"github.com/lyraproj/puppet-evaluator/pdsl"
 	"github.com/lyraproj/puppet-evaluator/puppet"
+	"github.com/lyraproj/puppet-parser/parser"
 	"github.com/lyraproj/servicesdk/grpc"
 	"github.com/lyraproj/servicesdk/service"
 	"github.com/lyraproj/servicesdk/serviceapi"
@@ -21,108 +22,211 @@ import (
 
 const ManifestLoaderID = `Puppet::ManifestLoader`
 
+// serviceNameKey is the context key for the name of the service that represents the manifest that
+// the context was created for.
+const serviceNameKey = `Puppet::ServiceName`
+
 type manifestLoader struct {
 	ctx         pdsl.EvaluationContext
 	serviceName string
+	lock        sync.RWMutex
+	steps       map[string]loadedStep
+	paths       map[string]string
+	metadata    map[string]*manifestMetadata
+	modules     map[string]px.ModuleLoader
+}
+
+// loadedStep is a top level step of a manifest that has been loaded.
+type loadedStep struct {
+	definition serviceapi.Definition
+	path       string
 }
 
 type manifestService struct {
-	ctx     pdsl.EvaluationContext
-	service serviceapi.Service
+	ctx      pdsl.EvaluationContext
+	service  serviceapi.Service
+	metadata *manifestMetadata
+	steps    []*puppetStep
 }
 
 func (m *manifestService) Invoke(identifier, name string, arguments ...px.Value) px.Value {
 	return m.service.Invoke(m.ctx.Fork(), identifier, name, arguments...)
 }
 
+// Metadata returns the TypeSet and the definitions of the service. The definitions include the
+// definition of the service itself when the manifest declares a version, a description, or
+// requirements using metadata().
 func (m *manifestService) Metadata() (px.TypeSet, []serviceapi.Definition) {
-	return m.service.Metadata(m.ctx.Fork())
+	ts, defs := m.service.Metadata(m.ctx.Fork())
+	if m.metadata.declared() {
+		defs = append(defs, m.metadata.definition(m.service.Identifier(m.ctx)))
+	}
+	return ts, defs
 }
 
 func (m *manifestService) State(name string, parameters px.OrderedMap) px.PuppetObject {
 	return m.service.State(m.ctx.Fork(), name, parameters)
 }
 
-func WithService(serviceName string, sf func(c pdsl.EvaluationContext, s serviceapi.Service)) {
+// Option configures the context that the service is created in.
+type Option func(c px.Context)
+
+func WithService(serviceName string, sf func(c pdsl.EvaluationContext, s serviceapi.Service), options ...Option) {
 	pcore.SetLogger(grpc.NewHclogLogger(hclog.Default()))
 	pcore.Set(`tasks`, types.BooleanTrue)
 	pcore.Set(`workflow`, types.BooleanTrue)
 
 	puppet.Do(func(c pdsl.EvaluationContext) {
+		for _, option := range options {
+			option(c)
+		}
+		defer closeResources(c)
 		c.DoWithLoader(service.FederatedLoader(c.Loader()), func() {
 			sb := service.NewServiceBuilder(c, serviceName)
 			sb.RegisterApiType(`Puppet::Service`, &manifestService{})
-			sb.RegisterAPI(`Puppet::ManifestLoader`, &manifestLoader{c, serviceName})
+			ml := &manifestLoader{ctx: c, serviceName: serviceName}
+			sb.RegisterAPI(`Puppet::ManifestLoader`, ml)
 			s := sb.Server()
 			c.Set(`Puppet::ServiceLoader`, s)
+			c.Set(ManifestLoaderID, ml)
 			sf(c, s)
 		})
 	})
 }
 
-func Start(serviceName string) {
+// closeResources closes the span exporter and the cassette of the given context when they implement
+// io.Closer.
+func closeResources(c px.Context) {
+	for _, key := range []string{SpanExporterKey, CassetteKey} {
+		if v, ok := c.Get(key); ok {
+			if cl, ok := v.(io.Closer); ok {
+				if err := cl.Close(); err != nil {
+					hclog.Default().Warn(`unable to close`, `resource`, key, `error`, err)
+				}
+			}
+		}
+	}
+}
+
+func Start(serviceName string, options ...Option) {
 	WithService(serviceName, func(c pdsl.EvaluationContext, s serviceapi.Service) {
 		grpc.Serve(c, s)
-	})
+	}, options...)
 }
 
 func (m *manifestLoader) LoadManifest(moduleDir string, fileName string) serviceapi.Definition {
-	ec := evaluator.WithParent(m.ctx, evaluator.NewEvaluator)
+	mf, ms, _ := m.loadManifest(moduleDir, fileName, false)
+	s, _ := m.ctx.Get(`Puppet::ServiceLoader`)
+	return s.(*service.Server).AddApi(mf, ms)
+}
+
+// loadManifest loads the manifest in the given file and returns the name of the service that
+// represents it, the service, and the parsed manifest. The top level statements of the manifest
+// are evaluated unless analyze is true, in which case the manifest is loaded for analysis only.
+// Only the registerHandler() calls of such a manifest are considered and functions that run
+// commands, such as exec() and lookup(), refuse to run.
+func (m *manifestLoader) loadManifest(moduleDir string, fileName string, analyze bool) (string, *manifestService, parser.Expression) {
 	content, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		panic(px.Error(px.UnableToReadFile, issue.H{`path`: fileName, `detail`: err.Error()}))
 	}
+	return m.loadManifestSource(moduleDir, fileName, content, analyze)
+}
+
+// loadManifestSource is like loadManifest but uses the given content instead of reading the file.
+func (m *manifestLoader) loadManifestSource(moduleDir string, fileName string, content []byte, analyze bool) (string, *manifestService, parser.Expression) {
+	ec := evaluator.WithParent(m.ctx, evaluator.NewEvaluator)
+	if analyze {
+		ec.Set(sandboxKey, true)
+	}
+	ec.SetLoader(m.modulesFor(ec.Loader(), moduleDir))
+	ast := ec.ParseAndValidate(fileName, string(content), false)
+	md := parseMetadata(ec, ast)
+	mf := serviceName(md, moduleDir, fileName)
+	m.checkServiceName(mf, fileName)
+	m.checkStepNames(fileName, ast)
 
-	mf := munged(fileName)
 	sb := service.NewServiceBuilder(ec, mf)
 	ec.Set(ServerBuilderKey, sb)
-
-	ast := ec.ParseAndValidate(fileName, string(content), false)
+	ec.Set(ManifestLoaderID, m)
+	ec.Set(serviceNameKey, mf)
+	for _, key := range []string{LookupKey, LoggerKey, SpanExporterKey, CassetteKey, HandlerObserverKey} {
+		if v, ok := m.ctx.Get(key); ok {
+			ec.Set(key, v)
+		}
+	}
 	ec.AddDefinitions(ast)
 
 	sb.RegisterStateConverter(ResolveState)
+	var steps []*puppetStep
 	for _, def := range ec.ResolveDefinitions() {
 		switch def := def.(type) {
 		case PuppetStep:
 			sb.RegisterStep(def.Step())
+			if ps, ok := def.(*puppetStep); ok {
+				steps = append(steps, ps)
+			}
 		case px.Type:
 			sb.RegisterType(def)
 		}
 	}
-	pdsl.TopEvaluate(ec, ast)
-	s, _ := m.ctx.Get(`Puppet::ServiceLoader`)
-	return s.(*service.Server).AddApi(mf, &manifestService{ec, sb.Server()})
+	if md != nil {
+		md.validate(ec)
+	}
+	if analyze {
+		registerHandlers(ec, sb, ast)
+	} else {
+		pdsl.TopEvaluate(ec, ast)
+	}
+	ms := &manifestService{ec, sb.Server(), md, steps}
+	_, defs := ms.Metadata()
+	m.addStepDefinitions(fileName, defs)
+	m.registerServiceName(mf, fileName, md)
+	return mf, ms, ast
 }
 
-func munged(path string) string {
-	b := bytes.NewBufferString(``)
-	pu := true
-	ps := true
-	for _, c := range path {
-		if c == '/' {
-			if !ps {
-				b.WriteString(`::`)
-				ps = true
-			}
-		} else if c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
-			if ps || pu {
-				// First character of the name must be an upper case letter
-				if ps && (c == '_' || c >= '0' && c <= '9') {
-					// Must insert extra character
-					b.WriteRune('X')
-				} else {
-					c = unicode.ToUpper(c)
-				}
+// checkStepNames panics if a top level step of the given program has the same name as a top level
+// step of another manifest that has been loaded already.
+func (m *manifestLoader) checkStepNames(path string, ast parser.Expression) {
+	p, ok := ast.(*parser.Program)
+	if !ok {
+		return
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	path = absPath(path)
+	for _, d := range p.Definitions() {
+		if se, ok := d.(*parser.StepExpression); ok {
+			if other, ok := m.steps[se.Name()]; ok && other.path != path {
+				panic(px.Error2(se, StepNameCollision, issue.H{`name`: se.Name(), `other`: other.path}))
 			}
-			b.WriteRune(c)
-			ps = false
-			pu = false
-		} else {
-			pu = true
 		}
 	}
-	if ps {
-		b.Truncate(b.Len() - 2)
+}
+
+// addStepDefinitions records the top level steps among the given definitions of the manifest with
+// the given path so that they can be called by name from manifests that are loaded later.
+func (m *manifestLoader) addStepDefinitions(path string, defs []serviceapi.Definition) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.steps == nil {
+		m.steps = make(map[string]loadedStep)
+	}
+	path = absPath(path)
+	for _, def := range defs {
+		if def.Identifier().Namespace() == px.NsService {
+			continue
+		}
+		if style, ok := def.Properties().Get4(`style`); ok && style.String() != `callable` {
+			m.steps[def.Identifier().Name()] = loadedStep{def, path}
+		}
 	}
-	return b.String()
+}
+
+// stepDefinition returns the definition of a top level step that has been loaded from a manifest.
+func (m *manifestLoader) stepDefinition(name string) (serviceapi.Definition, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ls, ok := m.steps[name]
+	return ls.definition, ok
 }
@@ -1,6 +1,8 @@
 package puppetwf
 
 import (
+	"io"
+
 	"github.com/lyraproj/pcore/px"
 	"github.com/lyraproj/pcore/types"
 	"github.com/lyraproj/puppet-evaluator/pdsl"
@@ -8,9 +10,12 @@ import (
 )
 
 type state struct {
+	step            string
 	ctx             px.Context
 	stateType       px.ObjectType
 	unresolvedState px.OrderedMap
+	parameters      []px.Parameter
+	locals          []*local
 }
 
 func (r *state) Type() px.ObjectType {
@@ -21,13 +26,89 @@ func (r *state) State() interface{} {
 	return r.unresolvedState
 }
 
+func (r *state) requiredLocals() []*local {
+	return r.locals
+}
+
+func (r *state) stepName() string {
+	return r.step
+}
+
+func (r *state) stateParameters() []px.Parameter {
+	return r.parameters
+}
+
+// ResolveState resolves the state using the given parameters. The values of parameters that are
+// declared as Sensitive remain wrapped in the resolved state, also when the state attribute that
+// they are assigned to doesn't accept a Sensitive value. Such values are unwrapped when the state
+// is handed over to the create or update function of the handler. A parameter without a value is
+// given its Deferred default, such as lookup('key'), resolved.
 func ResolveState(ctx px.Context, state wf.State, parameters px.OrderedMap) px.PuppetObject {
+	if ps, ok := state.(interface{ stateParameters() []px.Parameter }); ok {
+		parameters = sensitiveArguments(ps.stateParameters(), resolveDefaults(ctx, ps.stateParameters(), parameters))
+	}
+	if st, ok := state.(interface{ stepName() string }); ok {
+		defer observe(ctx, st.stepName(), `resource`, `resolveState`)()
+		defer amendRuntimeError(ctx, st.stepName(), `resolveState`, nil, parameters)
+	}
+	defer redactErrors(state.Type().Name(), secrets(parameters))
+
 	scope := ctx.Scope().(pdsl.Scope)
 	return scope.WithLocalScope(func() (v px.Value) {
 		parameters.EachPair(func(k, v px.Value) {
 			scope.Set(k.String(), v)
 		})
+		if ls, ok := state.(interface{ requiredLocals() []*local }); ok {
+			evaluateLocals(ctx.(pdsl.EvaluationContext), scope, ls.requiredLocals())
+		}
 		st := types.ResolveDeferred(ctx, state.State().(px.OrderedMap), scope).(px.OrderedMap)
-		return px.New(ctx, state.Type(), st).(px.PuppetObject)
+		return newResolvedState(ctx, state.Type(), st)
 	}).(px.PuppetObject)
 }
+
+// newResolvedState creates an instance of the given type from the given attributes. The attributes
+// are validated with all Sensitive values unwrapped but the returned object retains the Sensitive
+// values.
+func newResolvedState(ctx px.Context, t px.ObjectType, attrs px.OrderedMap) px.PuppetObject {
+	o := px.New(ctx, t, unwrapAttributes(ctx, t, attrs)).(px.PuppetObject)
+	if !hasSensitive(attrs) {
+		return o
+	}
+	wrapped := attrs.SelectPairs(func(k, v px.Value) bool { return hasSensitive(v) })
+	return &sensitiveState{stateType: t, attributes: o.InitHash().Merge(wrapped)}
+}
+
+// sensitiveState is a resolved state where one or more attributes have Sensitive values that the
+// type of the attribute doesn't accept. It must be unwrapped using unwrapSensitive before it is
+// handed over to a handler.
+type sensitiveState struct {
+	stateType  px.ObjectType
+	attributes px.OrderedMap
+}
+
+func (s *sensitiveState) String() string {
+	return px.ToString(s)
+}
+
+func (s *sensitiveState) Equals(other interface{}, guard px.Guard) bool {
+	if o, ok := other.(*sensitiveState); ok {
+		return s.stateType.Equals(o.stateType, guard) && s.attributes.Equals(o.attributes, guard)
+	}
+	return false
+}
+
+func (s *sensitiveState) ToString(bld io.Writer, format px.FormatContext, g px.RDetect) {
+	types.ObjectToString(s, format, bld, g)
+}
+
+func (s *sensitiveState) PType() px.Type {
+	return s.stateType
+}
+
+func (s *sensitiveState) Get(key string) (px.Value, bool) {
+	return s.attributes.Get4(key)
+}
+
+func (s *sensitiveState) InitHash() px.OrderedMap {
+	return s.attributes
+}
@@ -0,0 +1,24 @@
+workflow action_returns {
+  parameters => (
+    String $kind,
+  ),
+  returns => (
+    String $zone,
+  )
+} {
+  action zone {
+    parameters => ($kind),
+    returns => (String $zone)
+  } {
+    $fallback = { zone => 'a' }
+    if $kind == 'returned' {
+      return({ zone => 4 })
+    }
+    case $kind {
+      'missing': { {} }
+      'extra': { { zone => 'a', other => 'b' } }
+      'type': { { zone => 3 } }
+      default: { $fallback }
+    }
+  }
+}
@@ -0,0 +1,18 @@
+workflow alias_example {
+  parameters => (
+    String $region = 'us-east-1',
+  ),
+  returns => (
+    String $zone = availabilityZone,
+  )
+} {
+  action zone {
+    parameters => (
+      String $r = from('region'),
+      String $suffix = 'a',
+    ),
+    returns => (String $availabilityZone)
+  } {
+    return({ availabilityZone => "${r}${suffix}" })
+  }
+}
@@ -0,0 +1,31 @@
+workflow attach {
+  parameters => (
+    String $network,
+    Integer $retries = 3,
+  ),
+  returns => (
+    String $attachment,
+  )
+} {
+  action attach_network {
+    parameters => ($network),
+    returns => ($attachment)
+  } {
+    return({ attachment => "${network}-attached" })
+  }
+}
+
+workflow call_example {
+  parameters => (
+    String $net,
+  ),
+  returns => (
+    String $result,
+  )
+} {
+  workflow net_attachment {
+    call => 'attach',
+    parameters => ($network = from('net')),
+    returns => ($result = attachment)
+  }
+}
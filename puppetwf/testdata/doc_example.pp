@@ -0,0 +1,77 @@
+# A label that is attached to the VPC.
+type Doc_example::Label = Object[{
+  annotations => {
+    Lyra::Resource => {
+      immutableAttributes => ['key'],
+      providedAttributes => ['labelId']
+    }
+  },
+  attributes => {
+    key => String,
+    value => String,
+    labelId => Optional[String]
+  }
+}]
+
+registerHandler(Doc_example::Label, memoryHandler(Doc_example::Label))
+
+# Provisions a VPC and a number of subnets in it.
+#
+# The subnets are created in parallel.
+workflow doc_example {
+  parameters => (
+    Hash[String,String] $tags = lookup('aws.tags'),
+    String $tenancy = 'default',
+  ),
+  returns => (
+    String $vpcId,
+    Array[String] $subnetIds,
+  )
+} {
+  # The VPC that contains the subnets.
+  resource vpc {
+    parameters => ($tags, $tenancy),
+    returns => ($vpcId),
+    type => Aws::Vpc
+  } {
+    cidrBlock => '192.168.0.0/16',
+    instanceTenancy => $tenancy,
+    tags => $tags,
+  }
+
+  # One subnet for each number in the range.
+  resource subnets {
+    parameters => ($vpcId),
+    returns => ($subnetIds = subnetId),
+    when => 'vpcId',
+    iteration => {
+      function => range,
+      over => [1, 3],
+      variables => [Parameter('n', Integer)]
+    },
+    type => Aws::Subnet
+  } {
+    vpcId => $vpcId,
+    cidrBlock => $n,
+  }
+
+  resource label {
+    parameters => ($vpcId),
+    returns => (String $labelId),
+    type => Doc_example::Label
+  } {
+    key => 'vpc',
+    value => $vpcId,
+  }
+
+  # Logs the VPC.
+  #
+  # Runs once the VPC exists.
+  action log {
+    parameters => ($vpcId),
+    returns => (String $logged)
+  } {
+    notice("created ${vpcId}")
+    return({ logged => $vpcId })
+  }
+}
@@ -0,0 +1,36 @@
+# Creates a disk in a zone.
+workflow langserver_example {
+  parameters => (
+    String $zone,
+    Integer $size = 10,
+  ),
+  returns    => (String $diskId),
+} {
+  resource disk {
+    parameters => ($zone, $size),
+    returns    => ($diskId),
+    type       => Disks::Disk,
+  } {
+    size => $size,
+    zone => $zone,
+
+    tags => {},
+  }
+
+  action report {
+    parameters => ($diskId),
+  } {
+    notice("${diskId} in ${zone}")
+  }
+}
+
+workflow langserver_call {
+  parameters => (String $zone),
+  returns    => (String $diskId),
+} {
+  workflow create {
+    call       => 'langserver_example',
+    parameters => ($zone),
+    returns    => ($diskId),
+  }
+}
@@ -0,0 +1,25 @@
+type Disks = TypeSet[{
+  pcore_uri      => 'http://puppet.com/2016.1/pcore',
+  pcore_version  => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name           => 'Disks',
+  version        => '0.1.0',
+  types          => {
+    Disk => {
+      annotations => {
+        Lyra::Resource => {
+          'providedAttributes' => ['diskId'],
+        },
+      },
+      attributes  => {
+        'diskId' => {
+          'type'  => Optional[String],
+          'value' => undef,
+        },
+        'size'   => Integer,
+        'zone'   => String,
+        'tags'   => Hash[String, String],
+      },
+    },
+  },
+}]
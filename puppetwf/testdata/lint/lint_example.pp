@@ -0,0 +1,65 @@
+type Lint_example::Thing = Object[{
+  attributes => {
+    name => String,
+    thingId => Optional[String],
+  }
+}]
+
+registerHandler(Lint_example::Thing, memoryHandler(Lint_example::Thing))
+
+workflow lint_example {
+  parameters => (
+    String $name,
+    String $region,
+    String $zone, # lint:ignore PUPPETWF_UNUSED_PARAMETER
+  ),
+  returns => (
+    String $thingId,
+    String $output,
+  )
+} {
+  resource thing {
+    parameters => ($name),
+    returns => ($thingId),
+    type => Lint_example::Thing
+  } {
+    name => $name,
+  }
+
+  # lint:ignore PUPPETWF_MISSING_EXTERNAL_ID
+  resource other {
+    parameters => ($name),
+    type => Lint_example::Thing
+  } {
+    name => $name,
+  }
+
+  action report {
+    parameters => ($name)
+  } {
+    notice("${name} is ${color}")
+  }
+
+  workflow nested {
+    parameters => ($thingId),
+    returns => ($copy)
+  } {
+    action copy {
+      parameters => ($thingId),
+      returns => (String $copy, String $name)
+    } {
+      $listing = exec('ls', "-l ${thingId}")
+      $quoted = exec('sh', '-c', "ls '${thingId}'")
+      $ignored = exec('ls', "-l ${thingId}") # lint:ignore
+      return({ copy => $thingId, name => exec("echo ${listing}") })
+    }
+  }
+
+  stateHandler thing_handler {
+    parameters => ($name)
+  } {
+    function create(Lint_example::Thing $thing) { [$thing, 'x'] }
+    function read(String $id) { Lint_example::Thing(name => $id) }
+    function delete(String $id) { true }
+  }
+}
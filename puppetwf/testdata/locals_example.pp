@@ -0,0 +1,34 @@
+type Locals::Thing = Object[{
+  attributes => {
+    name => String,
+    tags => Hash[String, String]
+  }
+}]
+
+workflow locals_example {
+  parameters => (
+    String $env,
+    Hash[String, String] $tags = {},
+  ),
+  returns => (
+    String $name,
+  ),
+  locals => {
+    full => "${prefix}-x",
+    prefix => "${env}-app",
+    all_tags => $tags + { env => $env },
+  }
+} {
+  action naming {
+    returns => (String $name)
+  } {
+    return({ name => "${full}:${all_tags['env']}" })
+  }
+
+  resource thing {
+    type => Locals::Thing
+  } {
+    name => $prefix,
+    tags => $all_tags
+  }
+}
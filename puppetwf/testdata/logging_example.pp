@@ -0,0 +1,14 @@
+workflow logging_example {
+  parameters => (String $name),
+  returns => (String $greeting)
+} {
+  action greet {
+    parameters => ($name),
+    returns => (String $greeting)
+  } {
+    debug('about to greet')
+    notice("greeting ${name}")
+    $result = { greeting => "hello ${name}" }
+    $result
+  }
+}
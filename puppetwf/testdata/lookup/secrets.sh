@@ -0,0 +1,5 @@
+#!/bin/sh
+case "$1" in
+  db.password) echo "'s3cr3t'" ;;
+  *) exit 1 ;;
+esac
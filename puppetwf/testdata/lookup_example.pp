@@ -0,0 +1,17 @@
+workflow lookup_example {
+  returns => (
+    Hash $tags,
+    String $password,
+  )
+} {
+  action tags {
+    parameters => (
+      Hash[String,String] $tags = lookup('aws.tags'),
+      Sensitive[String] $password = lookup('db.password'),
+      String $owner = lookup('team', 'nobody')
+    ),
+    returns => (Hash $tags, String $password)
+  } {
+    { tags => $tags + { owner => $owner }, password => unwrap($password) }
+  }
+}
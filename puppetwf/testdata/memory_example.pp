@@ -0,0 +1,31 @@
+type Memory_example::Server = Object[{
+  annotations => {
+    Lyra::Resource => {
+      providedAttributes => ['serverId', 'address', 'port', 'ready']
+    }
+  },
+  attributes => {
+    name => String,
+    serverId => Optional[String],
+    address => Optional[String],
+    port => Optional[Integer],
+    ready => Optional[Boolean],
+    size => Enum[small, large]
+  }
+}]
+
+registerHandler(Memory_example::Server, memoryHandler(Memory_example::Server))
+
+workflow memory_example {
+  parameters => (String $name),
+  returns => (String $serverId, String $address)
+} {
+  resource server {
+    parameters => ($name),
+    returns => ($serverId, $address),
+    type => Memory_example::Server
+  } {
+    name => $name,
+    size => small
+  }
+}
@@ -0,0 +1,34 @@
+metadata({
+  name => 'Examples::Metadata',
+  version => '1.2.0',
+  description => 'Creates a disk',
+  requires => { 'Metadata_example' => '>=0.1.0 <1.0.0' }
+})
+
+type Metadata_example = TypeSet[{
+  pcore_uri => 'http://puppet.com/2016.1/pcore',
+  pcore_version => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name => 'Metadata_example',
+  version => '0.1.0',
+  types => {
+    Disk => {
+      attributes => {
+        name => String,
+        diskId => Optional[String]
+      }
+    }
+  }
+}]
+
+workflow metadata_example {
+  parameters => (String $name),
+  returns => (String $diskId)
+} {
+  action disk {
+    parameters => ($name),
+    returns => (String $diskId)
+  } {
+    { diskId => "disk-${name}" }
+  }
+}
@@ -0,0 +1,19 @@
+workflow sensitive_example {
+  parameters => (
+    Sensitive[String] $password = 'hunter2',
+  ),
+  returns => (
+    Boolean $valid,
+  )
+} {
+  action check {
+    parameters => (Sensitive[String] $password),
+    returns => (Boolean $valid)
+  } {
+    $clear = unwrap($password)
+    case $clear {
+      'wrong': { fail("invalid password '${clear}'") }
+      default: { { valid => true } }
+    }
+  }
+}
@@ -0,0 +1,45 @@
+type Sensitive_resource::Account = Object[{
+  attributes => {
+    user     => String,
+    password => String,
+    id       => Optional[String],
+  },
+}]
+
+workflow sensitive_resource {
+  parameters => (
+    String $user,
+    Sensitive[String] $password,
+  ),
+  returns    => (String $id),
+} {
+  resource account {
+    parameters => (
+      String $user,
+      Sensitive[String] $password,
+    ),
+    returns    => ($id),
+    type       => Sensitive_resource::Account,
+  } {
+    user     => $user,
+    password => $password,
+  }
+}
+
+stateHandler account_handler {
+} {
+  function create($state) {
+    if $state.password == 'wrong' {
+      fail("rejected password ${state.password}")
+    }
+    [Sensitive_resource::Account(user => $state.user, password => $state.password, id => "id-${state.user}"), "id-${state.user}"]
+  }
+
+  function read($id) {
+    Sensitive_resource::Account(user => 'read', password => 'read', id => $id)
+  }
+
+  function delete($id) {
+    true
+  }
+}
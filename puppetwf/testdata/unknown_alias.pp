@@ -0,0 +1,12 @@
+workflow unknown_alias {
+  parameters => (
+    String $region,
+  )
+} {
+  action zone {
+    parameters => (String $r = from('regin')),
+    returns => (String $availabilityZone)
+  } {
+    return({ availabilityZone => "${r}a" })
+  }
+}
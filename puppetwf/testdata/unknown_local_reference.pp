@@ -0,0 +1,12 @@
+workflow unknown_local_reference {
+  parameters => (String $env),
+  locals => {
+    prefix => "${environment}-app",
+  }
+} {
+  action naming {
+    returns => (String $name)
+  } {
+    return({ name => $prefix })
+  }
+}
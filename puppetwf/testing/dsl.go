@@ -0,0 +1,133 @@
+package testing
+
+import (
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+)
+
+// HarnessKey is the context key under which tests written in Puppet find the harness.
+const HarnessKey = `Puppet::TestHarness`
+
+func harness(c px.Context, function string) *Harness {
+	if v, ok := c.Get(HarnessKey); ok {
+		return v.(*Harness)
+	}
+	panic(px.Error(NoHarness, issue.H{`function`: function}))
+}
+
+// typeName returns the name of the given type, or the given string when it is a string.
+func typeName(v px.Value) string {
+	if t, ok := v.(px.Type); ok {
+		return t.Name()
+	}
+	return v.String()
+}
+
+func init() {
+	px.NewGoFunction(`mock_handler`,
+		func(d px.Dispatch) {
+			d.Param(`Variant[String,Type[Object]]`)
+			d.OptionalBlock(`Callable[1,1]`)
+			d.Function2(func(c px.Context, args []px.Value, block px.Lambda) px.Value {
+				f := harness(c, `mock_handler`).Fake(typeName(args[0]))
+				if block != nil {
+					location := c.StackTop()
+					f.CreateFunc = func(state px.OrderedMap) (px.OrderedMap, string, error) {
+						v := block.Call(c, nil, state)
+						r, ok := v.(px.List)
+						if !ok || r.Len() != 2 {
+							panic(px.Error2(location, InvalidMockResult, issue.H{`type`: typeName(args[0]), `actual`: px.DetailedValueType(v)}))
+						}
+						return stateHash(r.At(0)), r.At(1).String(), nil
+					}
+				}
+				return px.Undef
+			})
+		},
+	)
+
+	px.NewGoFunction(`run_workflow`,
+		func(d px.Dispatch) {
+			d.Param(`String`)
+			d.OptionalParam(`Hash[String,Any]`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				h := harness(c, `run_workflow`)
+				params := px.EmptyMap
+				if len(args) > 1 {
+					params = args[1].(px.OrderedMap)
+				}
+				m, def := h.workflow(args[0].String())
+				return m.runWorkflow(def, params)
+			})
+		},
+	)
+
+	px.NewGoFunction(`handler_calls`,
+		func(d px.Dispatch) {
+			d.Param(`Variant[String,Type[Object]]`)
+			d.OptionalParam(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				method := ``
+				if len(args) > 1 {
+					method = args[1].String()
+				}
+				calls := harness(c, `handler_calls`).CallsTo(typeName(args[0]), method)
+				cs := make([]px.Value, len(calls))
+				for i, call := range calls {
+					result := call.Result
+					if result == nil {
+						result = px.Undef
+					}
+					cargs := make([]px.Value, len(call.Args))
+					for ai, arg := range call.Args {
+						if po, ok := arg.(px.PuppetObject); ok {
+							arg = po.InitHash()
+						}
+						cargs[ai] = arg
+					}
+					cs[i] = types.WrapStringToValueMap(map[string]px.Value{
+						`method`: types.WrapString(call.Method),
+						`args`:   types.WrapValues(cargs),
+						`result`: result})
+				}
+				return types.WrapValues(cs)
+			})
+		},
+	)
+
+	px.NewGoFunction(`assert_equal`,
+		func(d px.Dispatch) {
+			d.Param(`Any`)
+			d.Param(`Any`)
+			d.OptionalParam(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if !args[0].Equals(args[1], nil) {
+					msg := `expected ` + px.ToPrettyString(args[0]) + `, got ` + px.ToPrettyString(args[1])
+					if len(args) > 2 {
+						msg = args[2].String() + `: ` + msg
+					}
+					panic(px.Error2(c.StackTop(), AssertionFailed, issue.H{`message`: msg}))
+				}
+				return px.Undef
+			})
+		},
+	)
+
+	px.NewGoFunction(`assert_true`,
+		func(d px.Dispatch) {
+			d.Param(`Any`)
+			d.OptionalParam(`String`)
+			d.Function(func(c px.Context, args []px.Value) px.Value {
+				if b, ok := args[0].(px.Boolean); !ok || !b.Bool() {
+					msg := `expected true, got ` + px.ToPrettyString(args[0])
+					if len(args) > 1 {
+						msg = args[1].String() + `: ` + msg
+					}
+					panic(px.Error2(c.StackTop(), AssertionFailed, issue.H{`message`: msg}))
+				}
+				return px.Undef
+			})
+		},
+	)
+}
@@ -0,0 +1,128 @@
+package testing
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/wf"
+)
+
+// FakeHandler is a wf.CRUD that keeps all states in memory. Each method can be replaced by setting
+// the corresponding function field. The default implementations store the state as given and
+// assign external ids of the form <type name>-<n>.
+type FakeHandler struct {
+	CreateFunc func(state px.OrderedMap) (px.OrderedMap, string, error)
+	ReadFunc   func(externalID string) (px.OrderedMap, error)
+	UpdateFunc func(externalID string, state px.OrderedMap) (px.OrderedMap, error)
+	DeleteFunc func(externalID string) error
+
+	typeName string
+	lock     sync.Mutex
+	count    int
+	states   map[string]px.OrderedMap
+}
+
+// NewFakeHandler returns a fake handler for the resource type with the given name.
+func NewFakeHandler(typeName string) *FakeHandler {
+	return &FakeHandler{typeName: typeName, states: make(map[string]px.OrderedMap)}
+}
+
+// States returns a copy of the states that the handler currently holds, keyed by external id.
+func (f *FakeHandler) States() map[string]px.OrderedMap {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sc := make(map[string]px.OrderedMap, len(f.states))
+	for k, v := range f.states {
+		sc[k] = v
+	}
+	return sc
+}
+
+func (f *FakeHandler) Create(state px.OrderedMap) (px.OrderedMap, string, error) {
+	if f.CreateFunc != nil {
+		return f.CreateFunc(state)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.count++
+	id := fmt.Sprintf(`%s-%d`, f.typeName, f.count)
+	f.states[id] = state
+	return state, id, nil
+}
+
+func (f *FakeHandler) Read(externalID string) (px.OrderedMap, error) {
+	if f.ReadFunc != nil {
+		return f.ReadFunc(externalID)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if state, ok := f.states[externalID]; ok {
+		return state, nil
+	}
+	return nil, wf.NotFound
+}
+
+func (f *FakeHandler) Update(externalID string, state px.OrderedMap) (px.OrderedMap, error) {
+	if f.UpdateFunc != nil {
+		return f.UpdateFunc(externalID, state)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.states[externalID]; !ok {
+		return nil, wf.NotFound
+	}
+	f.states[externalID] = state
+	return state, nil
+}
+
+func (f *FakeHandler) Delete(externalID string) error {
+	if f.DeleteFunc != nil {
+		return f.DeleteFunc(externalID)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.states[externalID]; !ok {
+		return wf.NotFound
+	}
+	delete(f.states, externalID)
+	return nil
+}
+
+// call dispatches a call made through a Handler to the corresponding method. Errors are raised
+// as panics, just like errors from handlers declared in manifests.
+func (f *FakeHandler) call(method string, args []px.Value) px.Value {
+	var (
+		result px.Value
+		err    error
+	)
+	switch method {
+	case `create`:
+		var state px.OrderedMap
+		var id string
+		if state, id, err = f.Create(stateHash(args[0])); err == nil {
+			result = types.WrapValues([]px.Value{state, types.WrapString(id)})
+		}
+	case `read`:
+		var state px.OrderedMap
+		if state, err = f.Read(args[0].String()); err == nil {
+			result = state
+		}
+	case `update`:
+		var state px.OrderedMap
+		if state, err = f.Update(args[0].String(), stateHash(args[1])); err == nil {
+			result = state
+		}
+	case `delete`:
+		if err = f.Delete(args[0].String()); err == nil {
+			result = types.BooleanTrue
+		}
+	default:
+		err = fmt.Errorf(`fake handler for %s has no method %s`, f.typeName, method)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
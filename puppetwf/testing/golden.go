@@ -0,0 +1,183 @@
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	gotesting "testing"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+const (
+	// GoldenExtension is the extension of files that contain the expected definitions of a manifest.
+	GoldenExtension = `.golden`
+
+	// IssueExtension is the extension of files that contain the issue that is expected when a
+	// manifest is loaded.
+	IssueExtension = `.issue`
+)
+
+// Golden walks the given directory and runs one sub-test for each manifest found in it. Each
+// manifest is loaded in a service of its own and its definitions are compared with the contents of
+// the golden file next to it. When a manifest is expected to fail, the issue file next to it
+// records the code and the location of the expected issue.
+//
+// When update is true, the golden and issue files are written instead of compared.
+//
+// Files in the types directory of a module and test manifests ending with _test.pp are not
+// considered to be manifests. The module directory of a manifest is the closest directory, from the
+// directory of the manifest up to the given root, that has a types directory. The root is used when
+// no such directory exists.
+func Golden(t *gotesting.T, root string, update bool, options ...puppetwf.Option) {
+	var manifests []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == `types` {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, `.pp`) && !strings.HasSuffix(path, `_test.pp`) {
+			manifests = append(manifests, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range manifests {
+		path := path
+		t.Run(strings.TrimSuffix(path, `.pp`), func(t *gotesting.T) {
+			golden(t, root, path, update, options)
+		})
+	}
+}
+
+func golden(t *gotesting.T, root, path string, update bool, options []puppetwf.Option) {
+	base := strings.TrimSuffix(path, `.pp`)
+	goldenFile := base + GoldenExtension
+	issueFile := base + IssueExtension
+
+	var actual, actualFile, other string
+	Run(t, func(h *Harness) {
+		defs, err := loadDefinitions(h, root, path)
+		if err != nil {
+			actual = formatIssue(root, err)
+			actualFile, other = issueFile, goldenFile
+		} else {
+			actual = defs
+			actualFile, other = goldenFile, issueFile
+		}
+	}, options...)
+
+	if update {
+		if err := ioutil.WriteFile(actualFile, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if _, err := os.Stat(other); err == nil {
+		t.Fatalf(`expected %s to produce what is recorded in %s but got:%s`, path, other, indent(actual))
+	}
+	expected, err := ioutil.ReadFile(actualFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.Fatalf(`%s is missing, run the test with -update to create it`, actualFile)
+		}
+		t.Fatal(err)
+	}
+	if string(expected) != actual {
+		t.Errorf(`%s does not match %s, run the test with -update to update it%s`, path, actualFile, diff(string(expected), actual))
+	}
+}
+
+// loadDefinitions loads the manifest in the given file below the given root and returns its definitions in pretty
+// printed form, or the issue that was raised when the manifest was loaded.
+func loadDefinitions(h *Harness, root, path string) (defs string, err issue.Reported) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ri, ok := r.(issue.Reported); ok {
+				err = ri
+				return
+			}
+			panic(r)
+		}
+	}()
+	b := bytes.NewBufferString(``)
+	for _, def := range h.LoadModule(moduleDir(root, path), path).Metadata() {
+		b.WriteString(px.ToPrettyString(def))
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// moduleDir returns the closest directory from the directory of the given path up to the given root
+// that has a types directory, or the root when no such directory exists.
+func moduleDir(root, path string) string {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, `types`)); err == nil && info.IsDir() {
+			return dir
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return root
+		}
+	}
+}
+
+// formatIssue returns the code of the innermost issue in the chain of causes of the given issue
+// and the innermost location in a manifest, with the file relative to the given root.
+func formatIssue(root string, ri issue.Reported) string {
+	code := ri.Code()
+	loc := `unknown`
+	for err := error(ri); err != nil; {
+		r, ok := err.(issue.Reported)
+		if !ok {
+			break
+		}
+		code = r.Code()
+		if l := r.Location(); l != nil && l.File() != `` && !strings.HasSuffix(l.File(), `.go`) {
+			file := l.File()
+			if rel, err := filepath.Rel(root, file); err == nil {
+				file = filepath.ToSlash(rel)
+			}
+			loc = fmt.Sprintf(`%s:%d:%d`, file, l.Line(), l.Pos())
+		}
+		err = r.Cause()
+	}
+	return fmt.Sprintf("code: %s\nlocation: %s\n", code, loc)
+}
+
+func indent(s string) string {
+	return "\n\t" + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n\t", -1)
+}
+
+// diff describes the first line that differs between expected and actual.
+func diff(expected, actual string) string {
+	el := strings.Split(expected, "\n")
+	al := strings.Split(actual, "\n")
+	i := 0
+	for i < len(el) && i < len(al) && el[i] == al[i] {
+		i++
+	}
+	line := func(ls []string) string {
+		if i < len(ls) {
+			return ls[i]
+		}
+		return `<end of file>`
+	}
+	return fmt.Sprintf("\nfirst difference at line %d\n\texpected: %s\n\tactual:   %s", i+1, line(el), line(al))
+}
@@ -0,0 +1,345 @@
+// Package testing provides an in-process harness for unit tests of Puppet workflow manifests.
+//
+// The harness loads manifests using the same service that is used by the plug-in, but without
+// starting a separate process or using gRPC. Tests can invoke actions, resolve the state of
+// resources, register fake handlers for resource types, and make assertions on all calls that
+// have been made to handlers. Calls to state handlers declared in manifests and to memory handlers
+// are recorded also when they are made by the service itself. Calls to other handlers implemented
+// in Go are only recorded when they are made through a Handler.
+package testing
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	gotesting "testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/puppet-evaluator/pdsl"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// Harness is the entry point for tests. It is created by Run and is only valid during that run.
+type Harness struct {
+	t         gotesting.TB
+	ctx       pdsl.EvaluationContext
+	service   serviceapi.Service
+	lock      sync.Mutex
+	handlers  map[string]*Handler
+	manifests []*Manifest
+	calls     []Call
+}
+
+// Call describes one call to a handler method.
+type Call struct {
+	// Handler is the name of the handler. For fake handlers, this is the name of the resource type.
+	Handler string
+
+	// Method is one of create, read, update, or delete.
+	Method string
+
+	// Args are the arguments passed to the method.
+	Args []px.Value
+
+	// Result is the value returned by the method, or nil when the method failed.
+	Result px.Value
+}
+
+// Run creates an in-process service with the given options and calls the given function
+// with the harness. Failures are reported to the given test.
+func Run(t gotesting.TB, f func(h *Harness), options ...puppetwf.Option) {
+	h := &Harness{t: t, handlers: make(map[string]*Handler)}
+	options = append(options, puppetwf.WithHandlerObserver(h.observe))
+	puppetwf.WithService(`Puppet`, func(c pdsl.EvaluationContext, s serviceapi.Service) {
+		h.ctx = c
+		h.service = s
+		f(h)
+	}, options...)
+}
+
+// Do is like Run but it is intended for use outside of Go tests. Failures are raised as panics.
+func Do(f func(h *Harness), options ...puppetwf.Option) {
+	Run(nil, f, options...)
+}
+
+// Context returns the evaluation context of the service.
+func (h *Harness) Context() pdsl.EvaluationContext {
+	return h.ctx
+}
+
+// Load loads the manifest in the given file. The directory of the file is used as the module
+// directory.
+func (h *Harness) Load(path string) *Manifest {
+	return h.LoadModule(filepath.Dir(path), path)
+}
+
+// LoadModule loads the manifest in the given file using the given module directory. The types of
+// the module are found in its types directory.
+func (h *Harness) LoadModule(moduleDir, path string) *Manifest {
+	def := h.service.Invoke(h.ctx, puppetwf.ManifestLoaderID, `loadManifest`, types.WrapString(moduleDir), types.WrapString(path)).(serviceapi.Definition)
+	m := &Manifest{h: h, id: def.Identifier().Name()}
+	h.lock.Lock()
+	h.manifests = append(h.manifests, m)
+	h.lock.Unlock()
+	return m
+}
+
+// Fake registers a fake handler for the resource type with the given name and returns it. The
+// fake may be configured using its fields before it is used.
+func (h *Harness) Fake(typeName string) *FakeHandler {
+	f := NewFakeHandler(typeName)
+	h.Handle(typeName, &Handler{h: h, name: typeName, call: f.call})
+	return f
+}
+
+// Handle makes the given handler responsible for resources of the type with the given name.
+func (h *Harness) Handle(typeName string, handler *Handler) {
+	h.lock.Lock()
+	h.handlers[typeName] = handler
+	h.lock.Unlock()
+}
+
+// HandlerFor returns the handler that is responsible for resources of the type with the given
+// name. Handlers registered with the harness take precedence over handlers that the loaded
+// manifests register using registerHandler. The test fails if no handler is found.
+func (h *Harness) HandlerFor(typeName string) *Handler {
+	h.lock.Lock()
+	handler, ok := h.handlers[typeName]
+	ms := append([]*Manifest(nil), h.manifests...)
+	h.lock.Unlock()
+	if ok {
+		return handler
+	}
+	for _, m := range ms {
+		for _, def := range m.Metadata() {
+			if hf, ok := def.Properties().Get4(`handlerFor`); ok && hf.(px.Type).Name() == typeName {
+				return m.Handler(def.Identifier().Name())
+			}
+		}
+	}
+	h.fail(NoHandler, issue.H{`type`: typeName})
+	return nil
+}
+
+// Calls returns all calls that have been made to handlers, in the order they were made.
+func (h *Harness) Calls() []Call {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Call(nil), h.calls...)
+}
+
+// CallsTo returns the calls that have been made to the given method of the handler with the given
+// name. All methods match when method is empty.
+func (h *Harness) CallsTo(handler, method string) []Call {
+	var cs []Call
+	for _, c := range h.Calls() {
+		if c.Handler == handler && (method == `` || c.Method == method) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// Reset forgets all recorded calls.
+func (h *Harness) Reset() {
+	h.lock.Lock()
+	h.calls = nil
+	h.lock.Unlock()
+}
+
+// fail reports the given issue as a fatal error of the test, or raises it when the harness isn't
+// used by a Go test.
+func (h *Harness) fail(code issue.Code, args issue.H) {
+	err := px.Error(code, args)
+	if h.t == nil {
+		panic(err)
+	}
+	h.t.Helper()
+	h.t.Fatal(err.Error())
+}
+
+func (h *Harness) record(c *Call) {
+	h.lock.Lock()
+	h.calls = append(h.calls, *c)
+	h.lock.Unlock()
+}
+
+// observe records a call that the service made to a state handler.
+func (h *Harness) observe(handler, method string, args []px.Value, result px.Value) {
+	h.record(&Call{Handler: handler, Method: method, Args: args, Result: result})
+}
+
+// wrap converts the given Go value into a px.Value unless it already is one.
+func (h *Harness) wrap(v interface{}) px.Value {
+	if pv, ok := v.(px.Value); ok {
+		return pv
+	}
+	return px.Wrap(h.ctx, v)
+}
+
+func (h *Harness) wrapMap(m map[string]interface{}) px.OrderedMap {
+	if len(m) == 0 {
+		return px.EmptyMap
+	}
+	return h.wrap(m).(px.OrderedMap)
+}
+
+// Manifest is a manifest that has been loaded by the harness.
+type Manifest struct {
+	h  *Harness
+	id string
+}
+
+// Identifier returns the identifier of the API that represents the manifest in the service.
+func (m *Manifest) Identifier() string {
+	return m.id
+}
+
+// Metadata returns the definitions of the manifest.
+func (m *Manifest) Metadata() []serviceapi.Definition {
+	dl := m.h.service.Invoke(m.h.ctx, m.id, `metadata`).(px.List).At(1).(px.List)
+	defs := make([]serviceapi.Definition, dl.Len())
+	dl.EachWithIndex(func(v px.Value, i int) { defs[i] = v.(serviceapi.Definition) })
+	return defs
+}
+
+// Definition returns the definition of the step with the given name, searching steps nested in
+// workflows too. The test fails if no such step exists.
+func (m *Manifest) Definition(name string) serviceapi.Definition {
+	var find func(defs []serviceapi.Definition) serviceapi.Definition
+	find = func(defs []serviceapi.Definition) serviceapi.Definition {
+		for _, def := range defs {
+			if def.Identifier().Namespace() != px.NsService && strings.EqualFold(def.Identifier().Name(), name) {
+				return def
+			}
+			if found := find(steps(def)); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	def := find(m.Metadata())
+	if def == nil {
+		m.h.fail(NoSuchStep, issue.H{`manifest`: m.id, `name`: name})
+	}
+	return def
+}
+
+// Invoke calls the action with the given name using the given arguments and returns the result.
+func (m *Manifest) Invoke(step string, args map[string]interface{}) px.Value {
+	return m.call(step, `do`, m.h.wrapMap(args))
+}
+
+// State returns the state of the resource with the given name, resolved using the given parameters.
+func (m *Manifest) State(step string, params map[string]interface{}) px.PuppetObject {
+	return m.state(step, m.h.wrapMap(params))
+}
+
+func (m *Manifest) state(step string, params px.OrderedMap) px.PuppetObject {
+	return m.h.service.Invoke(m.h.ctx, m.id, `state`, types.WrapString(step), params).(px.PuppetObject)
+}
+
+// Apply resolves the state of the resource with the given name and creates it using the handler
+// that is registered for the type of the resource. It returns the created state and the external
+// id.
+func (m *Manifest) Apply(step string, params map[string]interface{}) (px.OrderedMap, string) {
+	st := m.State(step, params)
+	return m.h.HandlerFor(st.PType().Name()).Create(st)
+}
+
+// Handler returns the state handler step, or the handler registered using registerHandler, with
+// the given name. Calls to the returned handler are recorded by the harness.
+func (m *Manifest) Handler(name string) *Handler {
+	return &Handler{h: m.h, name: name, observed: true, call: func(method string, args []px.Value) px.Value {
+		return m.call(name, method, args...)
+	}}
+}
+
+func (m *Manifest) call(step, method string, args ...px.Value) px.Value {
+	iargs := make([]px.Value, 0, len(args)+2)
+	iargs = append(iargs, types.WrapString(apiName(step)), types.WrapString(method))
+	iargs = append(iargs, args...)
+	return m.h.service.Invoke(m.h.ctx, m.id, `invoke`, iargs...)
+}
+
+// Handler is a handler for a resource type. It is either a state handler step declared in a
+// manifest or a FakeHandler.
+type Handler struct {
+	h    *Harness
+	name string
+	call func(method string, args []px.Value) px.Value
+
+	// observed is true when the service notifies the harness of the calls to the handler.
+	observed bool
+}
+
+// Name returns the name that identifies the handler in recorded calls.
+func (h *Handler) Name() string {
+	return h.name
+}
+
+// Create calls the create method of the handler and returns the created state and its external id.
+func (h *Handler) Create(state px.Value) (px.OrderedMap, string) {
+	t := h.invoke(`create`, state).(px.List)
+	return stateHash(t.At(0)), t.At(1).String()
+}
+
+// Read calls the read method of the handler and returns the state with the given external id.
+func (h *Handler) Read(externalID string) px.OrderedMap {
+	return stateHash(h.invoke(`read`, types.WrapString(externalID)))
+}
+
+// Update calls the update method of the handler and returns the updated state.
+func (h *Handler) Update(externalID string, state px.Value) px.OrderedMap {
+	return stateHash(h.invoke(`update`, types.WrapString(externalID), state))
+}
+
+// Delete calls the delete method of the handler.
+func (h *Handler) Delete(externalID string) {
+	h.invoke(`delete`, types.WrapString(externalID))
+}
+
+func (h *Handler) invoke(method string, args ...px.Value) px.Value {
+	if h.observed {
+		return h.call(method, args)
+	}
+	c := &Call{Handler: h.name, Method: method, Args: args}
+	defer h.h.record(c)
+	c.Result = h.call(method, args)
+	return c.Result
+}
+
+// apiName returns the name of the API that represents the step with the given qualified name. It
+// is the name where the first letter of each segment is in upper case.
+func apiName(step string) string {
+	segments := strings.Split(step, `::`)
+	for i, s := range segments {
+		segments[i] = upperFirst(s)
+	}
+	return strings.Join(segments, `::`)
+}
+
+// upperFirst returns the given string with its first letter in upper case.
+func upperFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// stateHash returns the attributes of the given state.
+func stateHash(v px.Value) px.OrderedMap {
+	switch v := v.(type) {
+	case px.OrderedMap:
+		return v
+	case px.PuppetObject:
+		return v.InitHash()
+	}
+	return px.EmptyMap
+}
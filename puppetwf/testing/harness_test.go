@@ -0,0 +1,96 @@
+package testing_test
+
+import (
+	"testing"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+	"github.com/lyraproj/servicesdk/wf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoke(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/handler_example.pp`)
+		require.Equal(t, `action`, m.Definition(`handler_example::greet`).Properties().Get5(`style`, px.Undef).String())
+		result := m.Invoke(`handler_example::greet`, map[string]interface{}{`name`: `bob`})
+		require.True(t, px.SingletonMap(`greeting`, types.WrapString(`hello bob`)).Equals(result, nil))
+	})
+}
+
+func TestFakeHandler(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/handler_example.pp`)
+		fake := h.Fake(`Handler_example::Thing`)
+		state, id := m.Apply(`handler_example::thing`, map[string]interface{}{`name`: `one`})
+		require.Equal(t, `Handler_example::Thing-1`, id)
+		require.Equal(t, `one`, state.Get5(`name`, px.Undef).String())
+		require.Len(t, fake.States(), 1)
+
+		handler := h.HandlerFor(`Handler_example::Thing`)
+		require.Equal(t, state, handler.Read(id))
+		handler.Delete(id)
+		require.Panics(t, func() { handler.Read(id) })
+
+		calls := h.CallsTo(`Handler_example::Thing`, ``)
+		require.Len(t, calls, 4)
+		require.Equal(t, []string{`create`, `read`, `delete`, `read`}, []string{calls[0].Method, calls[1].Method, calls[2].Method, calls[3].Method})
+		require.Nil(t, calls[3].Result)
+		require.Len(t, h.CallsTo(`Handler_example::Thing`, `read`), 2)
+	})
+}
+
+func TestFakeHandlerFunc(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/handler_example.pp`)
+		fake := h.Fake(`Handler_example::Thing`)
+		fake.CreateFunc = func(state px.OrderedMap) (px.OrderedMap, string, error) {
+			return nil, ``, wf.NotFound
+		}
+		require.Panics(t, func() { m.Apply(`handler_example::thing`, map[string]interface{}{`name`: `one`}) })
+		require.Len(t, h.CallsTo(`Handler_example::Thing`, `create`), 1)
+	})
+}
+
+func TestManifestHandler(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`testdata/handler_example.pp`)
+		h.Handle(`Handler_example::Thing`, m.Handler(`thing_handler`))
+		state, id := m.Apply(`handler_example::thing`, map[string]interface{}{`name`: `two`})
+		require.Equal(t, `id-two`, id)
+		require.Equal(t, `id-two`, state.Get5(`id`, px.Undef).String())
+		require.Equal(t, `read`, h.HandlerFor(`Handler_example::Thing`).Read(`x`).Get5(`name`, px.Undef).String())
+
+		calls := h.CallsTo(`thing_handler`, `create`)
+		require.Len(t, calls, 1)
+		require.Equal(t, `two`, calls[0].Args[0].(px.PuppetObject).InitHash().Get5(`name`, px.Undef).String())
+	})
+}
+
+func TestRegisteredHandler(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`../testdata/memory_example.pp`)
+		result := m.Run(`memory_example`, map[string]interface{}{`name`: `web`})
+		require.Equal(t, `{'serverId' => 'server-1', 'address' => 'address-1'}`, result.String())
+
+		calls := h.CallsTo(`Memory_example::ServerMemoryHandler`, `create`)
+		require.Len(t, calls, 1)
+		state := h.HandlerFor(`Memory_example::Server`).Read(`server-1`)
+		require.Equal(t, `{'name' => 'web', 'size' => 'small', 'serverId' => 'server-1', 'address' => 'address-1', 'port' => 1, 'ready' => false}`, state.String())
+	})
+}
+
+func TestRunRejectsConditionalStep(t *testing.T) {
+	wftesting.Do(func(h *wftesting.Harness) {
+		m := h.Load(`testdata/conditional_example.pp`)
+		defer func() {
+			ri, ok := recover().(issue.Reported)
+			require.True(t, ok)
+			require.Equal(t, issue.Code(wftesting.UnsupportedCondition), ri.Code())
+			require.Empty(t, h.Calls())
+		}()
+		m.Run(`conditional_example`, map[string]interface{}{`ready`: true})
+	})
+}
@@ -0,0 +1,29 @@
+package testing
+
+import "github.com/lyraproj/issue/issue"
+
+const (
+	AssertionFailed      = `PUPPETWF_TEST_ASSERTION_FAILED`
+	InvalidDefinition    = `PUPPETWF_TEST_INVALID_DEFINITION`
+	InvalidMockResult    = `PUPPETWF_TEST_INVALID_MOCK_RESULT`
+	NoHandler            = `PUPPETWF_TEST_NO_HANDLER`
+	NoHarness            = `PUPPETWF_TEST_NO_HARNESS`
+	NoSuchStep           = `PUPPETWF_TEST_NO_SUCH_STEP`
+	NoSuchWorkflow       = `PUPPETWF_TEST_NO_SUCH_WORKFLOW`
+	UnresolvedParameter  = `PUPPETWF_TEST_UNRESOLVED_PARAMETER`
+	UnsupportedCondition = `PUPPETWF_TEST_UNSUPPORTED_CONDITION`
+	UnsupportedStyle     = `PUPPETWF_TEST_UNSUPPORTED_STYLE`
+)
+
+func init() {
+	issue.Hard(AssertionFailed, `%{message}`)
+	issue.Hard(InvalidDefinition, `property %{key} of definition %{name} must contain %{expected}, got %{actual}`)
+	issue.Hard(InvalidMockResult, `the block of mock_handler() for %{type} must return an Array with the created state and its external id, got %{actual}`)
+	issue.Hard(NoHandler, `no handler registered for type %{type}`)
+	issue.Hard(NoHarness, `%{function}() can only be called from a test`)
+	issue.Hard(NoSuchStep, `manifest %{manifest} has no step named %{name}`)
+	issue.Hard(NoSuchWorkflow, `unable to find a workflow named '%{name}'`)
+	issue.Hard(UnresolvedParameter, `parameter '%{name}' of %{step} is not produced by any step`)
+	issue.Hard(UnsupportedCondition, `%{step} runs when '%{when}' which cannot be evaluated in a test`)
+	issue.Hard(UnsupportedStyle, `%{step} is a step of style %{style} which cannot be run in a test`)
+}
@@ -0,0 +1,93 @@
+package testing
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteTAP writes the given results to the given writer using the Test Anything Protocol.
+func WriteTAP(w io.Writer, results []*TestResult) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		status := `ok`
+		if r.Failed() {
+			status = `not ok`
+		}
+		fmt.Fprintf(b, "%s %d - %s %s\n", status, i+1, r.File, r.Name)
+		if r.Failed() {
+			fmt.Fprintf(b, "  ---\n  message: %q\n  duration_ms: %.3f\n  ...\n", r.Failure, float64(r.Duration.Nanoseconds())/1e6)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type junitSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the given results to the given writer as JUnit XML. Each test manifest
+// becomes a test suite.
+func WriteJUnit(w io.Writer, results []*TestResult) error {
+	js := &junitSuites{}
+	suites := make(map[string]*junitSuite)
+	durations := make(map[string]time.Duration)
+	for _, r := range results {
+		s, ok := suites[r.File]
+		if !ok {
+			s = &junitSuite{Name: r.File}
+			suites[r.File] = s
+			js.Suites = append(js.Suites, s)
+		}
+		durations[r.File] += r.Duration
+		c := &junitCase{Name: r.Name, ClassName: r.File, Time: seconds(r.Duration)}
+		if r.Failed() {
+			c.Failure = &junitFailure{Message: strings.SplitN(r.Failure, "\n", 2)[0], Text: r.Failure}
+			s.Failures++
+		}
+		s.Tests++
+		s.Cases = append(s.Cases, c)
+	}
+	for _, s := range js.Suites {
+		s.Time = seconds(durations[s.Name])
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent(``, `  `)
+	if err := enc.Encode(js); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf(`%.3f`, d.Seconds())
+}
@@ -0,0 +1,246 @@
+package testing
+
+import (
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/servicesdk/serviceapi"
+)
+
+// RunWorkflow runs the workflow with the given name in any of the loaded manifests using the given
+// parameters and returns its returns.
+func (h *Harness) RunWorkflow(name string, params map[string]interface{}) px.OrderedMap {
+	m, def := h.workflow(name)
+	return m.runWorkflow(def, h.wrapMap(params))
+}
+
+// Run runs the workflow with the given name using the given parameters and returns its returns.
+//
+// The steps of the workflow run in-process, one at a time, in an order where each step runs when
+// all its parameters have been produced. Actions are invoked, the state of resources is created
+// using the handler registered for their type, and calls to other workflows run those workflows.
+//
+// This is not the workflow engine. The test fails before any step runs when the workflow has a
+// step that is conditional or iterated since such steps would not run the way the engine runs them.
+func (m *Manifest) Run(workflow string, params map[string]interface{}) px.OrderedMap {
+	return m.runWorkflow(m.Definition(workflow), m.h.wrapMap(params))
+}
+
+// workflow returns the top level workflow with the given name and the manifest that declares it.
+func (h *Harness) workflow(name string) (*Manifest, serviceapi.Definition) {
+	h.lock.Lock()
+	ms := append([]*Manifest(nil), h.manifests...)
+	h.lock.Unlock()
+	for _, m := range ms {
+		for _, def := range m.Metadata() {
+			if strings.EqualFold(def.Identifier().Name(), name) && style(def) == `workflow` {
+				return m, def
+			}
+		}
+	}
+	h.fail(NoSuchWorkflow, issue.H{`name`: name})
+	return nil, nil
+}
+
+func (m *Manifest) runWorkflow(def serviceapi.Definition, args px.OrderedMap) px.OrderedMap {
+	m.checkSupported(def)
+	scope := make(map[string]px.Value)
+	for _, p := range parameters(def, `parameters`) {
+		if v, ok := args.Get4(p.Name()); ok {
+			scope[p.Name()] = v
+		} else if v := m.defaultValue(p); v != nil {
+			scope[p.Name()] = v
+		}
+	}
+
+	pending := steps(def)
+	for len(pending) > 0 {
+		var waiting []serviceapi.Definition
+		for _, step := range pending {
+			input, ok := m.stepInput(step, scope, pending)
+			if !ok {
+				waiting = append(waiting, step)
+				continue
+			}
+			for k, v := range m.runStep(step, input) {
+				scope[k] = v
+			}
+		}
+		if len(waiting) == len(pending) {
+			// No step could run. Report the first parameter that nothing produces.
+			for _, step := range waiting {
+				for _, p := range parameters(step, `parameters`) {
+					if _, ok := scope[source(p)]; !ok {
+						m.h.fail(UnresolvedParameter, issue.H{`name`: source(p), `step`: step.Identifier().Name()})
+					}
+				}
+			}
+		}
+		pending = waiting
+	}
+
+	rs := make([]*types.HashEntry, 0)
+	for _, r := range parameters(def, `returns`) {
+		if v, ok := scope[source(r)]; ok {
+			rs = append(rs, types.WrapHashEntry2(r.Name(), v))
+		}
+	}
+	return types.WrapHash(rs)
+}
+
+// checkSupported fails the test if the given workflow, or a workflow nested in it, has a step that
+// runs conditionally or that is iterated.
+func (m *Manifest) checkSupported(def serviceapi.Definition) {
+	for _, step := range steps(def) {
+		if when, ok := step.Properties().Get4(`when`); ok {
+			m.h.fail(UnsupportedCondition, issue.H{`step`: step.Identifier().Name(), `when`: when.String()})
+		}
+		switch s := style(step); s {
+		case `action`, `resource`, `call`:
+		case `workflow`:
+			m.checkSupported(step)
+		default:
+			m.h.fail(UnsupportedStyle, issue.H{`step`: step.Identifier().Name(), `style`: s})
+		}
+	}
+}
+
+// stepInput returns the arguments for the given step from the given scope. It returns false if the
+// step must wait for a value that is produced by another pending step.
+func (m *Manifest) stepInput(step serviceapi.Definition, scope map[string]px.Value, pending []serviceapi.Definition) (map[string]px.Value, bool) {
+	input := make(map[string]px.Value)
+	for _, p := range parameters(step, `parameters`) {
+		if v, ok := scope[source(p)]; ok {
+			input[p.Name()] = v
+			continue
+		}
+		if producedBy(source(p), step, pending) {
+			return nil, false
+		}
+		if v := m.defaultValue(p); v != nil {
+			input[p.Name()] = v
+		} else if !px.IsInstance(p.Type(), px.Undef) {
+			return nil, false
+		}
+	}
+	return input, true
+}
+
+// runStep runs the given step and returns the values that it produces, keyed by the names that
+// they are known by in the workflow.
+func (m *Manifest) runStep(step serviceapi.Definition, input map[string]px.Value) map[string]px.Value {
+	name := step.Identifier().Name()
+	args := types.WrapStringToValueMap(input)
+	var result px.OrderedMap
+	switch s := style(step); s {
+	case `action`:
+		result = stateHash(m.call(name, `do`, args))
+	case `resource`:
+		st := m.state(name, args)
+		result, _ = m.h.HandlerFor(st.PType().Name()).Create(st)
+	case `workflow`:
+		return hashToMap(m.runWorkflow(step, args))
+	case `call`:
+		cm, cd := m.h.workflow(step.Properties().Get5(`call`, px.Undef).String())
+		result = cm.runWorkflow(cd, args)
+	default:
+		m.h.fail(UnsupportedStyle, issue.H{`step`: name, `style`: s})
+	}
+
+	out := make(map[string]px.Value)
+	for _, r := range parameters(step, `returns`) {
+		if v, ok := result.Get4(source(r)); ok {
+			out[r.Name()] = v
+		}
+	}
+	return out
+}
+
+// defaultValue returns the value of the given parameter with Deferred values resolved, or nil when
+// the parameter has no value.
+func (m *Manifest) defaultValue(p serviceapi.Parameter) px.Value {
+	v := p.Value()
+	if v == nil {
+		return nil
+	}
+	if d, ok := v.(types.Deferred); ok {
+		return types.ResolveDeferred(m.h.ctx, d, m.h.ctx.Scope())
+	}
+	return v
+}
+
+// producedBy returns true if a step other than the given step among the pending steps returns the
+// value with the given name.
+func producedBy(name string, step serviceapi.Definition, pending []serviceapi.Definition) bool {
+	for _, other := range pending {
+		if other == step {
+			continue
+		}
+		for _, r := range parameters(other, `returns`) {
+			if r.Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// source returns the name of the value that the given parameter is bound to.
+func source(p serviceapi.Parameter) string {
+	if a := p.Alias(); a != `` {
+		return a
+	}
+	return p.Name()
+}
+
+// parameters returns the parameters held by the property with the given key of the given definition.
+func parameters(def serviceapi.Definition, key string) []serviceapi.Parameter {
+	var ps []serviceapi.Parameter
+	for _, v := range list(def, key) {
+		p, ok := v.(serviceapi.Parameter)
+		if !ok {
+			panic(px.Error(InvalidDefinition, issue.H{`name`: def.Identifier().Name(), `key`: key, `expected`: `Parameter`, `actual`: px.DetailedValueType(v)}))
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// steps returns the steps of the given workflow definition.
+func steps(def serviceapi.Definition) []serviceapi.Definition {
+	var ss []serviceapi.Definition
+	for _, v := range list(def, `steps`) {
+		s, ok := v.(serviceapi.Definition)
+		if !ok {
+			panic(px.Error(InvalidDefinition, issue.H{`name`: def.Identifier().Name(), `key`: `steps`, `expected`: `Definition`, `actual`: px.DetailedValueType(v)}))
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// list returns the elements of the Array held by the property with the given key of the given
+// definition, or nil when the definition has no such property.
+func list(def serviceapi.Definition, key string) []px.Value {
+	v, ok := def.Properties().Get4(key)
+	if !ok {
+		return nil
+	}
+	l, ok := v.(px.List)
+	if !ok {
+		panic(px.Error(InvalidDefinition, issue.H{`name`: def.Identifier().Name(), `key`: key, `expected`: `Array`, `actual`: px.DetailedValueType(v)}))
+	}
+	return l.AppendTo(make([]px.Value, 0, l.Len()))
+}
+
+func style(def serviceapi.Definition) string {
+	return def.Properties().Get5(`style`, px.Undef).String()
+}
+
+func hashToMap(h px.OrderedMap) map[string]px.Value {
+	m := make(map[string]px.Value, h.Len())
+	h.EachPair(func(k, v px.Value) { m[k.String()] = v })
+	return m
+}
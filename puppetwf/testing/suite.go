@@ -0,0 +1,142 @@
+package testing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-evaluator/evaluator"
+	"github.com/lyraproj/puppet-parser/parser"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+)
+
+// TestSuffix is the suffix of manifests that contain tests written in Puppet. The tests in the
+// manifest x_test.pp test the manifest x.pp in the same directory.
+const TestSuffix = `_test.pp`
+
+// TestResult is the outcome of one test written in Puppet.
+type TestResult struct {
+	// File is the test manifest that declares the test.
+	File string
+
+	// Name is the name of the test function.
+	Name string
+
+	// Duration is the time it took to run the test.
+	Duration time.Duration
+
+	// Failure is the reason why the test failed, or the empty string when it passed.
+	Failure string
+}
+
+// Failed returns true if the test failed.
+func (r *TestResult) Failed() bool {
+	return r.Failure != ``
+}
+
+// FindTests returns the test manifests among the given paths. Directories are searched
+// recursively.
+func FindTests(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, TestSuffix) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// RunTests runs the tests in the given test manifest. Each function in the manifest whose name,
+// without namespace, starts with test_ is a test. The tests run one at a time in the order they
+// are declared. Handlers and recorded calls are reset before each test.
+//
+// The following functions are available to the tests:
+//
+//	mock_handler(type)                     registers an in-memory handler for the type. An optional
+//	                                       block receives the state and returns [state, externalId]
+//	run_workflow(name, parameters)         runs the workflow and returns its returns
+//	handler_calls(type, method)            returns the calls made to the handler of the type, each
+//	                                       call a Hash with the keys method, args, and result
+//	assert_equal(expected, actual, msg)    fails the test unless expected equals actual
+//	assert_true(value, msg)                fails the test unless value is true
+func RunTests(file string, options ...puppetwf.Option) (results []*TestResult) {
+	Do(func(h *Harness) {
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				results = append(results, &TestResult{File: file, Name: file, Duration: time.Since(start), Failure: failure(r)})
+			}
+		}()
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		manifest := strings.TrimSuffix(file, TestSuffix) + `.pp`
+		if _, err := os.Stat(manifest); err == nil {
+			h.Load(manifest)
+		}
+
+		ec := evaluator.WithParent(h.ctx, evaluator.NewEvaluator)
+		ec.Set(HarnessKey, h)
+		ast := ec.ParseAndValidate(file, string(content), false)
+		ec.AddDefinitions(ast)
+		ec.ResolveDefinitions()
+
+		for _, d := range ast.(*parser.Program).Definitions() {
+			fd, ok := d.(*parser.FunctionDefinition)
+			if !ok || !isTest(fd.Name()) {
+				continue
+			}
+			results = append(results, runTest(h, ec, file, fd))
+		}
+	}, options...)
+	return
+}
+
+func runTest(h *Harness, c px.Context, file string, fd *parser.FunctionDefinition) (result *TestResult) {
+	h.lock.Lock()
+	h.handlers = make(map[string]*Handler)
+	h.calls = nil
+	h.lock.Unlock()
+
+	result = &TestResult{File: file, Name: fd.Name()}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		if r := recover(); r != nil {
+			result.Failure = failure(r)
+		}
+	}()
+	fn := evaluator.NewPuppetFunction(fd)
+	fn.Resolve(c)
+	fn.Call(c, nil)
+	return
+}
+
+func isTest(name string) bool {
+	if i := strings.LastIndex(name, `::`); i >= 0 {
+		name = name[i+2:]
+	}
+	return strings.HasPrefix(name, `test_`)
+}
+
+func failure(r interface{}) string {
+	if err, ok := r.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprint(r)
+}
@@ -0,0 +1,45 @@
+package testing_test
+
+import (
+	"bytes"
+	"testing"
+
+	wftesting "github.com/lyraproj/puppet-workflow/puppetwf/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTests(t *testing.T) {
+	files, err := wftesting.FindTests(`testdata`)
+	require.NoError(t, err)
+	require.Equal(t, []string{`testdata/handler_example_test.pp`}, files)
+
+	results := wftesting.RunTests(files[0])
+	require.Len(t, results, 4)
+	require.Equal(t, `handler_example::test_run`, results[0].Name)
+	require.False(t, results[0].Failed(), results[0].Failure)
+	require.False(t, results[1].Failed(), results[1].Failure)
+	require.True(t, results[2].Failed())
+	require.Contains(t, results[2].Failure, `greeting: expected 'hello ann', got 'hello bob'`)
+	require.Contains(t, results[2].Failure, `line: 23`)
+	require.True(t, results[3].Failed())
+	require.Contains(t, results[3].Failure, `the block of mock_handler() for Handler_example::Thing must return an Array`)
+	require.Contains(t, results[3].Failure, `line: 31`)
+
+	b := bytes.NewBufferString(``)
+	require.NoError(t, wftesting.WriteTAP(b, results))
+	require.Contains(t, b.String(), "1..4\nok 1 - testdata/handler_example_test.pp handler_example::test_run\n")
+	require.Contains(t, b.String(), "not ok 3 - testdata/handler_example_test.pp handler_example::test_failure\n")
+
+	b.Reset()
+	require.NoError(t, wftesting.WriteJUnit(b, results))
+	require.Contains(t, b.String(), `<testsuite name="testdata/handler_example_test.pp" tests="4" failures="2"`)
+	require.Contains(t, b.String(), `<testcase name="handler_example::test_failure" classname="testdata/handler_example_test.pp"`)
+}
+
+func TestRunWorkflow(t *testing.T) {
+	wftesting.Run(t, func(h *wftesting.Harness) {
+		m := h.Load(`../testdata/call_example.pp`)
+		result := m.Run(`call_example`, map[string]interface{}{`net`: `n1`})
+		require.Equal(t, `{'result' => 'n1-attached'}`, result.String())
+	})
+}
@@ -0,0 +1,12 @@
+workflow conditional_example {
+  parameters => (Boolean $ready),
+  returns => (String $greeting)
+} {
+  action greet {
+    parameters => ($ready),
+    returns => (String $greeting),
+    when => 'ready'
+  } {
+    return({ greeting => 'hello' })
+  }
+}
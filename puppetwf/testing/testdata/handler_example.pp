@@ -0,0 +1,41 @@
+type Handler_example::Thing = Object[{
+  attributes => {
+    name => String,
+    id => Optional[String]
+  }
+}]
+
+workflow handler_example {
+  parameters => (String $name),
+  returns => (String $id, String $greeting)
+} {
+  resource thing {
+    parameters => ($name),
+    returns => ($id),
+    type => Handler_example::Thing
+  } {
+    name => $name
+  }
+
+  action greet {
+    parameters => ($name),
+    returns => (String $greeting)
+  } {
+    return({ greeting => "hello ${name}" })
+  }
+}
+
+stateHandler thing_handler {
+} {
+  function create($state) {
+    [Handler_example::Thing(name => $state.name, id => "id-${state.name}"), "id-${state.name}"]
+  }
+
+  function read($id) {
+    Handler_example::Thing(name => 'read', id => $id)
+  }
+
+  function delete($id) {
+    true
+  }
+}
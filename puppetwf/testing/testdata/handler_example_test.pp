@@ -0,0 +1,35 @@
+function handler_example::test_run() {
+  mock_handler('Handler_example::Thing')
+  $result = run_workflow('handler_example', { name => 'bob' })
+  assert_equal('hello bob', $result['greeting'])
+
+  $calls = handler_calls('Handler_example::Thing')
+  assert_equal(['create'], $calls.map |$c| { $c['method'] }, 'methods called')
+  assert_equal('bob', $calls[0]['args'][0]['name'])
+  assert_equal('Handler_example::Thing-1', $calls[0]['result'][1])
+}
+
+function handler_example::test_custom_create() {
+  mock_handler(Handler_example::Thing) |$state| {
+    [$state + { id => "custom-${state['name']}" }, "custom-${state['name']}"]
+  }
+  $result = run_workflow('handler_example', { name => 'ann' })
+  assert_equal('custom-ann', $result['id'])
+}
+
+function handler_example::test_failure() {
+  mock_handler('Handler_example::Thing')
+  $result = run_workflow('handler_example', { name => 'bob' })
+  assert_equal('hello ann', $result['greeting'], 'greeting')
+}
+
+function handler_example::helper() {
+  fail('not a test')
+}
+
+function handler_example::test_invalid_mock() {
+  mock_handler(Handler_example::Thing) |$state| {
+    $state
+  }
+  run_workflow('handler_example', { name => 'bob' })
+}
@@ -0,0 +1,184 @@
+package puppetwf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+)
+
+const (
+	LoggerKey       = `Puppet::Logger`
+	SpanExporterKey = `Puppet::SpanExporter`
+
+	stepLoggerKey = `Puppet::StepLogger`
+	spanKey       = `Puppet::Span`
+)
+
+// Span describes one invocation of a step method. The fields follow the OpenTelemetry span model.
+type Span struct {
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentSpanId,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"startTime"`
+	End        time.Time              `json:"endTime"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// SpanExporter receives each span when it ends.
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+type fileExporter struct {
+	lock sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileExporter returns an exporter that appends each span as one line of JSON to the file
+// with the given path. The exporter implements io.Closer and the file is closed when the service
+// ends.
+func NewFileExporter(path string) SpanExporter {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		panic(px.Error(TraceFileWriteFailed, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	return &fileExporter{file: f, enc: json.NewEncoder(f)}
+}
+
+func (e *fileExporter) Export(span *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		hclog.Default().Warn(`unable to export span`, `error`, err)
+	}
+}
+
+func (e *fileExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.file.Close()
+}
+
+// WithLogger makes the given logger the parent of all step loggers. The default is hclog.Default().
+func WithLogger(log hclog.Logger) Option {
+	return func(c px.Context) {
+		c.Set(LoggerKey, log)
+	}
+}
+
+// WithSpanExporter makes each invocation of a step method produce a span that is exported using
+// the given exporter.
+func WithSpanExporter(e SpanExporter) Option {
+	return func(c px.Context) {
+		c.Set(SpanExporterKey, e)
+	}
+}
+
+// WithTraceFile makes each invocation of a step method produce a span that is appended to the
+// file with the given path.
+func WithTraceFile(path string) Option {
+	return WithSpanExporter(NewFileExporter(path))
+}
+
+// StepLogger returns the logger of the step that is currently running in the given context, or
+// the service logger when no step is running.
+func StepLogger(c px.Context) hclog.Logger {
+	if v, ok := c.Get(stepLoggerKey); ok {
+		return v.(hclog.Logger)
+	}
+	if v, ok := c.Get(LoggerKey); ok {
+		return v.(hclog.Logger)
+	}
+	return hclog.Default()
+}
+
+// observe starts the logging and tracing of a call to the given method of a step. The returned
+// function must be deferred. It logs the outcome of the call and ends the span. The given context
+// will use a logger that carries the workflow, step, style, and invocation id until the call ends.
+func observe(c px.Context, step, style, method string) func() {
+	id := newID(8)
+	log := StepLogger(c).With(`workflow`, workflowName(step), `step`, step, `style`, style, `invocation`, id)
+	prevLog, hadLog := c.Get(stepLoggerKey)
+	c.Set(stepLoggerKey, log)
+
+	var span *Span
+	prevSpan, hadSpan := c.Get(spanKey)
+	exporter, tracing := c.Get(SpanExporterKey)
+	if tracing {
+		span = &Span{
+			SpanID:     id,
+			Name:       step + `.` + method,
+			Start:      time.Now(),
+			Attributes: map[string]interface{}{`workflow`: workflowName(step), `step`: step, `style`: style, `method`: method},
+			Status:     `OK`,
+		}
+		if hadSpan {
+			parent := prevSpan.(*Span)
+			span.TraceID = parent.TraceID
+			span.ParentID = parent.SpanID
+		} else {
+			span.TraceID = newID(16)
+		}
+		c.Set(spanKey, span)
+	}
+
+	log.Debug(`call`, `method`, method)
+	start := time.Now()
+	return func() {
+		r := recover()
+		if r != nil {
+			log.Debug(`call failed`, `method`, method, `duration`, time.Since(start), `error`, r)
+		} else {
+			log.Debug(`call done`, `method`, method, `duration`, time.Since(start))
+		}
+
+		restore(c, stepLoggerKey, prevLog, hadLog)
+		if tracing {
+			span.End = time.Now()
+			if r != nil {
+				span.Status = `ERROR`
+				span.Error = fmt.Sprint(r)
+			}
+			exporter.(SpanExporter).Export(span)
+			restore(c, spanKey, prevSpan, hadSpan)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}
+}
+
+func restore(c px.Context, key string, value interface{}, had bool) {
+	if had {
+		c.Set(key, value)
+	} else {
+		c.Delete(key)
+	}
+}
+
+// workflowName returns the name of the top level workflow of the step with the given name.
+func workflowName(step string) string {
+	if i := strings.Index(step, `::`); i > 0 {
+		return step[:i]
+	}
+	return step
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,125 @@
+package puppetwf
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/lyraproj/issue/issue"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/pcore/types"
+	"github.com/lyraproj/semver/semver"
+	"github.com/lyraproj/servicesdk/lang/typegen"
+	"github.com/lyraproj/servicesdk/service"
+)
+
+// ResourceTag is the struct tag that lists an attribute of a resource in the Lyra::Resource
+// annotation of the generated type. Its value is immutable, provided, or both separated by a comma:
+//
+//	type Subnet struct {
+//		VpcID    string
+//		Tags     map[string]string `lyra:"immutable"`
+//		SubnetID *string           `lyra:"provided"`
+//	}
+const ResourceTag = `lyra`
+
+// Resource is a Go struct that is generated as a resource type, together with the struct that
+// handles it.
+type Resource struct {
+	// Type is a pointer to the zero value of the resource struct.
+	Type interface{}
+
+	// Handler is a pointer to the zero value of the handler struct, or nil. The generated handler
+	// type is named after the struct and has a function for each of its exported methods.
+	Handler interface{}
+}
+
+// GenerateTypeSet returns a TypeSet with the given name and version that contains a type for each
+// of the given values. A value is either a Resource or a value that is accepted by the
+// RegisterTypes method of a service.Builder. The types are created by the same reflection that a
+// Go service uses when it registers them, so a manifest that uses the TypeSet declares the same
+// types as the service.
+func GenerateTypeSet(c px.Context, name string, version semver.Version, values ...interface{}) px.TypeSet {
+	sb := service.NewServiceBuilder(c, name)
+	for _, v := range values {
+		r, ok := v.(Resource)
+		if !ok {
+			sb.RegisterTypes(name, v)
+			continue
+		}
+		t := sb.RegisterTypes(name, resourceType(c, sb, r.Type))[0]
+		if r.Handler != nil {
+			sb.RegisterHandler(name+`::`+handlerName(r.Handler), r.Handler, t)
+		}
+	}
+	ts, _ := sb.Server().Metadata(c)
+	if version == nil || version.Equals(ts.Version()) {
+		return ts
+	}
+	ts = types.NewTypeSet(ts.NameAuthority(), ts.Name(), ts.(px.PuppetObject).InitHash().Merge(px.SingletonMap(types.KeyVersion, types.WrapSemVer(version))))
+	ts.(px.ResolvableType).Resolve(c)
+	return ts
+}
+
+// ReadTypeSet returns the TypeSet that is declared in the given .pp file, such as a file written
+// by WriteTypeSet.
+func ReadTypeSet(c px.Context, path string) px.TypeSet {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(px.Error(px.UnableToReadFile, issue.H{`path`: path, `detail`: err.Error()}))
+	}
+	if ts, ok := types.ParseFile(path, string(content)).(px.TypeSet); ok {
+		px.AddTypes(c, ts)
+		return ts
+	}
+	panic(px.Error(NotATypeSet, issue.H{`path`: path}))
+}
+
+// WriteTypeSet writes the given TypeSet to a .pp file that is named after it in the given
+// directory, so that it is found by manifests that use that directory as their types directory.
+func WriteTypeSet(ts px.TypeSet, dir string) {
+	typegen.GetGenerator(`puppet`).GenerateTypes(ts, dir)
+}
+
+// resourceType returns the resource struct annotated with the attributes that are tagged with
+// the ResourceTag.
+func resourceType(c px.Context, sb *service.Builder, goType interface{}) interface{} {
+	rt := reflect.TypeOf(goType)
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return goType
+	}
+
+	var immutable, provided []string
+	for _, f := range c.Reflector().Fields(rt) {
+		for _, kind := range strings.Split(f.Tag.Get(ResourceTag), `,`) {
+			switch strings.TrimSpace(kind) {
+			case `immutable`:
+				immutable = append(immutable, c.Reflector().FieldName(&f))
+			case `provided`:
+				provided = append(provided, c.Reflector().FieldName(&f))
+			}
+		}
+	}
+	if immutable == nil && provided == nil {
+		return goType
+	}
+	return sb.BuildResource(goType, func(rb service.ResourceTypeBuilder) {
+		if immutable != nil {
+			rb.ImmutableAttributes(immutable...)
+		}
+		if provided != nil {
+			rb.ProvidedAttributes(provided...)
+		}
+	})
+}
+
+func handlerName(handler interface{}) string {
+	rt := reflect.TypeOf(handler)
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt.Name()
+}
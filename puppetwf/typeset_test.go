@@ -0,0 +1,119 @@
+package puppetwf_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lyraproj/pcore/pcore"
+	"github.com/lyraproj/pcore/px"
+	"github.com/lyraproj/puppet-workflow/puppetwf"
+	"github.com/lyraproj/semver/semver"
+	"github.com/stretchr/testify/require"
+)
+
+type Subnet struct {
+	VpcId            string
+	CidrBlock        string
+	AvailabilityZone *string           `lyra:"provided"`
+	Tags             map[string]string `lyra:"immutable"`
+	SubnetId         *string           `lyra:"provided"`
+}
+
+type SubnetHandler struct{}
+
+func (h *SubnetHandler) Create(desired *Subnet) (*Subnet, string, error) {
+	return desired, ``, nil
+}
+
+func (h *SubnetHandler) Read(externalID string) (*Subnet, error) {
+	return nil, nil
+}
+
+func (h *SubnetHandler) Delete(externalID string) error {
+	return nil
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+func TestGenerateTypeSet(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `typeset`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pcore.Do(func(c px.Context) {
+		ts := puppetwf.GenerateTypeSet(c, `Aws`, semver.MustParseVersion(`1.2.0`),
+			puppetwf.Resource{Type: &Subnet{}, Handler: &SubnetHandler{}}, &Tag{})
+		puppetwf.WriteTypeSet(ts, dir)
+	})
+	content, err := ioutil.ReadFile(filepath.Join(dir, `Aws.pp`))
+	require.NoError(t, err)
+	require.Equal(t, `# this file is generated
+type Aws = TypeSet[{
+  pcore_uri => 'http://puppet.com/2016.1/pcore',
+  pcore_version => '1.0.0',
+  name_authority => 'http://puppet.com/2016.1/runtime',
+  name => 'Aws',
+  version => '1.2.0',
+  types => {
+    Subnet => {
+      annotations => {
+        Lyra::Resource => {
+          'immutableAttributes' => ['tags'],
+          'providedAttributes' => ['availabilityZone', 'subnetId']
+        }
+      },
+      attributes => {
+        'vpcId' => String,
+        'cidrBlock' => String,
+        'availabilityZone' => {
+          'annotations' => {
+            TagsAnnotation => {
+              'lyra' => 'provided'
+            }
+          },
+          'type' => Optional[String]
+        },
+        'tags' => {
+          'annotations' => {
+            TagsAnnotation => {
+              'lyra' => 'immutable'
+            }
+          },
+          'type' => Hash[String, String]
+        },
+        'subnetId' => {
+          'annotations' => {
+            TagsAnnotation => {
+              'lyra' => 'provided'
+            }
+          },
+          'type' => Optional[String]
+        }
+      }
+    },
+    SubnetHandler => {
+      functions => {
+        'create' => Callable[
+          [Optional[Subnet]],
+          Tuple[Optional[Subnet], String]],
+        'delete' => Callable[String],
+        'read' => Callable[
+          [String],
+          Optional[Subnet]]
+      }
+    },
+    Tag => {
+      attributes => {
+        'key' => String,
+        'value' => String
+      }
+    }
+  }
+}]
+`, string(content))
+}